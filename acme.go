@@ -0,0 +1,1239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACME resource statuses (RFC 8555 §7.1.6).
+const (
+	acmeStatusPending     = "pending"
+	acmeStatusProcessing  = "processing"
+	acmeStatusReady       = "ready"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusExpired     = "expired"
+	acmeStatusDeactivated = "deactivated"
+)
+
+// acmeLockFile is held by "ca acme serve" for as long as it runs. The server keeps
+// acme.json in memory and rewrites it whole, so a second server on the same data
+// directory would overwrite the first one's accounts, orders and authorizations.
+const acmeLockFile = ".acme.lock"
+
+// acmeOrderLifetime bounds how long an order and its authorizations stay usable.
+const acmeOrderLifetime = 7 * 24 * time.Hour
+
+// acmeAccount is a registered ACME account, identified by its key's JWK thumbprint.
+type acmeAccount struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Contact    []string        `json:"contact,omitempty"`
+	Key        json.RawMessage `json:"key"`
+	Thumbprint string          `json:"thumbprint"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	ID             string           `json:"id"`
+	AccountID      string           `json:"account_id"`
+	Status         string           `json:"status"`
+	Expires        string           `json:"expires"`
+	Identifiers    []acmeIdentifier `json:"identifiers"`
+	Authorizations []string         `json:"authorizations"`   // authorization IDs
+	Serial         string           `json:"serial,omitempty"` // issued certificate, once valid
+	Error          *acmeProblem     `json:"error,omitempty"`
+}
+
+type acmeChallenge struct {
+	ID        string       `json:"id"`
+	Type      string       `json:"type"`
+	Token     string       `json:"token"`
+	Status    string       `json:"status"`
+	Validated string       `json:"validated,omitempty"`
+	Error     *acmeProblem `json:"error,omitempty"`
+}
+
+type acmeAuthz struct {
+	ID         string          `json:"id"`
+	AccountID  string          `json:"account_id"`
+	Identifier acmeIdentifier  `json:"identifier"` // without the "*." of a wildcard
+	Wildcard   bool            `json:"wildcard,omitempty"`
+	Status     string          `json:"status"`
+	Expires    string          `json:"expires"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+// acmeState is the persistent ACME database, stored as acme.json next to the certificate index.
+type acmeState struct {
+	Accounts       map[string]*acmeAccount `json:"accounts"`
+	Orders         map[string]*acmeOrder   `json:"orders"`
+	Authorizations map[string]*acmeAuthz   `json:"authorizations"`
+}
+
+// acmeProblem is an RFC 7807 problem document with an ACME error type (RFC 8555 §6.7).
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *acmeProblem) Error() string {
+	return p.Detail
+}
+
+// acmeError builds a problem of the given ACME error type, e.g. "malformed".
+func acmeError(status int, errType string, format string, args ...interface{}) *acmeProblem {
+	return &acmeProblem{Type: "urn:ietf:params:acme:error:" + errType, Detail: fmt.Sprintf(format, args...), Status: status}
+}
+
+// ACMEServer implements the RFC 8555 server endpoints under /acme/ on top of SignCSR
+// and RevokeCert. Accounts, orders and authorizations persist in acme.json.
+type ACMEServer struct {
+	dataDir      string
+	passphrase   PassphraseFunc
+	profile      string
+	validityDays int
+	http01Port   int
+	resolver     *net.Resolver
+
+	// mu serializes request handling and guards state; challenge validation
+	// does its network I/O without it.
+	mu     sync.Mutex
+	state  *acmeState
+	unlock func() // releases acmeLockFile
+
+	nonceMu sync.Mutex
+	nonces  map[string]bool
+}
+
+// maxNonces caps the outstanding nonces; older ones are dropped and fail as badNonce.
+const maxNonces = 10000
+
+// NewACMEServer loads the ACME state of the CA in dataDir. Certificates are issued under
+// profile for validityDays (0: the profile default). http-01 challenges are fetched from http01Port on the identifier; dns-01
+// TXT records are looked up through dnsResolver (host:port) or the system resolver if empty.
+// The server holds acmeLockFile until Close, and fails if another server holds it.
+// Enforces CON-INV-004: CA initialization prerequisite
+func NewACMEServer(dataDir string, passphrase PassphraseFunc, profile string, validityDays int, http01Port int, dnsResolver string) (*ACMEServer, error) {
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	profiles, err := LoadProfiles(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	p, err := lookupProfile(profiles, profile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.validityDays(profile, validityDays); err != nil {
+		return nil, err
+	}
+
+	// acme.json is read once and then owned by this server
+	unlock, err := tryLock(filepath.Join(dataDir, acmeLockFile))
+	if errors.Is(err, errLockHeld) {
+		return nil, newCAError(KindConflict, "Error: another ACME server is already running on %s", dataDir)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to lock ACME state: %w", err)
+	}
+	state, err := loadACMEState(dataDir)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	resolver := net.DefaultResolver
+	if dnsResolver != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, dnsResolver)
+			},
+		}
+	}
+
+	return &ACMEServer{
+		dataDir:      dataDir,
+		passphrase:   passphrase,
+		profile:      profile,
+		validityDays: validityDays,
+		http01Port:   http01Port,
+		resolver:     resolver,
+		state:        state,
+		unlock:       unlock,
+		nonces:       make(map[string]bool),
+	}, nil
+}
+
+// Close releases the ACME state for another server.
+func (s *ACMEServer) Close() {
+	s.unlock()
+}
+
+// loadACMEState reads acme.json, returning an empty state if it does not exist yet.
+func loadACMEState(dataDir string) (*acmeState, error) {
+	state := &acmeState{
+		Accounts:       map[string]*acmeAccount{},
+		Orders:         map[string]*acmeOrder{},
+		Authorizations: map[string]*acmeAuthz{},
+	}
+	data, err := os.ReadFile(filepath.Join(dataDir, "acme.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("failed to read ACME state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse acme.json: %w", err)
+	}
+	return state, nil
+}
+
+// save persists the state. Callers hold s.mu; the process holds acmeLockFile.
+// Enforces CON-DI-004: atomic file replacement (ADR-006)
+func (s *ACMEServer) save() *acmeProblem {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return acmeError(http.StatusInternalServerError, "serverInternal", "failed to marshal ACME state")
+	}
+	data = append(data, '\n')
+	if err := writeFileAtomic(filepath.Join(s.dataDir, "acme.json"), data, 0644); err != nil {
+		log.Printf("acme: %v", err)
+		return acmeError(http.StatusInternalServerError, "serverInternal", "failed to save ACME state")
+	}
+	return nil
+}
+
+// randomID returns n random bytes as base64url.
+func randomID(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return b64(buf)
+}
+
+func (s *ACMEServer) newNonce() string {
+	nonce := randomID(16)
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+	if len(s.nonces) >= maxNonces {
+		for n := range s.nonces {
+			delete(s.nonces, n)
+			break
+		}
+	}
+	s.nonces[nonce] = true
+	return nonce
+}
+
+// useNonce consumes nonce, reporting whether it was outstanding.
+func (s *ACMEServer) useNonce(nonce string) bool {
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+	if !s.nonces[nonce] {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return true
+}
+
+// baseURL returns the scheme and host the client used to reach the server.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// acmeRequest is a POST whose JWS has been verified.
+type acmeRequest struct {
+	payload    []byte
+	postAsGet  bool         // empty payload (RFC 8555 §6.3)
+	account    *acmeAccount // set when signed with a kid
+	jwk        json.RawMessage
+	key        crypto.PublicKey
+	thumbprint string
+}
+
+// jwsKeyMode says which key reference a resource accepts in the protected header.
+type jwsKeyMode int
+
+const (
+	jwsKid    jwsKeyMode = iota // existing account via "kid"
+	jwsJWK                      // embedded "jwk" (newAccount)
+	jwsEither                   // revokeCert accepts both
+)
+
+// authenticate verifies the JWS body of r: nonce, url, algorithm, key and signature.
+// Callers hold s.mu.
+func (s *ACMEServer) authenticate(r *http.Request, mode jwsKeyMode) (*acmeRequest, *acmeProblem) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, acmeError(http.StatusUnsupportedMediaType, "malformed", "Content-Type must be application/jose+json")
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "failed to read request body")
+	}
+
+	var msg jwsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "request body is not a flattened JWS")
+	}
+	headerJSON, err := b64Decode(msg.Protected)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "protected header is not base64url")
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "protected header is not valid JSON")
+	}
+
+	if !s.useNonce(header.Nonce) {
+		return nil, acmeError(http.StatusBadRequest, "badNonce", "invalid or reused anti-replay nonce")
+	}
+	if header.URL != baseURL(r)+r.URL.Path {
+		return nil, acmeError(http.StatusUnauthorized, "unauthorized", "JWS url %q does not match the request URL", header.URL)
+	}
+	if header.Alg != "ES256" && header.Alg != "ES384" && header.Alg != "RS256" {
+		return nil, acmeError(http.StatusBadRequest, "badSignatureAlgorithm", "unsupported JWS algorithm %q (supported: ES256, ES384, RS256)", header.Alg)
+	}
+
+	req := &acmeRequest{}
+	switch {
+	case len(header.JWK) > 0 && header.Kid == "" && mode != jwsKid:
+		req.jwk = header.JWK
+		req.key, req.thumbprint, err = parseJWK(header.JWK)
+		if err != nil {
+			return nil, acmeError(http.StatusBadRequest, "badPublicKey", "%v", err)
+		}
+	case header.Kid != "" && len(header.JWK) == 0 && mode != jwsJWK:
+		prefix := baseURL(r) + "/acme/acct/"
+		account := s.state.Accounts[strings.TrimPrefix(header.Kid, prefix)]
+		if !strings.HasPrefix(header.Kid, prefix) || account == nil {
+			return nil, acmeError(http.StatusBadRequest, "accountDoesNotExist", "unknown account %q", header.Kid)
+		}
+		if account.Status != acmeStatusValid {
+			return nil, acmeError(http.StatusUnauthorized, "unauthorized", "account is %s", account.Status)
+		}
+		req.account = account
+		req.key, req.thumbprint, err = parseJWK(account.Key)
+		if err != nil {
+			return nil, acmeError(http.StatusInternalServerError, "serverInternal", "stored account key is invalid")
+		}
+	case mode == jwsJWK:
+		return nil, acmeError(http.StatusBadRequest, "malformed", "this resource requires a jwk and no kid in the protected header")
+	case mode == jwsKid:
+		return nil, acmeError(http.StatusBadRequest, "malformed", "this resource requires a kid and no jwk in the protected header")
+	default:
+		return nil, acmeError(http.StatusBadRequest, "malformed", "protected header must carry exactly one of jwk and kid")
+	}
+
+	sig, err := b64Decode(msg.Signature)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "signature is not base64url")
+	}
+	if err := verifyJWS(header.Alg, req.key, []byte(msg.Protected+"."+msg.Payload), sig); err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "%v", err)
+	}
+
+	req.payload, err = b64Decode(msg.Payload)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "payload is not base64url")
+	}
+	req.postAsGet = msg.Payload == ""
+	return req, nil
+}
+
+// decodePayload parses a JSON payload into v.
+func (req *acmeRequest) decodePayload(v interface{}) *acmeProblem {
+	if err := json.Unmarshal(req.payload, v); err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "invalid JSON payload: %v", err)
+	}
+	return nil
+}
+
+func writeACME(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeProblem(w http.ResponseWriter, p *acmeProblem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(p)
+}
+
+// ServeHTTP routes the ACME resources. The directory and nonce endpoints accept GET;
+// every other resource is POST (or POST-as-GET) with a JWS body.
+func (s *ACMEServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Link", "<"+base+"/acme/directory>;rel=\"index\"")
+	w.Header().Set("Cache-Control", "no-store")
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/acme"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "directory" && r.Method == http.MethodGet:
+		writeACME(w, http.StatusOK, map[string]string{
+			"newNonce":   base + "/acme/new-nonce",
+			"newAccount": base + "/acme/new-account",
+			"newOrder":   base + "/acme/new-order",
+			"revokeCert": base + "/acme/revoke-cert",
+		})
+		return
+	case path == "new-nonce" && (r.Method == http.MethodHead || r.Method == http.MethodGet):
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	case r.Method != http.MethodPost:
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, acmeError(http.StatusMethodNotAllowed, "malformed", "method %s not allowed", r.Method))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p *acmeProblem
+	switch {
+	case path == "new-account":
+		p = s.newAccount(w, r)
+	case path == "new-order":
+		p = s.newOrder(w, r)
+	case path == "revoke-cert":
+		p = s.revokeCert(w, r)
+	case len(parts) == 2 && parts[0] == "acct":
+		p = s.updateAccount(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "acct" && parts[2] == "orders":
+		p = s.listOrders(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "order":
+		p = s.getOrder(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "order" && parts[2] == "finalize":
+		p = s.finalize(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "authz":
+		p = s.getAuthz(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "chall":
+		p = s.respondChallenge(w, r, parts[1], parts[2])
+	case len(parts) == 2 && parts[0] == "cert":
+		p = s.getCertChain(w, r, parts[1])
+	default:
+		p = acmeError(http.StatusNotFound, "malformed", "no such resource")
+	}
+	if p != nil {
+		writeProblem(w, p)
+		log.Printf("acme: %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, p.Detail)
+		return
+	}
+	log.Printf("acme: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+}
+
+// accountJSON renders an account object (RFC 8555 §7.1.2).
+func accountJSON(base string, a *acmeAccount) interface{} {
+	return struct {
+		Status  string   `json:"status"`
+		Contact []string `json:"contact,omitempty"`
+		Orders  string   `json:"orders"`
+	}{a.Status, a.Contact, base + "/acme/acct/" + a.ID + "/orders"}
+}
+
+// validateContacts accepts only mailto: URLs.
+func validateContacts(contacts []string) *acmeProblem {
+	for _, c := range contacts {
+		if !strings.HasPrefix(c, "mailto:") || len(c) == len("mailto:") {
+			return acmeError(http.StatusBadRequest, "unsupportedContact", "contact %q is not a mailto: URL", c)
+		}
+	}
+	return nil
+}
+
+// newAccount handles POST /acme/new-account (RFC 8555 §7.3).
+func (s *ACMEServer) newAccount(w http.ResponseWriter, r *http.Request) *acmeProblem {
+	req, p := s.authenticate(r, jwsJWK)
+	if p != nil {
+		return p
+	}
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		return p
+	}
+
+	base := baseURL(r)
+	for _, a := range s.state.Accounts {
+		if a.Thumbprint == req.thumbprint {
+			w.Header().Set("Location", base+"/acme/acct/"+a.ID)
+			writeACME(w, http.StatusOK, accountJSON(base, a))
+			return nil
+		}
+	}
+	if payload.OnlyReturnExisting {
+		return acmeError(http.StatusBadRequest, "accountDoesNotExist", "no account exists for this key")
+	}
+	if p := validateContacts(payload.Contact); p != nil {
+		return p
+	}
+
+	account := &acmeAccount{
+		ID:         randomID(12),
+		Status:     acmeStatusValid,
+		Contact:    payload.Contact,
+		Key:        req.jwk,
+		Thumbprint: req.thumbprint,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339), // CON-DI-003
+	}
+	s.state.Accounts[account.ID] = account
+	if p := s.save(); p != nil {
+		delete(s.state.Accounts, account.ID)
+		return p
+	}
+	w.Header().Set("Location", base+"/acme/acct/"+account.ID)
+	writeACME(w, http.StatusCreated, accountJSON(base, account))
+	return nil
+}
+
+// updateAccount handles POST /acme/acct/{id}: fetch, contact update or deactivation (RFC 8555 §7.3.2, §7.3.6).
+func (s *ACMEServer) updateAccount(w http.ResponseWriter, r *http.Request, id string) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	if req.account.ID != id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "request is not signed by this account")
+	}
+	account := req.account
+
+	if !req.postAsGet {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if p := req.decodePayload(&payload); p != nil {
+			return p
+		}
+		if payload.Status != "" && payload.Status != acmeStatusDeactivated {
+			return acmeError(http.StatusBadRequest, "malformed", "account status can only be changed to deactivated")
+		}
+		if payload.Contact != nil {
+			if p := validateContacts(payload.Contact); p != nil {
+				return p
+			}
+		}
+
+		prev := *account
+		if payload.Contact != nil {
+			account.Contact = payload.Contact
+		}
+		if payload.Status == acmeStatusDeactivated {
+			account.Status = acmeStatusDeactivated
+		}
+		if p := s.save(); p != nil {
+			*account = prev
+			return p
+		}
+	}
+	writeACME(w, http.StatusOK, accountJSON(baseURL(r), account))
+	return nil
+}
+
+// listOrders handles POST-as-GET /acme/acct/{id}/orders (RFC 8555 §7.1.2.1).
+func (s *ACMEServer) listOrders(w http.ResponseWriter, r *http.Request, id string) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	if req.account.ID != id {
+		return acmeError(http.StatusUnauthorized, "unauthorized", "request is not signed by this account")
+	}
+	base := baseURL(r)
+	urls := []string{}
+	for _, o := range s.state.Orders {
+		if o.AccountID == id {
+			urls = append(urls, base+"/acme/order/"+o.ID)
+		}
+	}
+	sort.Strings(urls)
+	writeACME(w, http.StatusOK, struct {
+		Orders []string `json:"orders"`
+	}{urls})
+	return nil
+}
+
+// normalizeIdentifier validates an order identifier and returns its canonical form.
+// DNS names may carry a single leading "*." wildcard label; IP identifiers follow RFC 8738.
+func normalizeIdentifier(id acmeIdentifier) (acmeIdentifier, *acmeProblem) {
+	switch id.Type {
+	case "dns":
+		name := strings.ToLower(strings.TrimSuffix(id.Value, "."))
+		host := strings.TrimPrefix(name, "*.")
+		if host == "" || len(host) > 253 || net.ParseIP(host) != nil {
+			return id, acmeError(http.StatusBadRequest, "rejectedIdentifier", "invalid DNS identifier %q", id.Value)
+		}
+		for _, label := range strings.Split(host, ".") {
+			if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+				return id, acmeError(http.StatusBadRequest, "rejectedIdentifier", "invalid DNS identifier %q", id.Value)
+			}
+			for _, c := range label {
+				if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+					return id, acmeError(http.StatusBadRequest, "rejectedIdentifier", "invalid DNS identifier %q", id.Value)
+				}
+			}
+		}
+		return acmeIdentifier{Type: "dns", Value: name}, nil
+	case "ip":
+		ip := net.ParseIP(id.Value)
+		if ip == nil {
+			return id, acmeError(http.StatusBadRequest, "rejectedIdentifier", "invalid IP identifier %q", id.Value)
+		}
+		return acmeIdentifier{Type: "ip", Value: ip.String()}, nil
+	default:
+		return id, acmeError(http.StatusBadRequest, "unsupportedIdentifier", "identifier type %q is not supported (supported: dns, ip)", id.Type)
+	}
+}
+
+// newOrder handles POST /acme/new-order (RFC 8555 §7.4), creating one authorization per identifier.
+func (s *ACMEServer) newOrder(w http.ResponseWriter, r *http.Request) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+		NotBefore   string           `json:"notBefore"`
+		NotAfter    string           `json:"notAfter"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		return p
+	}
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		return acmeError(http.StatusBadRequest, "malformed", "notBefore and notAfter are not supported; validity is set by the server")
+	}
+	if len(payload.Identifiers) == 0 || len(payload.Identifiers) > 100 {
+		return acmeError(http.StatusBadRequest, "malformed", "an order needs between 1 and 100 identifiers")
+	}
+
+	seen := map[acmeIdentifier]bool{}
+	var identifiers []acmeIdentifier
+	for _, raw := range payload.Identifiers {
+		id, p := normalizeIdentifier(raw)
+		if p != nil {
+			return p
+		}
+		if !seen[id] {
+			seen[id] = true
+			identifiers = append(identifiers, id)
+		}
+	}
+
+	// Refuse names the issuance policy would reject at finalization before any
+	// challenge is offered for them.
+	policy, err := LoadPolicy(s.dataDir)
+	if err != nil {
+		log.Printf("acme: new order: %v", err)
+		return acmeError(http.StatusInternalServerError, "serverInternal", "%s", strings.TrimPrefix(err.Error(), "Error: "))
+	}
+	if policy != nil {
+		for _, id := range identifiers {
+			if c := policy.checkName(id.Type, id.Value); !c.Passed {
+				return acmeError(http.StatusBadRequest, "rejectedIdentifier", "%s is not permitted by the issuance policy: %s", id.Value, c.Reason)
+			}
+		}
+	}
+
+	expires := time.Now().UTC().Add(acmeOrderLifetime).Format(time.RFC3339)
+	order := &acmeOrder{
+		ID:          randomID(12),
+		AccountID:   req.account.ID,
+		Status:      acmeStatusPending,
+		Expires:     expires,
+		Identifiers: identifiers,
+	}
+	var authzs []*acmeAuthz
+	for _, id := range identifiers {
+		authz := &acmeAuthz{
+			ID:         randomID(12),
+			AccountID:  req.account.ID,
+			Identifier: id,
+			Status:     acmeStatusPending,
+			Expires:    expires,
+		}
+		// Wildcards can only be proven through DNS; IP addresses have no DNS zone to prove.
+		var types []string
+		switch {
+		case id.Type == "ip":
+			types = []string{"http-01"}
+		case strings.HasPrefix(id.Value, "*."):
+			authz.Identifier.Value = strings.TrimPrefix(id.Value, "*.")
+			authz.Wildcard = true
+			types = []string{"dns-01"}
+		default:
+			types = []string{"http-01", "dns-01"}
+		}
+		for _, t := range types {
+			authz.Challenges = append(authz.Challenges, acmeChallenge{
+				ID:     randomID(8),
+				Type:   t,
+				Token:  randomID(32),
+				Status: acmeStatusPending,
+			})
+		}
+		authzs = append(authzs, authz)
+		order.Authorizations = append(order.Authorizations, authz.ID)
+	}
+
+	for _, a := range authzs {
+		s.state.Authorizations[a.ID] = a
+	}
+	s.state.Orders[order.ID] = order
+	if p := s.save(); p != nil {
+		for _, a := range authzs {
+			delete(s.state.Authorizations, a.ID)
+		}
+		delete(s.state.Orders, order.ID)
+		return p
+	}
+
+	base := baseURL(r)
+	w.Header().Set("Location", base+"/acme/order/"+order.ID)
+	writeACME(w, http.StatusCreated, s.orderJSON(base, order))
+	return nil
+}
+
+// refreshAuthz expires a pending authorization past its deadline.
+func refreshAuthz(a *acmeAuthz, now time.Time) {
+	expires, _ := time.Parse(time.RFC3339, a.Expires)
+	if a.Status == acmeStatusPending && now.After(expires) {
+		a.Status = acmeStatusExpired
+	}
+}
+
+// refreshOrder derives an order's status from its authorizations and expiry (RFC 8555 §7.1.6).
+func (s *ACMEServer) refreshOrder(o *acmeOrder) {
+	now := time.Now().UTC()
+	if o.Status == acmeStatusPending {
+		allValid := true
+		for _, id := range o.Authorizations {
+			a := s.state.Authorizations[id]
+			if a == nil {
+				o.Status = acmeStatusInvalid
+				return
+			}
+			refreshAuthz(a, now)
+			switch a.Status {
+			case acmeStatusValid:
+			case acmeStatusPending:
+				allValid = false
+			default:
+				o.Status = acmeStatusInvalid
+				o.Error = acmeError(http.StatusForbidden, "unauthorized", "authorization for %s is %s", a.Identifier.Value, a.Status)
+				return
+			}
+		}
+		if allValid {
+			o.Status = acmeStatusReady
+		}
+	}
+	expires, _ := time.Parse(time.RFC3339, o.Expires)
+	if (o.Status == acmeStatusPending || o.Status == acmeStatusReady) && now.After(expires) {
+		o.Status = acmeStatusInvalid
+	}
+}
+
+// orderJSON renders an order object (RFC 8555 §7.1.3).
+func (s *ACMEServer) orderJSON(base string, o *acmeOrder) interface{} {
+	authzURLs := make([]string, len(o.Authorizations))
+	for i, id := range o.Authorizations {
+		authzURLs[i] = base + "/acme/authz/" + id
+	}
+	var certURL string
+	if o.Serial != "" {
+		certURL = base + "/acme/cert/" + o.Serial
+	}
+	return struct {
+		Status         string           `json:"status"`
+		Expires        string           `json:"expires"`
+		Identifiers    []acmeIdentifier `json:"identifiers"`
+		Authorizations []string         `json:"authorizations"`
+		Finalize       string           `json:"finalize"`
+		Certificate    string           `json:"certificate,omitempty"`
+		Error          *acmeProblem     `json:"error,omitempty"`
+	}{o.Status, o.Expires, o.Identifiers, authzURLs, base + "/acme/order/" + o.ID + "/finalize", certURL, o.Error}
+}
+
+// ownedOrder looks up an order belonging to the requesting account.
+func (s *ACMEServer) ownedOrder(req *acmeRequest, id string) (*acmeOrder, *acmeProblem) {
+	o := s.state.Orders[id]
+	if o == nil {
+		return nil, acmeError(http.StatusNotFound, "malformed", "no such order")
+	}
+	if o.AccountID != req.account.ID {
+		return nil, acmeError(http.StatusUnauthorized, "unauthorized", "order belongs to another account")
+	}
+	return o, nil
+}
+
+// getOrder handles POST-as-GET /acme/order/{id}.
+func (s *ACMEServer) getOrder(w http.ResponseWriter, r *http.Request, id string) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	o, p := s.ownedOrder(req, id)
+	if p != nil {
+		return p
+	}
+	s.refreshOrder(o)
+	writeACME(w, http.StatusOK, s.orderJSON(baseURL(r), o))
+	return nil
+}
+
+// csrMatchesOrder checks that the CSR requests exactly the order's identifiers (RFC 8555 §7.4).
+func csrMatchesOrder(csr *x509.CertificateRequest, o *acmeOrder) *acmeProblem {
+	// Orders only hold dns and ip identifiers; other names would be issued unvalidated
+	if len(csr.EmailAddresses) > 0 {
+		return acmeError(http.StatusBadRequest, "badCSR", "CSR requests email:%s, which is not an identifier of this order", csr.EmailAddresses[0])
+	}
+	if len(csr.URIs) > 0 {
+		return acmeError(http.StatusBadRequest, "badCSR", "CSR requests uri:%s, which is not an identifier of this order", csr.URIs[0])
+	}
+	want := map[string]bool{}
+	for _, id := range o.Identifiers {
+		want[id.Type+":"+id.Value] = true
+	}
+	got := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		got["dns:"+strings.ToLower(name)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		got["ip:"+ip.String()] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		key := "dns:" + strings.ToLower(cn)
+		if ip := net.ParseIP(cn); ip != nil {
+			key = "ip:" + ip.String()
+		}
+		if !want[key] {
+			return acmeError(http.StatusBadRequest, "badCSR", "CSR common name %q is not an identifier of this order", cn)
+		}
+		got[key] = true
+	}
+	if len(got) != len(want) {
+		return acmeError(http.StatusBadRequest, "badCSR", "CSR names do not match the order identifiers")
+	}
+	for k := range got {
+		if !want[k] {
+			return acmeError(http.StatusBadRequest, "badCSR", "CSR requests %s, which is not an identifier of this order", k)
+		}
+	}
+	return nil
+}
+
+// finalize handles POST /acme/order/{id}/finalize: issues the certificate through SignCSR (RFC 8555 §7.4).
+// Enforces CON-DI-004: the order is only marked valid after SignCSR committed the certificate
+func (s *ACMEServer) finalize(w http.ResponseWriter, r *http.Request, id string) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	o, p := s.ownedOrder(req, id)
+	if p != nil {
+		return p
+	}
+	s.refreshOrder(o)
+	if o.Status != acmeStatusReady {
+		return acmeError(http.StatusForbidden, "orderNotReady", "order is %s, not ready", o.Status)
+	}
+
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		return p
+	}
+	der, err := b64Decode(payload.CSR)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "csr is not base64url DER")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "badCSR", "failed to parse CSR")
+	}
+	if p := csrMatchesOrder(csr, o); p != nil {
+		return p
+	}
+
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	result, err := SignCSR(s.dataDir, csrPEM, "ACME order "+o.ID, s.profile, s.validityDays, s.passphrase)
+	if err != nil {
+		detail := strings.TrimPrefix(err.Error(), "Error: ")
+		if errorKind(err) == KindInvalidInput {
+			return acmeError(http.StatusBadRequest, "badCSR", "%s", detail)
+		}
+		log.Printf("acme: finalize order %s: %v", o.ID, err)
+		return acmeError(http.StatusInternalServerError, "serverInternal", "%s", detail)
+	}
+
+	o.Status = acmeStatusValid
+	o.Serial = result.Serial
+	if p := s.save(); p != nil {
+		return p
+	}
+	base := baseURL(r)
+	w.Header().Set("Location", base+"/acme/order/"+o.ID)
+	writeACME(w, http.StatusOK, s.orderJSON(base, o))
+	return nil
+}
+
+// acmeChallengeJSON is the wire form of a challenge object (RFC 8555 §7.1.5).
+type acmeChallengeJSON struct {
+	Type      string       `json:"type"`
+	URL       string       `json:"url"`
+	Token     string       `json:"token"`
+	Status    string       `json:"status"`
+	Validated string       `json:"validated,omitempty"`
+	Error     *acmeProblem `json:"error,omitempty"`
+}
+
+func challengeJSON(base string, a *acmeAuthz, c acmeChallenge) acmeChallengeJSON {
+	return acmeChallengeJSON{c.Type, base + "/acme/chall/" + a.ID + "/" + c.ID, c.Token, c.Status, c.Validated, c.Error}
+}
+
+// authzJSON renders an authorization object (RFC 8555 §7.1.4).
+func authzJSON(base string, a *acmeAuthz) interface{} {
+	challenges := make([]acmeChallengeJSON, len(a.Challenges))
+	for i, c := range a.Challenges {
+		challenges[i] = challengeJSON(base, a, c)
+	}
+	return struct {
+		Identifier acmeIdentifier      `json:"identifier"`
+		Status     string              `json:"status"`
+		Expires    string              `json:"expires"`
+		Challenges []acmeChallengeJSON `json:"challenges"`
+		Wildcard   bool                `json:"wildcard,omitempty"`
+	}{a.Identifier, a.Status, a.Expires, challenges, a.Wildcard}
+}
+
+// ownedAuthz looks up an authorization belonging to the requesting account.
+func (s *ACMEServer) ownedAuthz(req *acmeRequest, id string) (*acmeAuthz, *acmeProblem) {
+	a := s.state.Authorizations[id]
+	if a == nil {
+		return nil, acmeError(http.StatusNotFound, "malformed", "no such authorization")
+	}
+	if a.AccountID != req.account.ID {
+		return nil, acmeError(http.StatusUnauthorized, "unauthorized", "authorization belongs to another account")
+	}
+	return a, nil
+}
+
+// getAuthz handles POST-as-GET /acme/authz/{id}.
+func (s *ACMEServer) getAuthz(w http.ResponseWriter, r *http.Request, id string) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	a, p := s.ownedAuthz(req, id)
+	if p != nil {
+		return p
+	}
+	refreshAuthz(a, time.Now().UTC())
+	writeACME(w, http.StatusOK, authzJSON(baseURL(r), a))
+	return nil
+}
+
+// respondChallenge handles POST /acme/chall/{authz}/{id}: the client asks the server to
+// validate. Validation runs in the background; clients poll the authorization (RFC 8555 §7.5.1).
+func (s *ACMEServer) respondChallenge(w http.ResponseWriter, r *http.Request, authzID string, chalID string) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	a, p := s.ownedAuthz(req, authzID)
+	if p != nil {
+		return p
+	}
+	var chal *acmeChallenge
+	for i := range a.Challenges {
+		if a.Challenges[i].ID == chalID {
+			chal = &a.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return acmeError(http.StatusNotFound, "malformed", "no such challenge")
+	}
+
+	refreshAuthz(a, time.Now().UTC())
+	if !req.postAsGet && chal.Status == acmeStatusPending && a.Status == acmeStatusPending {
+		chal.Status = acmeStatusProcessing
+		if p := s.save(); p != nil {
+			chal.Status = acmeStatusPending
+			return p
+		}
+		keyAuth := chal.Token + "." + req.thumbprint
+		go s.validateChallenge(a.ID, chal.ID, a.Identifier, chal.Type, chal.Token, keyAuth)
+	}
+
+	base := baseURL(r)
+	w.Header().Set("Link", "<"+base+"/acme/authz/"+a.ID+">;rel=\"up\"")
+	writeACME(w, http.StatusOK, challengeJSON(base, a, *chal))
+	return nil
+}
+
+// validateChallenge performs an http-01 or dns-01 check and records the outcome.
+// A failed challenge invalidates the whole authorization (RFC 8555 §7.1.6). The
+// authorization only moves while it is pending: once another challenge has settled
+// it, a late result is recorded on its own challenge and neither invalidates nor
+// revives the authorization.
+func (s *ACMEServer) validateChallenge(authzID string, chalID string, id acmeIdentifier, chalType string, token string, keyAuth string) {
+	var problem *acmeProblem
+	switch chalType {
+	case "http-01":
+		problem = s.checkHTTP01(id, token, keyAuth)
+	case "dns-01":
+		problem = s.checkDNS01(id, keyAuth)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.state.Authorizations[authzID]
+	if a == nil {
+		return
+	}
+	for i := range a.Challenges {
+		c := &a.Challenges[i]
+		if c.ID != chalID {
+			continue
+		}
+		if c.Status != acmeStatusProcessing {
+			return
+		}
+		if problem == nil {
+			c.Status = acmeStatusValid
+			c.Validated = time.Now().UTC().Format(time.RFC3339)
+		} else {
+			c.Status = acmeStatusInvalid
+			c.Error = problem
+		}
+		if a.Status == acmeStatusPending {
+			a.Status = c.Status
+		} else {
+			log.Printf("acme: %s for %s finished after the authorization became %s; it stays %s", chalType, id.Value, a.Status, a.Status)
+		}
+	}
+	if p := s.save(); p != nil {
+		log.Printf("acme: failed to record %s result for %s", chalType, id.Value)
+		return
+	}
+	if problem != nil {
+		log.Printf("acme: %s for %s failed: %s", chalType, id.Value, problem.Detail)
+	} else {
+		log.Printf("acme: %s for %s succeeded", chalType, id.Value)
+	}
+}
+
+// checkHTTP01 fetches http://<identifier>:<port>/.well-known/acme-challenge/<token> (RFC 8555 §8.3).
+func (s *ACMEServer) checkHTTP01(id acmeIdentifier, token string, keyAuth string) *acmeProblem {
+	host := id.Value
+	if s.http01Port != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(s.http01Port))
+	} else if id.Type == "ip" && strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	url := "http://" + host + "/.well-known/acme-challenge/" + token
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "connection", "fetching %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return acmeError(http.StatusForbidden, "unauthorized", "fetching %s: HTTP %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 8192))
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "connection", "reading %s: %v", url, err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(body), []byte(keyAuth)) {
+		return acmeError(http.StatusForbidden, "incorrectResponse", "key authorization at %s does not match", url)
+	}
+	return nil
+}
+
+// checkDNS01 looks for the key authorization digest in the _acme-challenge TXT record (RFC 8555 §8.4).
+func (s *ACMEServer) checkDNS01(id acmeIdentifier, keyAuth string) *acmeProblem {
+	name := "_acme-challenge." + id.Value
+	sum := sha256.Sum256([]byte(keyAuth))
+	want := b64(sum[:])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	records, err := s.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "dns", "looking up TXT %s: %v", name, err)
+	}
+	for _, txt := range records {
+		if txt == want {
+			return nil
+		}
+	}
+	return acmeError(http.StatusForbidden, "incorrectResponse", "no TXT record at %s matches the key authorization", name)
+}
+
+// getCertChain handles POST-as-GET /acme/cert/{serial}: the leaf followed by the issuing
+// chain, excluding the self-signed root (RFC 8555 §7.4.2).
+func (s *ACMEServer) getCertChain(w http.ResponseWriter, r *http.Request, serial string) *acmeProblem {
+	req, p := s.authenticate(r, jwsKid)
+	if p != nil {
+		return p
+	}
+	owned := false
+	for _, o := range s.state.Orders {
+		if o.Serial == serial && o.AccountID == req.account.ID {
+			owned = true
+		}
+	}
+	if !owned {
+		return acmeError(http.StatusNotFound, "malformed", "no certificate %s for this account", serial)
+	}
+
+	leaf, err := os.ReadFile(filepath.Join(s.dataDir, "certs", serial+".pem"))
+	if err != nil {
+		return acmeError(http.StatusInternalServerError, "serverInternal", "failed to read certificate %s", serial)
+	}
+	caCert, err := LoadCertificate(filepath.Join(s.dataDir, "ca.crt"))
+	if err != nil {
+		return acmeError(http.StatusInternalServerError, "serverInternal", "failed to load CA certificate")
+	}
+	chain, err := LoadChain(s.dataDir)
+	if err != nil {
+		return acmeError(http.StatusInternalServerError, "serverInternal", "failed to load CA chain")
+	}
+
+	out := append([]byte{}, leaf...)
+	for _, c := range append([]*x509.Certificate{caCert}, chain...) {
+		if bytes.Equal(c.RawSubject, c.RawIssuer) {
+			continue
+		}
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(out)
+	return nil
+}
+
+// revokeCert handles POST /acme/revoke-cert (RFC 8555 §7.6). The request is authorized
+// when signed by the account that ordered the certificate or by the certificate's own key.
+func (s *ACMEServer) revokeCert(w http.ResponseWriter, r *http.Request) *acmeProblem {
+	req, p := s.authenticate(r, jwsEither)
+	if p != nil {
+		return p
+	}
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      *int   `json:"reason"`
+	}
+	if p := req.decodePayload(&payload); p != nil {
+		return p
+	}
+	der, err := b64Decode(payload.Certificate)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "certificate is not base64url DER")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return acmeError(http.StatusBadRequest, "malformed", "failed to parse certificate")
+	}
+	gens, err := LoadGenerations(s.dataDir)
+	if err != nil {
+		return acmeError(http.StatusInternalServerError, "serverInternal", "failed to load CA certificate")
+	}
+	if generationOf(gens, cert) == nil { // certificates of a retired generation can still be revoked
+		return acmeError(http.StatusNotFound, "malformed", "certificate was not issued by this CA")
+	}
+	serial := FormatSerialBig(cert.SerialNumber)
+
+	if req.account != nil {
+		owned := false
+		for _, o := range s.state.Orders {
+			if o.Serial == serial && o.AccountID == req.account.ID {
+				owned = true
+			}
+		}
+		if !owned {
+			return acmeError(http.StatusForbidden, "unauthorized", "account did not order certificate %s", serial)
+		}
+	} else {
+		keyDER, err := x509.MarshalPKIXPublicKey(req.key)
+		if err != nil || !bytes.Equal(keyDER, cert.RawSubjectPublicKeyInfo) {
+			return acmeError(http.StatusForbidden, "unauthorized", "request is not signed by the certificate's key")
+		}
+	}
+
+	reason := "unspecified"
+	if payload.Reason != nil {
+		reason = ""
+		for name, code := range ReasonCodes {
+			if code == *payload.Reason {
+				reason = name
+			}
+		}
+		if reason == "" || reason == HoldReason { // a hold could not be released over ACME
+			return acmeError(http.StatusBadRequest, "badRevocationReason", "unsupported reason code %d", *payload.Reason)
+		}
+	}
+
+	if err := RevokeCert(s.dataDir, serial, reason, time.Time{}); err != nil {
+		detail := strings.TrimPrefix(err.Error(), "Error: ")
+		switch errorKind(err) {
+		case KindConflict:
+			return acmeError(http.StatusBadRequest, "alreadyRevoked", "%s", detail)
+		case KindNotFound:
+			return acmeError(http.StatusNotFound, "malformed", "%s", detail)
+		default:
+			return acmeError(http.StatusInternalServerError, "serverInternal", "%s", detail)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"strings"
+)
+
+// KeyAlgorithm is one entry of the key algorithm registry: a key type the CA can
+// generate, accept in a CSR and sign with. Each CA key signs with the hash that
+// matches its strength (SHA-384 for P-384, SHA-512 for P-521 and RSA 4096, ...).
+type KeyAlgorithm struct {
+	Name        string // CLI and policy name, e.g. "ecdsa-p384"
+	DisplayName string // e.g. "ECDSA P-384"
+
+	generate func() (crypto.PrivateKey, error)
+	matches  func(crypto.PublicKey) bool
+	sigAlg   x509.SignatureAlgorithm // signature algorithm of a CA holding this key
+	pssAlg   x509.SignatureAlgorithm // RSASSA-PSS variant; UnknownSignatureAlgorithm if none
+}
+
+// keyAlgorithms is the registry, in the order names are listed to users.
+// Enforces CON-INV-010: supported key algorithms only
+var keyAlgorithms = []*KeyAlgorithm{
+	ecdsaAlgorithm("ecdsa-p256", "ECDSA P-256", elliptic.P256(), x509.ECDSAWithSHA256),
+	ecdsaAlgorithm("ecdsa-p384", "ECDSA P-384", elliptic.P384(), x509.ECDSAWithSHA384),
+	ecdsaAlgorithm("ecdsa-p521", "ECDSA P-521", elliptic.P521(), x509.ECDSAWithSHA512),
+	{
+		Name:        "ed25519",
+		DisplayName: "Ed25519",
+		generate: func() (crypto.PrivateKey, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		},
+		matches: func(pub crypto.PublicKey) bool {
+			_, ok := pub.(ed25519.PublicKey)
+			return ok
+		},
+		sigAlg: x509.PureEd25519,
+	},
+	rsaAlgorithm("rsa-2048", "RSA 2048", 2048, x509.SHA256WithRSA, x509.SHA256WithRSAPSS),
+	rsaAlgorithm("rsa-3072", "RSA 3072", 3072, x509.SHA384WithRSA, x509.SHA384WithRSAPSS),
+	rsaAlgorithm("rsa-4096", "RSA 4096", 4096, x509.SHA512WithRSA, x509.SHA512WithRSAPSS),
+}
+
+func ecdsaAlgorithm(name, display string, curve elliptic.Curve, sigAlg x509.SignatureAlgorithm) *KeyAlgorithm {
+	return &KeyAlgorithm{
+		Name:        name,
+		DisplayName: display,
+		generate: func() (crypto.PrivateKey, error) {
+			return ecdsa.GenerateKey(curve, rand.Reader)
+		},
+		matches: func(pub crypto.PublicKey) bool {
+			k, ok := pub.(*ecdsa.PublicKey)
+			return ok && k.Curve == curve
+		},
+		sigAlg: sigAlg,
+	}
+}
+
+func rsaAlgorithm(name, display string, bits int, sigAlg, pssAlg x509.SignatureAlgorithm) *KeyAlgorithm {
+	return &KeyAlgorithm{
+		Name:        name,
+		DisplayName: display,
+		generate: func() (crypto.PrivateKey, error) {
+			return rsa.GenerateKey(rand.Reader, bits)
+		},
+		matches: func(pub crypto.PublicKey) bool {
+			k, ok := pub.(*rsa.PublicKey)
+			return ok && k.N.BitLen() == bits
+		},
+		sigAlg: sigAlg,
+		pssAlg: pssAlg,
+	}
+}
+
+// lookupKeyAlgorithm returns the registry entry for a CLI or policy name.
+func lookupKeyAlgorithm(name string) (*KeyAlgorithm, bool) {
+	for _, a := range keyAlgorithms {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// keyAlgorithmOf returns the registry entry a public key belongs to, or nil.
+func keyAlgorithmOf(pub crypto.PublicKey) *KeyAlgorithm {
+	for _, a := range keyAlgorithms {
+		if a.matches(pub) {
+			return a
+		}
+	}
+	return nil
+}
+
+// KeyAlgorithmNames lists the registered algorithm names, e.g. for usage errors.
+func KeyAlgorithmNames() string {
+	names := make([]string, len(keyAlgorithms))
+	for i, a := range keyAlgorithms {
+		names[i] = a.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// errUnsupportedCSRKey is returned for CSR keys outside the registry.
+func errUnsupportedCSRKey() error {
+	names := make([]string, len(keyAlgorithms))
+	for i, a := range keyAlgorithms {
+		names[i] = a.DisplayName
+	}
+	return newCAError(KindInvalidInput, "Error: unsupported key algorithm in CSR. Supported: %s", strings.Join(names, ", ")) // REQ-ER-006
+}
+
+// signatureScheme describes how signTBS produces a signature algorithm by hand, for
+// structures the x509 package does not sign itself.
+type signatureScheme struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash // 0 for Ed25519, which signs the message itself
+}
+
+// signatureSchemes covers every signature algorithm in the registry.
+var signatureSchemes = map[x509.SignatureAlgorithm]signatureScheme{
+	x509.ECDSAWithSHA256:  {asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, crypto.SHA256},
+	x509.ECDSAWithSHA384:  {asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, crypto.SHA384},
+	x509.ECDSAWithSHA512:  {asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, crypto.SHA512},
+	x509.PureEd25519:      {asn1.ObjectIdentifier{1, 3, 101, 112}, 0},
+	x509.SHA256WithRSA:    {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, crypto.SHA256},
+	x509.SHA384WithRSA:    {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, crypto.SHA384},
+	x509.SHA512WithRSA:    {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, crypto.SHA512},
+	x509.SHA256WithRSAPSS: {oidRSASSAPSS, crypto.SHA256},
+	x509.SHA384WithRSAPSS: {oidRSASSAPSS, crypto.SHA384},
+	x509.SHA512WithRSAPSS: {oidRSASSAPSS, crypto.SHA512},
+}
+
+var (
+	oidRSASSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+
+	hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+		crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+		crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+	}
+)
+
+// signatureAlgorithmOf maps an AlgorithmIdentifier from a structure the x509 package
+// does not parse itself back to the signature algorithm in signatureSchemes.
+func signatureAlgorithmOf(algID pkix.AlgorithmIdentifier) (x509.SignatureAlgorithm, bool) {
+	var pssHash crypto.Hash
+	if algID.Algorithm.Equal(oidRSASSAPSS) {
+		var params pssParameters
+		if _, err := asn1.Unmarshal(algID.Parameters.FullBytes, &params); err != nil {
+			return x509.UnknownSignatureAlgorithm, false
+		}
+		for h, oid := range hashOIDs {
+			if oid.Equal(params.Hash.Algorithm) {
+				pssHash = h
+			}
+		}
+		if pssHash == 0 {
+			return x509.UnknownSignatureAlgorithm, false
+		}
+	}
+	for alg, scheme := range signatureSchemes {
+		if scheme.oid.Equal(algID.Algorithm) && (pssHash == 0 || scheme.hash == pssHash) {
+			return alg, true
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, false
+}
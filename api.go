@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// apiServer exposes the CA operations as a JSON REST API under /api/v1.
+// Response bodies carry the same fields as the *Result structs the CLI prints.
+// Concurrent mutating requests are serialized by the data directory lock.
+type apiServer struct {
+	dataDir    string
+	passphrase PassphraseFunc
+	token      string
+}
+
+// NewAPIHandler returns the REST API handler for the CA in dataDir. When token is
+// non-empty every request must present it as "Authorization: Bearer <token>".
+func NewAPIHandler(dataDir string, passphrase PassphraseFunc, token string) http.Handler {
+	return &apiServer{dataDir: dataDir, passphrase: passphrase, token: token}
+}
+
+// apiError is the JSON body of every non-2xx response.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// httpStatus maps an operational error to the HTTP status matching its CLI exit-code class.
+func httpStatus(err error) int {
+	switch errorKind(err) {
+	case KindInvalidInput:
+		return http.StatusBadRequest
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict, KindAlreadyInitialized:
+		return http.StatusConflict
+	case KindNotInitialized:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError reports err with its mapped status; usage-class errors pass status 400 explicitly.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: strings.TrimPrefix(err.Error(), "Error: ")})
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer") // RFC 6750 section 3
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "ca" && r.Method == http.MethodGet:
+		s.getCA(w)
+	case path == "crl" && r.Method == http.MethodGet:
+		s.getCRL(w, r.URL.Query().Get("delta") == "true")
+	case path == "crl" && r.Method == http.MethodPost:
+		s.generateCRL(w, r)
+	case path == "certificates" && r.Method == http.MethodGet:
+		s.listCerts(w, r)
+	case path == "certificates" && r.Method == http.MethodPost:
+		s.signCSR(w, r)
+	case len(parts) == 2 && parts[0] == "certificates" && r.Method == http.MethodGet:
+		s.getCert(w, NormalizeSerial(parts[1]))
+	case len(parts) == 3 && parts[0] == "certificates" && parts[2] == "revoke" && r.Method == http.MethodPost:
+		s.revokeCert(w, r, NormalizeSerial(parts[1]))
+	case len(parts) == 3 && parts[0] == "certificates" && parts[2] == "unhold" && r.Method == http.MethodPost:
+		s.unholdCert(w, NormalizeSerial(parts[1]))
+	case path == "verify" && r.Method == http.MethodPost:
+		s.verifyCert(w, r)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint"))
+		return
+	}
+	log.Printf("api: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+}
+
+// decodeBody parses a JSON request body into v, rejecting unknown fields.
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.New("invalid JSON request body: " + err.Error())
+	}
+	return nil
+}
+
+// getCA handles GET /api/v1/ca.
+func (s *apiServer) getCA(w http.ResponseWriter) {
+	if !IsInitialized(s.dataDir) {
+		writeError(w, http.StatusServiceUnavailable, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.")) // REQ-ER-002
+		return
+	}
+	certPath := filepath.Join(s.dataDir, "ca.crt")
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	chain, err := LoadChain(s.dataDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var chainPEM string
+	for _, c := range chain {
+		chainPEM += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}))
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Subject     string    `json:"subject"`
+		Issuer      string    `json:"issuer"`
+		Serial      string    `json:"serial"`
+		NotBefore   time.Time `json:"not_before"`
+		NotAfter    time.Time `json:"not_after"`
+		Certificate string    `json:"certificate"`
+		Chain       string    `json:"chain,omitempty"`
+	}{
+		Subject:     FormatDN(cert.Subject),
+		Issuer:      FormatDN(cert.Issuer),
+		Serial:      FormatSerialBig(cert.SerialNumber),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		Chain:       chainPEM,
+	})
+}
+
+// getCRL handles GET /api/v1/crl: the CRL most recently produced by "ca crl", or with
+// ?delta=true the latest delta CRL.
+func (s *apiServer) getCRL(w http.ResponseWriter, delta bool) {
+	if !IsInitialized(s.dataDir) {
+		writeError(w, http.StatusServiceUnavailable, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.")) // REQ-ER-002
+		return
+	}
+	crlPath := filepath.Join(s.dataDir, "ca.crl")
+	if delta {
+		crlPath = filepath.Join(s.dataDir, "ca-delta.crl")
+	}
+	data, err := os.ReadFile(crlPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusNotFound, errors.New("no such CRL has been generated yet"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	crl, err := LoadCRL(crlPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if crl.Number == nil || !crl.Number.IsInt64() {
+		writeError(w, http.StatusInternalServerError, errors.New("the CRL has no CRL number, or one too large to report"))
+		return
+	}
+	baseNumber, _ := deltaCRLBase(crl)
+	writeJSON(w, http.StatusOK, struct {
+		CRLResult
+		CRL string `json:"crl"`
+	}{
+		CRLResult: CRLResult{
+			ThisUpdate:    crl.ThisUpdate,
+			NextUpdate:    crl.NextUpdate,
+			CRLNumber:     crl.Number.Int64(),
+			RevokedCount:  len(crl.RevokedCertificateEntries),
+			CRLPath:       crlPath,
+			Delta:         delta,
+			BaseCRLNumber: baseNumber,
+		},
+		CRL: string(data),
+	})
+}
+
+// generateCRL handles POST /api/v1/crl.
+func (s *apiServer) generateCRL(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		NextUpdateHours int  `json:"next_update_hours"`
+		Delta           bool `json:"delta"`
+	}{NextUpdateHours: 24}
+	if r.ContentLength != 0 {
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.NextUpdateHours <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("next_update_hours must be a positive integer"))
+		return
+	}
+
+	result, err := GenerateCRL(s.dataDir, req.NextUpdateHours, req.Delta, s.passphrase)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// listCerts handles GET /api/v1/certificates?status=<active|revoked|expired>&subject=<substring>&san=<name>.
+func (s *apiServer) listCerts(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	subject := r.URL.Query().Get("subject")
+	san := r.URL.Query().Get("san")
+	if status != "" && status != "active" && status != "revoked" && status != "expired" {
+		writeError(w, http.StatusBadRequest, errors.New("status must be active, revoked or expired"))
+		return
+	}
+
+	certs, err := ListCerts(s.dataDir, CertFilter{Status: status, SAN: san})
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	filtered := []CertInfo{}
+	for _, c := range certs {
+		if subject != "" && !strings.Contains(c.Subject, subject) {
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Certificates []CertInfo `json:"certificates"`
+	}{filtered})
+}
+
+// signCSR handles POST /api/v1/certificates with {"csr": "<PEM>", "profile": "<name>", "validity_days": N}.
+// Omitted fields select the default profile and its default validity.
+func (s *apiServer) signCSR(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		CSR          string `json:"csr"`
+		Profile      string `json:"profile"`
+		ValidityDays int    `json:"validity_days"`
+	}{}
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.CSR == "" {
+		writeError(w, http.StatusBadRequest, errors.New("csr is required"))
+		return
+	}
+	if req.ValidityDays < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("validity_days must be a positive integer"))
+		return
+	}
+
+	result, err := SignCSR(s.dataDir, []byte(req.CSR), "request body", req.Profile, req.ValidityDays, s.passphrase)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	certPEM, err := os.ReadFile(result.CertPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		*SignResult
+		Certificate string `json:"certificate"`
+	}{result, string(certPEM)})
+}
+
+// getCert handles GET /api/v1/certificates/{serial}.
+func (s *apiServer) getCert(w http.ResponseWriter, serialHex string) {
+	info, certPath, err := GetCert(s.dataDir, serialHex)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		*CertInfo
+		Certificate string `json:"certificate"`
+	}{info, string(certPEM)})
+}
+
+// revokeCert handles POST /api/v1/certificates/{serial}/revoke with
+// {"reason": "<code>", "invalidity_date": "<RFC 3339 or YYYY-MM-DD>"}.
+func (s *apiServer) revokeCert(w http.ResponseWriter, r *http.Request, serialHex string) {
+	req := struct {
+		Reason         string `json:"reason"`
+		InvalidityDate string `json:"invalidity_date"`
+	}{Reason: "unspecified"}
+	if r.ContentLength != 0 {
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if !isValidReason(req.Reason) {
+		writeError(w, http.StatusBadRequest, errors.New("invalid reason code "+req.Reason+". Valid: "+strings.Join(ValidReasons, ", ")))
+		return
+	}
+	var invalidityDate time.Time
+	if req.InvalidityDate != "" {
+		var err error
+		if invalidityDate, err = ParseInvalidityDate(req.InvalidityDate); err != nil {
+			writeError(w, httpStatus(err), err)
+			return
+		}
+	}
+
+	err := RevokeCert(s.dataDir, serialHex, req.Reason, invalidityDate)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, RevokeResult{Serial: serialHex, Reason: req.Reason})
+}
+
+// unholdCert handles POST /api/v1/certificates/{serial}/unhold.
+func (s *apiServer) unholdCert(w http.ResponseWriter, serialHex string) {
+	result, err := UnholdCert(s.dataDir, serialHex)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// verifyCert handles POST /api/v1/verify with {"certificate": "<PEM>"}. The PEM may be
+// a bundle of the certificate and its intermediates; "purpose", "hostname", "ip" and
+// "index" are optional.
+func (s *apiServer) verifyCert(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Certificate string `json:"certificate"`
+		Purpose     string `json:"purpose"`
+		Hostname    string `json:"hostname"`
+		IP          string `json:"ip"`
+		Index       bool   `json:"index"`
+	}{}
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts := VerifyOptions{Purpose: req.Purpose, Hostname: req.Hostname, IP: req.IP, Index: req.Index}
+	result, err := VerifyCert(s.dataDir, []byte(req.Certificate), "request body", opts)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
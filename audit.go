@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	auditLogFile  = "audit.log"   // JSON Lines, one AuditRecord per line, append-only
+	auditLockFile = ".audit.lock" // serializes appends; never taken while waiting for .lock
+)
+
+// auditCheckpointEvery is how many records may follow a checkpoint before the next
+// operation that holds the CA key signs one. init, key operations and "ca audit
+// checkpoint" always sign.
+const auditCheckpointEvery = 16
+
+// AuditRecord is one line of audit.log. Hash is the SHA-256 of the record encoded with
+// Hash and Signature empty, and Prev is the previous record's Hash, so changing,
+// inserting or removing a record breaks the chain from there on. A checkpoint also
+// carries the CA key's signature over its Hash, which vouches for every record up to
+// it; Checkpoint is the Seq of the latest checkpoint at or before the record.
+type AuditRecord struct {
+	Seq        int64  `json:"seq"`
+	Time       string `json:"time"`
+	Operator   string `json:"operator"`
+	Via        string `json:"via,omitempty"` // the ca command that ran the operation, e.g. "acme serve"
+	Command    string `json:"command"`
+	Serial     string `json:"serial,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+	CSRHash    string `json:"csr_sha256,omitempty"`
+	CRLNumber  string `json:"crl_number,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	Result     string `json:"result"` // "ok" or "error"
+	Error      string `json:"error,omitempty"`
+	Prev       string `json:"prev"`
+	Checkpoint int64  `json:"checkpoint,omitempty"`
+	KeyID      string `json:"key_id,omitempty"` // subject key identifier of the signing key
+	SigAlg     string `json:"sig_alg,omitempty"`
+	Hash       string `json:"hash"`
+	Signature  []byte `json:"signature,omitempty"`
+}
+
+// digest returns the record's chain hash.
+func (r AuditRecord) digest() ([]byte, error) {
+	r.Hash, r.Signature = "", nil
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// auditOp collects the audit record of one operation while it runs. The operation
+// fills in what it learns (serial, subject, CSR hash) and, once it has loaded the CA
+// key, hands it over with signWith so that the record can be a checkpoint.
+type auditOp struct {
+	dataDir    string
+	rec        AuditRecord
+	key        crypto.Signer
+	checkpoint bool // sign even if the last checkpoint is recent
+}
+
+// startAudit begins the audit record of command on dataDir.
+func startAudit(dataDir string, command string) *auditOp {
+	return &auditOp{dataDir: dataDir, rec: AuditRecord{Command: command}}
+}
+
+// signWith makes the CA key available to sign the record as a checkpoint.
+func (a *auditOp) signWith(key crypto.PrivateKey) {
+	if signer, ok := key.(crypto.Signer); ok {
+		a.key = signer
+	}
+}
+
+// setCSR records the SHA-256 of the DER CSR the operation was given.
+func (a *auditOp) setCSR(csr *x509.CertificateRequest) {
+	if len(csr.Raw) > 0 {
+		sum := sha256.Sum256(csr.Raw)
+		a.rec.CSRHash = hex.EncodeToString(sum[:])
+	}
+}
+
+// finish appends the record with the operation's outcome. Nothing is logged for a
+// data directory that holds no CA. The operation has already committed or failed by
+// now, so a log that cannot be written is reported as a warning and does not change
+// its result. Appending a record to audit.log is the one change a failed operation
+// makes (CON-DI-004).
+func (a *auditOp) finish(err error) {
+	if !IsInitialized(a.dataDir) {
+		return
+	}
+	a.rec.Result = "ok"
+	if err != nil {
+		a.rec.Result = "error"
+		a.rec.Error = strings.TrimPrefix(err.Error(), "Error: ")
+	}
+	if _, werr := a.append(); werr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", werr)
+	}
+}
+
+// append chains the record to the end of audit.log and writes it.
+func (a *auditOp) append() (*AuditRecord, error) {
+	unlock, err := acquireLock(filepath.Join(a.dataDir, auditLockFile), "audit log "+a.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(a.dataDir, auditLogFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	last, err := lastAuditRecord(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w; run 'ca audit verify'", path, err)
+	}
+
+	rec := a.rec
+	rec.Time = time.Now().UTC().Format(time.RFC3339) // CON-DI-014: system clock
+	rec.Operator = auditOperator()
+	rec.Via = output.command
+	rec.Seq = 1
+	if last != nil {
+		rec.Seq, rec.Prev, rec.Checkpoint = last.Seq+1, last.Hash, last.Checkpoint
+	}
+	var pss bool
+	if a.key != nil && (a.checkpoint || rec.Checkpoint == 0 || rec.Seq-rec.Checkpoint >= auditCheckpointEvery) {
+		config, err := LoadConfig(a.dataDir)
+		if err != nil {
+			return nil, err
+		}
+		ski, err := computeSKI(a.key.Public())
+		if err != nil {
+			return nil, err
+		}
+		pss = config.RSAPSS
+		rec.Checkpoint = rec.Seq
+		rec.KeyID = hex.EncodeToString(ski)
+		rec.SigAlg = sigAlgorithm(a.key, pss).String() // checkpoints are signed like the CA's certificates
+	}
+	sum, err := rec.digest()
+	if err != nil {
+		return nil, err
+	}
+	rec.Hash = hex.EncodeToString(sum)
+	if rec.Checkpoint == rec.Seq {
+		if _, rec.Signature, err = signTBS(a.key, sum, pss); err != nil {
+			return nil, fmt.Errorf("failed to sign audit checkpoint: %w", err)
+		}
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteAt(append(line, '\n'), info.Size()); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// lastAuditRecord reads the final record of a log of the given size, or nil for an
+// empty log. A log that does not end in a complete record is an error: the next
+// record could not be chained to it.
+func lastAuditRecord(f io.ReaderAt, size int64) (*AuditRecord, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	for chunk := int64(4096); ; chunk *= 2 {
+		off := size - chunk
+		if off < 0 {
+			off = 0
+		}
+		buf := make([]byte, size-off)
+		if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if buf[len(buf)-1] != '\n' {
+			return nil, errors.New("log ends in a partial record")
+		}
+		body := buf[:len(buf)-1]
+		i := bytes.LastIndexByte(body, '\n')
+		if i < 0 && off > 0 {
+			continue
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal(body[i+1:], &rec); err != nil {
+			return nil, fmt.Errorf("last record is unreadable: %v", err)
+		}
+		return &rec, nil
+	}
+}
+
+// auditOperator names who ran the operation: CA_OPERATOR if set (for a service
+// account acting for a person), otherwise the login name of the process owner.
+func auditOperator() string {
+	if v := os.Getenv("CA_OPERATOR"); v != "" {
+		return v
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
+
+// AuditCheckpoint signs a checkpoint record now, so that every record so far is covered
+// by a CA key signature; "ca audit checkpoint" runs it, e.g. from cron.
+func AuditCheckpoint(dataDir string, passphrase PassphraseFunc) (*AuditRecord, error) {
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	caKey, err := LoadCASigner(dataDir, config, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key: %w", err)
+	}
+	a := startAudit(dataDir, "checkpoint")
+	a.signWith(caKey)
+	a.checkpoint = true
+	a.rec.Result = "ok"
+	rec, err := a.append()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return rec, nil
+}
+
+// AuditVerifyResult is the report of "ca audit verify". Valid means the chain is
+// intact, every checkpoint signature verifies against the CA certificate, and the
+// index and CRLs record nothing the log does not.
+type AuditVerifyResult struct {
+	Path           string   `json:"path"`
+	Valid          bool     `json:"valid"`
+	Records        int64    `json:"records"`
+	Checkpoints    int      `json:"checkpoints"`
+	LastCheckpoint int64    `json:"last_checkpoint"`
+	Unsigned       int64    `json:"unsigned"` // records after the last checkpoint, protected by the chain alone
+	FirstTime      string   `json:"first_time,omitempty"`
+	LastTime       string   `json:"last_time,omitempty"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+// auditIssuing are the commands whose successful record names a new certificate.
+var auditIssuing = map[string]bool{"sign": true, "sign-batch": true, "renew": true, "rekey": true, "intermediate": true, "rollover": true}
+
+// VerifyAuditLog checks audit.log record by record: sequence numbers, hashes and the
+// chain of Prev links, and the signature of every checkpoint against ca.crt, or the
+// certificate of the retired generation whose key signed it. The hash chain alone can
+// be recomputed by anyone who edits the file, so records after the last checkpoint are
+// reported separately. Truncation that removes whole records at the end leaves a valid
+// chain, so the state of the CA is checked against the log: every certificate issued,
+// revoked or released from hold since the first record, and the published CRLs, must
+// have a successful record. A non-empty anchor is the hash of a record noted earlier
+// outside the data directory, e.g. from "ca audit checkpoint"; the log must still
+// hold it.
+func VerifyAuditLog(dataDir string, anchor string) (*AuditVerifyResult, error) {
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	// Checkpoints are signed by the key that was current at the time (ca rollover)
+	gens, err := LoadGenerations(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	caCerts := map[string]*x509.Certificate{}
+	for _, g := range gens {
+		ski, err := computeSKI(g.Cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		caCerts[hex.EncodeToString(ski)] = g.Cert
+	}
+
+	path := filepath.Join(dataDir, auditLogFile)
+	result := &AuditVerifyResult{Path: path}
+	problem := func(format string, args ...interface{}) {
+		result.Problems = append(result.Problems, fmt.Sprintf(format, args...))
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		problem("%s does not exist", path)
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	// What the log vouches for, to compare with the CA's state afterwards
+	issued := map[string]bool{}
+	revoked := map[string]bool{}
+	released := map[string]bool{}
+	crls := map[string]bool{}
+
+	anchored := false
+	var prev *AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			problem("line %d: unreadable record: %v", line, err)
+			break
+		}
+		var want AuditRecord
+		if prev != nil {
+			want = AuditRecord{Seq: prev.Seq + 1, Prev: prev.Hash, Checkpoint: prev.Checkpoint}
+		} else {
+			want.Seq = 1
+		}
+		if rec.Seq != want.Seq {
+			problem("line %d: sequence number %d, expected %d (records removed or reordered)", line, rec.Seq, want.Seq)
+		}
+		if rec.Prev != want.Prev {
+			problem("record %d: does not chain to the record before it", rec.Seq)
+		}
+		sum, err := rec.digest()
+		if err != nil {
+			return nil, err
+		}
+		if hex.EncodeToString(sum) != rec.Hash {
+			problem("record %d: hash mismatch (record altered)", rec.Seq)
+		}
+		signed := len(rec.Signature) > 0
+		switch {
+		case signed && rec.Checkpoint != rec.Seq, !signed && rec.Checkpoint != want.Checkpoint:
+			problem("record %d: checkpoint reference %d, expected %d", rec.Seq, rec.Checkpoint, want.Checkpoint)
+		case signed && caCerts[rec.KeyID] == nil:
+			problem("record %d: checkpoint signed by key %s, which is not a CA key", rec.Seq, rec.KeyID)
+		case signed:
+			if err := caCerts[rec.KeyID].CheckSignature(signatureAlgorithmByName(rec.SigAlg), sum, rec.Signature); err != nil {
+				problem("record %d: checkpoint signature does not verify: %v", rec.Seq, err)
+			} else {
+				result.Checkpoints++
+				result.LastCheckpoint = rec.Seq
+			}
+		}
+
+		if result.FirstTime == "" {
+			result.FirstTime = rec.Time
+		}
+		result.LastTime = rec.Time
+		result.Records++
+		anchored = anchored || rec.Hash == anchor
+		if rec.Result == "ok" {
+			switch {
+			case auditIssuing[rec.Command]:
+				issued[rec.Serial] = true
+			case rec.Command == "revoke":
+				revoked[rec.Serial] = true
+			case rec.Command == "unhold":
+				released[rec.Serial] = true
+			case rec.Command == "crl":
+				crls[rec.CRLNumber] = true
+			}
+		}
+		prev = &rec
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		problem("log ends in a partial record")
+	}
+	if prev != nil {
+		result.Unsigned = prev.Seq - result.LastCheckpoint
+	}
+	if anchor != "" && !anchored {
+		problem("no record has the anchor hash %s (truncated or rewritten)", anchor)
+	}
+
+	// The CA's state since the first record must all be in the log
+	if start, err := time.Parse(time.RFC3339, result.FirstTime); err == nil {
+		since := func(ts string) bool {
+			t, err := time.Parse(time.RFC3339, ts)
+			return err == nil && !t.Before(start)
+		}
+		entries, err := LoadIndex(dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load index: %w", err)
+		}
+		for _, e := range entries {
+			if since(e.NotBefore) && !issued[e.Serial] {
+				problem("certificate %s was issued at %s but is not in the log (truncated?)", e.Serial, e.NotBefore)
+			}
+			// A renewal with --revoke-old revokes its predecessor in the renewal's record
+			superseded := e.RevocationReason == "superseded" && issued[e.ReplacedBy]
+			if e.Status == "revoked" && since(e.RevokedAt) && !revoked[e.Serial] && !superseded {
+				problem("certificate %s was revoked at %s but is not in the log (truncated?)", e.Serial, e.RevokedAt)
+			}
+			if since(e.HoldReleasedAt) && !released[e.Serial] {
+				problem("certificate %s was released from hold at %s but is not in the log (truncated?)", e.Serial, e.HoldReleasedAt)
+			}
+		}
+		for _, name := range []string{"ca.crl", "ca-delta.crl"} {
+			crl, err := LoadCRL(filepath.Join(dataDir, name))
+			if err != nil || crl.Number == nil || crl.ThisUpdate.Before(start) {
+				continue
+			}
+			if number := FormatSerialBig(crl.Number); !crls[number] {
+				problem("%s number %s is not in the log (truncated?)", name, number)
+			}
+		}
+	}
+
+	result.Valid = len(result.Problems) == 0
+	return result, nil
+}
+
+// signatureAlgorithmByName maps x509.SignatureAlgorithm.String() back to the algorithm.
+func signatureAlgorithmByName(name string) x509.SignatureAlgorithm {
+	for alg := range signatureSchemes {
+		if alg.String() == name {
+			return alg
+		}
+	}
+	return x509.UnknownSignatureAlgorithm
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupManifestName  = "MANIFEST.json" // first member of the tar archive
+	backupSignatureName = "MANIFEST.sig"  // second member: the CA key's signature over the manifest
+	backupVersion       = 1
+)
+
+// backupEncMagic starts an encrypted backup: magic, PBKDF2 salt, iteration count and
+// GCM nonce, then the tar archive sealed with AES-256-GCM under the header as
+// additional data.
+const backupEncMagic = "CABAKENC1\n"
+
+const (
+	backupSaltSize  = 16
+	backupNonceSize = 12
+)
+
+// BackupManifest lists every file of a backup with its SHA-256.
+type BackupManifest struct {
+	Version int          `json:"version"`
+	Created string       `json:"created"`
+	CA      string       `json:"ca"` // subject of ca.crt
+	Files   []BackupFile `json:"files"`
+}
+
+// BackupFile is one data directory file in a backup.
+type BackupFile struct {
+	Path   string `json:"path"` // slash-separated, relative to the data directory
+	Mode   uint32 `json:"mode"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupSignature is the MANIFEST.sig member.
+type backupSignature struct {
+	KeyID     string `json:"key_id"`
+	SigAlg    string `json:"sig_alg"`
+	Signature []byte `json:"signature"`
+}
+
+// BackupResult describes an archive written by "ca backup".
+type BackupResult struct {
+	Path      string `json:"path"`
+	CA        string `json:"ca"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+	Encrypted bool   `json:"encrypted"`
+	SHA256    string `json:"sha256"` // of the archive file, for the operator's records
+}
+
+// RestoreResult describes a data directory written by "ca restore".
+type RestoreResult struct {
+	DataDir    string `json:"data_dir"`
+	CA         string `json:"ca"`
+	Created    string `json:"created"` // when the backup was taken
+	Files      int    `json:"files"`
+	KeyChecked bool   `json:"key_checked"` // false when a signer provider holds the key
+	// SignatureTrusted is true when the manifest signature was checked against a CA
+	// certificate given by the caller; otherwise only the archive vouches for itself.
+	SignatureTrusted bool `json:"signature_trusted"`
+}
+
+// backupSkipped reports whether a data directory file stays out of backups: the lock
+// files and the temporary files of atomic writes.
+func backupSkipped(name string) bool {
+	return name == ".lock" || name == auditLockFile || name == acmeLockFile || strings.HasSuffix(name, ".tmp")
+}
+
+// Backup writes the whole state of dataDir to a single archive at outPath: a tar of
+// every file with a manifest of their SHA-256 hashes, signed by the CA key. The data
+// directory and audit log locks are held while the files are read, so the serial and
+// CRL number counters, the index and the certificates are captured as of one moment.
+// A non-empty encryptPassphrase seals the archive with AES-256-GCM under a
+// PBKDF2-HMAC-SHA256 key; unencrypted, it holds ca.key as it is on disk.
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-SC-001: key material only written to the archive, never to output
+func Backup(dataDir string, outPath string, passphrase PassphraseFunc, encryptPassphrase []byte) (result *BackupResult, err error) {
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	audit := startAudit(dataDir, "backup")
+	audit.rec.Detail = outPath
+	defer func() { audit.finish(err) }()
+
+	if _, err := os.Stat(outPath); err == nil {
+		return nil, newCAError(KindConflict, "Error: %s already exists", outPath)
+	}
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	caKey, err := LoadCASigner(dataDir, config, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key: %w", err)
+	}
+	audit.signWith(caKey)
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	unlockAudit, err := acquireLock(filepath.Join(dataDir, auditLockFile), "audit log "+dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockAudit()
+
+	caCert, err := LoadCertificate(filepath.Join(dataDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+
+	manifest := BackupManifest{
+		Version: backupVersion,
+		Created: time.Now().UTC().Format(time.RFC3339), // CON-DI-014: system clock
+		CA:      FormatDN(caCert.Subject),
+	}
+	contents := map[string][]byte{}
+	err = filepath.WalkDir(dataDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || backupSkipped(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dataDir, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		name := filepath.ToSlash(rel)
+		contents[name] = data
+		manifest.Files = append(manifest.Files, BackupFile{
+			Path:   name,
+			Mode:   uint32(info.Mode().Perm()),
+			Size:   int64(len(data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	ski, err := computeSKI(caKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	_, sig, err := signTBS(caKey, manifestData, config.RSAPSS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign backup manifest: %w", err)
+	}
+	sigData, err := json.MarshalIndent(backupSignature{
+		KeyID:     hex.EncodeToString(ski),
+		SigAlg:    sigAlgorithm(caKey, config.RSAPSS).String(),
+		Signature: sig,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	mtime, _ := time.Parse(time.RFC3339, manifest.Created)
+	add := func(name string, mode uint32, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: int64(mode), Size: int64(len(data)), ModTime: mtime, Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(backupManifestName, 0644, manifestData); err != nil {
+		return nil, err
+	}
+	if err := add(backupSignatureName, 0644, sigData); err != nil {
+		return nil, err
+	}
+	for _, f := range manifest.Files {
+		if err := add(f.Path, f.Mode, contents[f.Path]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	out := archive.Bytes()
+	if len(encryptPassphrase) > 0 {
+		if out, err = sealBackup(out, encryptPassphrase); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeFileAtomic(outPath, out, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	sum := sha256.Sum256(out)
+	return &BackupResult{
+		Path:      outPath,
+		CA:        manifest.CA,
+		Files:     len(manifest.Files),
+		Bytes:     int64(len(out)),
+		Encrypted: len(encryptPassphrase) > 0,
+		SHA256:    hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// sealBackup encrypts a backup archive under passphrase.
+func sealBackup(archive []byte, passphrase []byte) ([]byte, error) {
+	header := make([]byte, len(backupEncMagic)+backupSaltSize+4+backupNonceSize)
+	copy(header, backupEncMagic)
+	salt := header[len(backupEncMagic) : len(backupEncMagic)+backupSaltSize]
+	nonce := header[len(header)-backupNonceSize:]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(header[len(backupEncMagic)+backupSaltSize:], pbkdf2Iterations)
+	gcm, err := backupCipher(passphrase, salt, pbkdf2Iterations)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(header, nonce, archive, header), nil
+}
+
+// openBackup decrypts an archive sealed by sealBackup.
+func openBackup(data []byte, passphrase []byte) ([]byte, error) {
+	headerLen := len(backupEncMagic) + backupSaltSize + 4 + backupNonceSize
+	if len(data) < headerLen {
+		return nil, newCAError(KindInvalidInput, "Error: encrypted backup is truncated")
+	}
+	header := data[:headerLen]
+	salt := header[len(backupEncMagic) : len(backupEncMagic)+backupSaltSize]
+	iterations := binary.BigEndian.Uint32(header[len(backupEncMagic)+backupSaltSize:])
+	nonce := header[headerLen-backupNonceSize:]
+	if iterations == 0 || iterations > 10*pbkdf2Iterations {
+		return nil, newCAError(KindInvalidInput, "Error: encrypted backup has an invalid iteration count %d", iterations)
+	}
+	gcm, err := backupCipher(passphrase, salt, int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	archive, err := gcm.Open(nil, nonce, data[headerLen:], header)
+	if err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: cannot decrypt backup: incorrect passphrase or damaged archive")
+	}
+	return archive, nil
+}
+
+func backupCipher(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2Key(passphrase, salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Restore writes the data directory in archivePath to dataDir, which must not exist
+// or be empty. Nothing is written until the archive has been validated: every file
+// the manifest lists is present with its SHA-256 and nothing else is, the manifest
+// signature verifies against the archived ca.crt (which must equal expectCA, if
+// given), and ca.key holds the key of ca.crt. Without expectCA the signature only
+// shows the archive is self-consistent, and the result reports it as untrusted.
+//
+// The files are assembled in a staging directory next to dataDir, which is renamed
+// into place as the commit point. backupPassphrase opens an encrypted archive;
+// passphrase unlocks an encrypted ca.key for the key check.
+// Enforces CON-DI-004: validate-before-mutate + atomic commit (ADR-003, ADR-006)
+func Restore(dataDir string, archivePath string, expectCA *x509.Certificate, backupPassphrase PassphraseFunc, passphrase PassphraseFunc) (result *RestoreResult, err error) {
+	// VALIDATE PHASE (ADR-003)
+	if IsInitialized(dataDir) {
+		return nil, newCAError(KindAlreadyInitialized, "Error: CA already initialized at %s", dataDir) // REQ-ER-005
+	}
+	if entries, err := os.ReadDir(dataDir); err == nil && len(entries) > 0 {
+		return nil, newCAError(KindConflict, "Error: %s is not empty; restore into a new data directory", dataDir)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", dataDir, err)
+	}
+
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to read backup %s: %v", archivePath, err)
+	}
+	if bytes.HasPrefix(data, []byte(backupEncMagic)) {
+		pass, err := backupPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = openBackup(data, pass); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, files, err := readBackup(data, archivePath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(files["ca.crt"])
+	if block == nil {
+		return nil, newCAError(KindInvalidInput, "Error: backup %s has no valid ca.crt", archivePath)
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: backup %s has no valid ca.crt", archivePath)
+	}
+	if expectCA != nil && !bytes.Equal(caCert.Raw, expectCA.Raw) {
+		return nil, newCAError(KindConflict, "Error: backup %s is of CA %s, not the expected CA certificate", archivePath, FormatDN(caCert.Subject))
+	}
+	var sig backupSignature
+	if err := json.Unmarshal(files[backupSignatureName], &sig); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: backup %s has an unreadable %s", archivePath, backupSignatureName)
+	}
+	if err := caCert.CheckSignature(signatureAlgorithmByName(sig.SigAlg), files[backupManifestName], sig.Signature); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: backup manifest signature does not verify against its ca.crt: %v", err)
+	}
+
+	// MUTATE PHASE: assemble the data directory beside its final location
+	parent := filepath.Dir(filepath.Clean(dataDir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", parent, err)
+	}
+	staging, err := os.MkdirTemp(parent, "."+filepath.Base(dataDir)+".restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.RemoveAll(staging)
+		}
+	}()
+	if err := os.Chmod(staging, 0755); err != nil { // MkdirTemp creates it 0700
+		return nil, err
+	}
+	if err := InitDataDir(staging); err != nil {
+		return nil, err
+	}
+	for _, f := range manifest.Files {
+		p := filepath.Join(staging, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(p, files[f.Path], os.FileMode(f.Mode).Perm()); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", f.Path, err)
+		}
+	}
+
+	// The key must be the key of ca.crt; a signer provider's key is checked when it signs
+	config, err := LoadConfig(staging)
+	if err != nil {
+		return nil, err
+	}
+	result = &RestoreResult{DataDir: dataDir, CA: manifest.CA, Created: manifest.Created, Files: len(manifest.Files), SignatureTrusted: expectCA != nil}
+	var key crypto.Signer
+	if config.signerProvider() == signerFile {
+		if _, ok := files["ca.key"]; !ok {
+			return nil, newCAError(KindInvalidInput, "Error: backup %s has no ca.key", archivePath)
+		}
+		if key, err = LoadCASigner(staging, config, passphrase); err != nil {
+			return nil, fmt.Errorf("failed to load CA key: %w", err)
+		}
+		if err := checkSignerKey(staging, config, key); err != nil {
+			return nil, err
+		}
+		result.KeyChecked = true
+	}
+
+	if _, err := os.Stat(dataDir); err == nil {
+		if err := os.Remove(dataDir); err != nil { // empty, checked above
+			return nil, fmt.Errorf("failed to replace %s: %w", dataDir, err)
+		}
+	}
+	if err := os.Rename(staging, dataDir); err != nil { // Commit point
+		return nil, fmt.Errorf("failed to move restored data directory into place: %w", err)
+	}
+	committed = true
+
+	audit := startAudit(dataDir, "restore")
+	audit.rec.Subject = manifest.CA
+	audit.rec.Detail = fmt.Sprintf("from %s taken %s", archivePath, manifest.Created)
+	audit.signWith(key)
+	audit.checkpoint = true
+	audit.finish(nil)
+	return result, nil
+}
+
+// readBackup reads the members of a backup tar and checks them against the manifest.
+// It returns the manifest and the member contents by name.
+func readBackup(data []byte, archivePath string) (*BackupManifest, map[string][]byte, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return newCAError(KindInvalidInput, "Error: backup %s: %s", archivePath, fmt.Sprintf(format, args...))
+	}
+	files := map[string][]byte{}
+	var order []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, invalid("not a backup archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, nil, invalid("member %s is not a regular file", hdr.Name)
+		}
+		// Member names are relative paths inside the data directory, never ../ or absolute
+		if name := path.Clean(hdr.Name); name != hdr.Name || !filepath.IsLocal(filepath.FromSlash(name)) {
+			return nil, nil, invalid("member %s has an unsafe path", hdr.Name)
+		}
+		if _, dup := files[hdr.Name]; dup {
+			return nil, nil, invalid("member %s appears twice", hdr.Name)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, invalid("%v", err)
+		}
+		files[hdr.Name] = body
+		order = append(order, hdr.Name)
+	}
+	if len(order) < 2 || order[0] != backupManifestName || order[1] != backupSignatureName {
+		return nil, nil, invalid("%s and %s must come first", backupManifestName, backupSignatureName)
+	}
+
+	var manifest BackupManifest
+	if err := json.Unmarshal(files[backupManifestName], &manifest); err != nil {
+		return nil, nil, invalid("unreadable manifest: %v", err)
+	}
+	if manifest.Version != backupVersion {
+		return nil, nil, invalid("unsupported backup version %d", manifest.Version)
+	}
+	listed := map[string]bool{backupManifestName: true, backupSignatureName: true}
+	for _, f := range manifest.Files {
+		body, ok := files[f.Path]
+		if !ok {
+			return nil, nil, invalid("%s is listed in the manifest but missing", f.Path)
+		}
+		sum := sha256.Sum256(body)
+		if int64(len(body)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, nil, invalid("%s does not match its SHA-256 in the manifest", f.Path)
+		}
+		listed[f.Path] = true
+	}
+	var extra []string
+	for name := range files {
+		if !listed[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return nil, nil, invalid("%s is not listed in the manifest", strings.Join(extra, ", "))
+	}
+	return &manifest, files, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BatchItem is one CSR of a sign-batch run, with the profile and validity it is
+// issued under. Line is its line in the manifest, or zero for a directory.
+type BatchItem struct {
+	CSRPath      string
+	Profile      string
+	ValidityDays int
+	Line         int
+}
+
+// BatchItemResult is the outcome for one CSR of the batch. Status is "issued",
+// "invalid" (the CSR failed a check) or "skipped" (valid, but the batch held
+// invalid CSRs and nothing was issued).
+type BatchItemResult struct {
+	CSR      string     `json:"csr"`
+	Line     int        `json:"line,omitempty"`
+	Profile  string     `json:"profile"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Serial   string     `json:"serial,omitempty"`
+	Subject  string     `json:"subject,omitempty"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	CertPath string     `json:"cert_path,omitempty"`
+}
+
+// BatchResult is the per-item report of ca sign-batch.
+type BatchResult struct {
+	Issued  int               `json:"issued"`
+	Invalid int               `json:"invalid"`
+	Items   []BatchItemResult `json:"items"`
+}
+
+// BatchFromDir lists the *.csr files of dir in name order, each under the given
+// profile and validity.
+func BatchFromDir(dir string, profileName string, validityDays int) ([]BatchItem, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to read CSR directory %s: %v", dir, err)
+	}
+	var items []BatchItem
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".csr" {
+			continue
+		}
+		items = append(items, BatchItem{CSRPath: filepath.Join(dir, e.Name()), Profile: profileName, ValidityDays: validityDays})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CSRPath < items[j].CSRPath })
+	if len(items) == 0 {
+		return nil, newCAError(KindInvalidInput, "Error: no .csr files in %s", dir)
+	}
+	return items, nil
+}
+
+// BatchFromManifest reads a manifest with one CSR per line: the CSR path, then
+// optionally a profile and a validity in days, separated by whitespace. "-" keeps
+// the default for a field. Blank lines and lines starting with # are skipped;
+// relative paths are taken from the manifest's directory.
+func BatchFromManifest(path string, profileName string, validityDays int) ([]BatchItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to read manifest %s: %v", path, err)
+	}
+	base := filepath.Dir(path)
+	var items []BatchItem
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 3 {
+			return nil, newCAError(KindInvalidInput, "Error: %s:%d: expected a CSR path, a profile and a validity, got %d fields", path, n, len(fields))
+		}
+		item := BatchItem{CSRPath: fields[0], Profile: profileName, ValidityDays: validityDays, Line: n}
+		if !filepath.IsAbs(item.CSRPath) {
+			item.CSRPath = filepath.Join(base, item.CSRPath)
+		}
+		if len(fields) > 1 && fields[1] != "-" {
+			item.Profile = fields[1]
+		}
+		if len(fields) > 2 && fields[2] != "-" {
+			days, err := strconv.Atoi(fields[2])
+			if err != nil || days <= 0 {
+				return nil, newCAError(KindInvalidInput, "Error: %s:%d: validity must be a positive integer, got %q", path, n, fields[2])
+			}
+			item.ValidityDays = days
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to read manifest %s: %v", path, err)
+	}
+	if len(items) == 0 {
+		return nil, newCAError(KindInvalidInput, "Error: manifest %s lists no CSRs", path)
+	}
+	return items, nil
+}
+
+// SignBatch issues a certificate for every CSR of the batch, or none. All CSRs go
+// through the checks of ca sign first (CON-SC-003); if any fails, the report marks
+// it invalid and nothing is issued. Otherwise the CA key is loaded once and the
+// certificates are issued under one lock, with one serial draw, one staged commit of
+// the certificate files and one index transaction. Each certificate gets its own
+// audit record, so the log names every serial it issued.
+// Enforces CON-INV-001: unique serials
+func SignBatch(dataDir string, items []BatchItem, passphrase PassphraseFunc) (result *BatchResult, err error) {
+	audit := startAudit(dataDir, "sign-batch")
+	var issued []*auditOp
+	defer func() {
+		if err != nil {
+			audit.finish(err)
+			return
+		}
+		for _, op := range issued {
+			op.finish(nil)
+		}
+	}()
+
+	// VALIDATE PHASE (ADR-003, CON-SC-003): every CSR before any mutation
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	if len(items) == 0 {
+		return nil, newCAError(KindInvalidInput, "Error: the batch lists no CSRs")
+	}
+	profiles, err := LoadProfiles(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := LoadPolicy(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &BatchResult{Items: make([]BatchItemResult, len(items))}
+	issuances := make([]*issuance, len(items))
+	for i, item := range items {
+		profileName := item.Profile
+		if profileName == "" {
+			profileName = DefaultProfileName
+		}
+		r := &result.Items[i]
+		r.CSR, r.Line, r.Profile = item.CSRPath, item.Line, profileName
+		iss, err := checkBatchItem(item.CSRPath, profileName, item.ValidityDays, profiles, policy)
+		if err != nil {
+			r.Status = "invalid"
+			r.Error = strings.TrimPrefix(err.Error(), "Error: ")
+			result.Invalid++
+			continue
+		}
+		r.Subject = FormatDN(iss.csr.Subject)
+		issuances[i] = iss
+	}
+	if result.Invalid > 0 {
+		for i := range result.Items {
+			if result.Items[i].Status == "" {
+				result.Items[i].Status = "skipped"
+			}
+		}
+		audit.rec.Detail = fmt.Sprintf("%d of %d CSRs invalid", result.Invalid, len(items))
+		audit.finish(newCAError(KindInvalidInput, "Error: %d of %d CSRs are invalid; nothing was issued", result.Invalid, len(items)))
+		return result, nil
+	}
+
+	// MUTATE PHASE
+	caKey, err := LoadCASigner(dataDir, config, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key: %w", err)
+	}
+	audit.signWith(caKey)
+	caCert, err := LoadCertificate(filepath.Join(dataDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Re-checked under the lock: a rollover meanwhile changes which key signs what
+	if err := checkIssuingCA(dataDir, caCert, caKey); err != nil {
+		return nil, err
+	}
+
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	generation, err := currentGeneration(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	serials, newSerialData, err := nextSerials(dataDir, config, store, len(items))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014
+	var files []stagedFile
+	if newSerialData != nil {
+		files = append(files, stagedFile{filepath.Join(dataDir, "serial"), newSerialData, 0644}) // Prevents serial reuse (CON-INV-001)
+	}
+	entries := make([]IndexEntry, len(items))
+	for i, iss := range issuances {
+		certDER, template, err := iss.sign(serials[i], now, caCert, caKey, config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", items[i].CSRPath, err)
+		}
+		serialHex := FormatSerialBig(serials[i])
+		certPath := filepath.Join(dataDir, "certs", serialHex+".pem")
+		files = append(files, stagedFile{certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644})
+		entries[i] = IndexEntry{
+			Serial:     serialHex,
+			Subject:    FormatDN(iss.csr.Subject),
+			NotBefore:  now.Format(time.RFC3339),
+			NotAfter:   template.NotAfter.Format(time.RFC3339),
+			Status:     "active",
+			Profile:    iss.profileName,
+			SANs:       indexSANs(template.DNSNames, template.IPAddresses, template.EmailAddresses, template.URIs),
+			Generation: issuedGeneration(generation),
+		}
+
+		notAfter := template.NotAfter
+		r := &result.Items[i]
+		r.Status, r.Serial, r.NotAfter, r.CertPath = "issued", serialHex, &notAfter, certPath
+	}
+
+	// STAGE + COMMIT (ADR-006): serial counter and certificates, then the index transaction
+	if err := stageAndCommit(files); err != nil {
+		return nil, err
+	}
+	if err := store.Commit(entries...); err != nil { // Commit point
+		return nil, err
+	}
+	result.Issued = len(entries)
+
+	for i, iss := range issuances {
+		op := startAudit(dataDir, "sign-batch")
+		op.setCSR(iss.csr)
+		op.signWith(caKey)
+		op.rec.Serial = entries[i].Serial
+		op.rec.Subject = entries[i].Subject
+		op.rec.Detail = "profile " + iss.profileName
+		issued = append(issued, op)
+	}
+	return result, nil
+}
+
+// WriteBatchReport writes the per-CSR results of a batch to path as JSON.
+func WriteBatchReport(path string, result *BatchResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// checkBatchItem reads one CSR of the batch and runs the checks of ca sign on it.
+func checkBatchItem(csrPath string, profileName string, validityDays int, profiles map[string]*Profile, policy *Policy) (*issuance, error) {
+	csrPEM, err := os.ReadFile(csrPath)
+	if err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to read CSR file %s: %v", csrPath, err)
+	}
+	csr, err := parseCSR(csrPEM, csrPath)
+	if err != nil {
+		return nil, err
+	}
+	return checkIssuance(csr, profileName, validityDays, profiles, policy)
+}
@@ -2,87 +2,131 @@ package main
 
 import (
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"math/big"
-	"os"
 	"path/filepath"
 	"time"
 )
 
 // InitResult contains the results of CA initialization.
 type InitResult struct {
-	Subject   string
-	Algorithm string
-	Serial    string
-	NotAfter  time.Time
-	CertPath  string
-	KeyPath   string
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Algorithm string    `json:"algorithm"`
+	Serial    string    `json:"serial"`
+	NotAfter  time.Time `json:"not_after"`
+	CertPath  string    `json:"cert_path"`
+	KeyPath   string    `json:"key_path"`
+	ChainPath string    `json:"chain_path,omitempty"` // empty for a root CA
+	Encrypted bool      `json:"key_encrypted"`        // ca.key is passphrase-protected
+
+	PolicyPath      string `json:"policy_path,omitempty"`      // policy.json, when a policy was installed
+	NameConstraints bool   `json:"name_constraints,omitempty"` // the policy is embedded in the CA certificate
+	ConfigPath      string `json:"config_path,omitempty"`      // config.json, when publication URLs were given
+	Signer          string `json:"signer,omitempty"`           // provider holding the key when there is no ca.key
+	SignerSocket    string `json:"signer_socket,omitempty"`    // socket of the remote signer
+}
+
+// setKey records where the new CA's key lives: keyPath when it was written there,
+// otherwise the signer provider that holds it.
+func (r *InitResult) setKey(keyPath string, written bool, config *CAConfig) {
+	if written {
+		r.KeyPath = keyPath
+		return
+	}
+	r.Signer = config.signerProvider()
+	r.SignerSocket = config.SignerSocket
 }
 
 // SignResult contains the results of signing a CSR.
 type SignResult struct {
-	Serial   string
-	Subject  string
-	NotAfter time.Time
-	CertPath string
+	Serial   string    `json:"serial"`
+	Subject  string    `json:"subject"`
+	Profile  string    `json:"profile"`
+	NotAfter time.Time `json:"not_after"`
+	CertPath string    `json:"cert_path"`
+
+	Replaces        string `json:"replaces,omitempty"`         // predecessor serial (renew, rekey)
+	ReplacedRevoked bool   `json:"replaced_revoked,omitempty"` // predecessor revoked as superseded
+}
+
+// RevokeResult identifies a revoked certificate.
+type RevokeResult struct {
+	Serial string `json:"serial"`
+	Reason string `json:"reason"`
 }
 
 // CertInfo contains certificate display information for listing.
 type CertInfo struct {
-	Serial   string
-	Subject  string
-	NotAfter time.Time
-	Status   string // "active", "revoked", or "expired"
+	Serial   string    `json:"serial"`
+	Subject  string    `json:"subject"`
+	Profile  string    `json:"profile,omitempty"` // empty for intermediates and pre-profile entries
+	NotAfter time.Time `json:"not_after"`
+	Status   string    `json:"status"` // "active", "revoked", or "expired"
+
+	Replaces   string `json:"replaces,omitempty"`    // serial this certificate renewed or rekeyed
+	ReplacedBy string `json:"replaced_by,omitempty"` // serial of its renewal or rekey
 }
 
 // ReasonCodes maps reason code strings to RFC 5280 CRL reason code integers.
+// removeFromCRL (8) is not a revocation reason; it only marks a released hold in a delta CRL.
 var ReasonCodes = map[string]int{
 	"unspecified":          0,
 	"keyCompromise":        1,
+	"cACompromise":         2,
 	"affiliationChanged":   3,
 	"superseded":           4,
 	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
 }
 
 // ValidReasons is the ordered list of accepted reason code strings.
 var ValidReasons = []string{
-	"unspecified", "keyCompromise", "affiliationChanged",
-	"superseded", "cessationOfOperation",
+	"unspecified", "keyCompromise", "cACompromise", "affiliationChanged",
+	"superseded", "cessationOfOperation", "certificateHold",
+	"privilegeWithdrawn", "aACompromise",
+}
+
+// HoldReason is the one revocation reason that can be undone, with "ca unhold".
+const HoldReason = "certificateHold"
+
+// isValidReason reports whether reason is one of ValidReasons.
+func isValidReason(reason string) bool {
+	for _, r := range ValidReasons {
+		if reason == r {
+			return true
+		}
+	}
+	return false
 }
 
-// generateKeyPair generates an ECDSA P-256 or RSA 2048 key pair.
+// generateKeyPair generates a key pair for a registered key algorithm.
 // Enforces CON-SC-002: cryptographically secure key generation via crypto/rand
 // Enforces CON-INV-010: supported key algorithms only
 func generateKeyPair(keyAlgo string) (crypto.PrivateKey, error) {
-	switch keyAlgo {
-	case "ecdsa-p256":
-		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	case "rsa-2048":
-		return rsa.GenerateKey(rand.Reader, 2048)
-	default:
+	alg, ok := lookupKeyAlgorithm(keyAlgo)
+	if !ok {
 		return nil, fmt.Errorf("unsupported key algorithm: %s", keyAlgo)
 	}
+	return alg.generate()
 }
 
 // publicKeyBytes returns the DER-encoded public key bytes for SKI computation.
 func publicKeyBytes(pub crypto.PublicKey) ([]byte, error) {
-	switch k := pub.(type) {
-	case *ecdsa.PublicKey:
-		return x509.MarshalPKIXPublicKey(k)
-	case *rsa.PublicKey:
-		return x509.MarshalPKIXPublicKey(k)
-	default:
+	if keyAlgorithmOf(pub) == nil {
 		return nil, fmt.Errorf("unsupported public key type")
 	}
+	return x509.MarshalPKIXPublicKey(pub)
 }
 
 // computeSKI computes the Subject Key Identifier as SHA-1 hash of public key.
@@ -96,29 +140,84 @@ func computeSKI(pub crypto.PublicKey) ([]byte, error) {
 	return hash[:], nil
 }
 
-// sigAlgorithm returns the appropriate signature algorithm for the key type.
-// Enforces CON-INV-008: SHA-256 signature algorithm
-func sigAlgorithm(key crypto.PrivateKey) x509.SignatureAlgorithm {
-	switch key.(type) {
-	case *ecdsa.PrivateKey:
-		return x509.ECDSAWithSHA256
-	case *rsa.PrivateKey:
-		return x509.SHA256WithRSA
-	default:
+// sigAlgorithm returns the signature algorithm a CA key signs with: the hash matched
+// to the key's strength, and RSASSA-PSS instead of PKCS#1 v1.5 for RSA keys when pss is set.
+// Enforces CON-INV-008: explicit signature algorithm (SHA-256 or stronger)
+func sigAlgorithm(key crypto.PrivateKey, pss bool) x509.SignatureAlgorithm {
+	alg := keyAlgorithmOf(publicKey(key))
+	switch {
+	case alg == nil:
 		return x509.UnknownSignatureAlgorithm
+	case pss && alg.pssAlg != x509.UnknownSignatureAlgorithm:
+		return alg.pssAlg
+	default:
+		return alg.sigAlg
+	}
+}
+
+// pssParameters is RSASSA-PSS-params (RFC 4055) with the hash used for MGF1 too.
+type pssParameters struct {
+	Hash       pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF        pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength int                      `asn1:"explicit,tag:2"`
+}
+
+// signTBS signs DER-encoded to-be-signed data with the CA key and returns the
+// AlgorithmIdentifier and signature value to embed next to it (OCSP responses etc.).
+// Enforces CON-INV-008: explicit signature algorithm (SHA-256 or stronger)
+func signTBS(key crypto.PrivateKey, tbs []byte, pss bool) (pkix.AlgorithmIdentifier, []byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, nil, fmt.Errorf("key does not implement crypto.Signer")
+	}
+	alg := sigAlgorithm(key, pss)
+	scheme, ok := signatureSchemes[alg]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, nil, fmt.Errorf("unsupported signature algorithm %s", alg)
+	}
+	algID := pkix.AlgorithmIdentifier{Algorithm: scheme.oid}
+
+	var opts crypto.SignerOpts = scheme.hash
+	switch {
+	case scheme.oid.Equal(oidRSASSAPSS):
+		hashID := pkix.AlgorithmIdentifier{Algorithm: hashOIDs[scheme.hash], Parameters: asn1.NullRawValue}
+		hashIDDER, err := asn1.Marshal(hashID)
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		params, err := asn1.Marshal(pssParameters{
+			Hash:       hashID,
+			MGF:        pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: hashIDDER}},
+			SaltLength: scheme.hash.Size(),
+		})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		algID.Parameters = asn1.RawValue{FullBytes: params}
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: scheme.hash}
+	case alg == x509.SHA256WithRSA || alg == x509.SHA384WithRSA || alg == x509.SHA512WithRSA:
+		algID.Parameters = asn1.NullRawValue // RSA PKCS#1 v1.5 identifiers carry explicit NULL parameters
+	}
+
+	digest := tbs // Ed25519 signs the message itself
+	if scheme.hash != 0 {
+		h := scheme.hash.New()
+		h.Write(tbs)
+		digest = h.Sum(nil)
 	}
+	sig, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return algID, sig, nil
 }
 
 // publicKey extracts the public key from a private key.
 func publicKey(key crypto.PrivateKey) crypto.PublicKey {
-	switch k := key.(type) {
-	case *ecdsa.PrivateKey:
-		return &k.PublicKey
-	case *rsa.PrivateKey:
-		return &k.PublicKey
-	default:
-		return nil
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer.Public()
 	}
+	return nil
 }
 
 // InitCA initializes the root CA with key pair and self-signed certificate.
@@ -131,20 +230,39 @@ func publicKey(key crypto.PrivateKey) crypto.PublicKey {
 // Enforces CON-DI-004: validate-before-mutate + atomic writes (ADR-003, ADR-006)
 // Enforces CON-DI-010: X.509 version 3
 // Enforces CON-DI-011: root CA certificate extensions
-func InitCA(dataDir string, subject pkix.Name, keyAlgo string, validityDays int) (*InitResult, error) {
+// A non-empty passphrase stores ca.key encrypted; nil keeps the plaintext PKCS#8 layout.
+// A config selecting another signer provider uses the key it holds and writes no ca.key.
+// A non-nil policy is installed as policy.json; with nameConstraints its name rules are
+// also embedded in the root certificate. A non-empty config is installed as config.json;
+// the self-signed root itself carries none of its URLs.
+func InitCA(dataDir string, subject pkix.Name, keyAlgo string, validityDays int, policy *Policy, nameConstraints bool, config *CAConfig, passphrase []byte) (result *InitResult, err error) {
+	// Only an attempt on an existing CA is logged if this fails; a new CA's log starts
+	// with its init record
+	audit := startAudit(dataDir, "init")
+	audit.rec.Subject = FormatDN(subject)
+	defer func() { audit.finish(err) }()
+
 	// VALIDATE PHASE (ADR-003): all checks before any state change
 	if IsInitialized(dataDir) {
-		return nil, fmt.Errorf("Error: CA already initialized at %s", dataDir) // REQ-ER-005
+		return nil, newCAError(KindAlreadyInitialized, "Error: CA already initialized at %s", dataDir) // REQ-ER-005
+	}
+	if nameConstraints && policy == nil {
+		return nil, newCAError(KindInvalidInput, "Error: name constraints require a policy")
+	}
+	if config != nil {
+		if err := config.validate(); err != nil {
+			return nil, newCAError(KindInvalidInput, "Error: %v", err)
+		}
 	}
 
 	// MUTATE PHASE
-	// Generate key pair using CSPRNG (CON-SC-002)
-	privKey, err := generateKeyPair(keyAlgo)
+	// Generate a key pair using CSPRNG (CON-SC-002), or use the signer provider's key
+	privKey, keyPEM, err := newCAKey(dataDir, config, keyAlgo, passphrase)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+		return nil, err
 	}
 
-	pub := publicKey(privKey)
+	pub := privKey.Public()
 
 	// Compute Subject Key Identifier (CON-DI-011)
 	ski, err := computeSKI(pub)
@@ -152,12 +270,19 @@ func InitCA(dataDir string, subject pkix.Name, keyAlgo string, validityDays int)
 		return nil, fmt.Errorf("failed to compute subject key identifier: %w", err)
 	}
 
-	now := time.Now().UTC() // CON-DI-014: system clock
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014: system clock; certificates carry whole seconds
 	notAfter := now.Add(time.Duration(validityDays) * 24 * time.Hour)
 
+	rootSerial := big.NewInt(1)
+	if config.serialMode() == serialRandom {
+		if rootSerial, err = randomSerial(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Build X.509v3 root CA certificate template (CON-DI-011)
 	template := &x509.Certificate{
-		SerialNumber: big.NewInt(1), // CON-INV-002: root gets serial 01
+		SerialNumber: rootSerial, // CON-INV-002: root gets serial 01 (random in random serial mode)
 		Subject:      subject,
 		NotBefore:    now,
 		NotAfter:     notAfter,
@@ -165,7 +290,12 @@ func InitCA(dataDir string, subject pkix.Name, keyAlgo string, validityDays int)
 		BasicConstraintsValid: true,
 		IsCA:               true, // CON-DI-011: cA=TRUE
 		SubjectKeyId:       ski,  // CON-DI-011
-		SignatureAlgorithm: sigAlgorithm(privKey), // CON-INV-008: explicit SHA-256
+		SignatureAlgorithm: sigAlgorithm(privKey, config != nil && config.RSAPSS), // CON-INV-008: hash matched to the key
+	}
+	if nameConstraints {
+		if err := policy.applyNameConstraints(template); err != nil {
+			return nil, err
+		}
 	}
 
 	// Self-sign: template is both template and parent (CON-INV-006)
@@ -179,85 +309,102 @@ func InitCA(dataDir string, subject pkix.Name, keyAlgo string, validityDays int)
 		return nil, err
 	}
 
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Re-check under the lock: a concurrent init may have finished meanwhile (REQ-ER-005)
+	if IsInitialized(dataDir) {
+		return nil, newCAError(KindAlreadyInitialized, "Error: CA already initialized at %s", dataDir)
+	}
+
 	keyPath := filepath.Join(dataDir, "ca.key")
 	certPath := filepath.Join(dataDir, "ca.crt")
 	serialPath := filepath.Join(dataDir, "serial")
 	crlnumPath := filepath.Join(dataDir, "crlnumber")
-	indexPath := filepath.Join(dataDir, "index.json")
+	indexPath := filepath.Join(dataDir, indexDBFile)
 
 	// Prepare all data in memory first
 	serialData := FormatSerial(2) + "\n"   // CON-DI-008: next serial is 02
 	crlnumData := FormatSerial(1) + "\n"   // CON-DI-009: first CRL number is 01
-	indexData := "[]\n"                     // CON-INV-009: empty index, no root cert
-
-	// STAGE SUB-PHASE (ADR-006): write all to .tmp files
-	tmpPaths := []string{
-		keyPath + ".tmp",
-		certPath + ".tmp",
-		serialPath + ".tmp",
-		crlnumPath + ".tmp",
-		indexPath + ".tmp",
-	}
 
-	// Stage ca.key
-	keyDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+	indexData, err := marshalIndexDB(nil) // CON-INV-009: empty index, no root cert
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal private key: %w", err)
-	}
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
-	if err := os.WriteFile(keyPath+".tmp", keyPEM, 0600); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to write CA key: %w", err)
+		return nil, err
 	}
 
-	// Stage ca.crt
 	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-	if err := os.WriteFile(certPath+".tmp", certPEM, 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to write CA certificate: %w", err)
-	}
-
-	// Stage serial
-	if err := os.WriteFile(serialPath+".tmp", []byte(serialData), 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to write serial: %w", err)
-	}
-
-	// Stage crlnumber
-	if err := os.WriteFile(crlnumPath+".tmp", []byte(crlnumData), 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to write crlnumber: %w", err)
-	}
-
-	// Stage index.json
-	if err := os.WriteFile(indexPath+".tmp", []byte(indexData), 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to write index: %w", err)
+	profilesData, err := marshalProfiles(builtinProfiles())
+	if err != nil {
+		return nil, err
 	}
 
-	// COMMIT SUB-PHASE (ADR-006): rename in order: ca.key, ca.crt, serial, crlnumber, index.json
-	commitOrder := []struct{ tmp, final string }{
-		{keyPath + ".tmp", keyPath},
-		{certPath + ".tmp", certPath},
-		{serialPath + ".tmp", serialPath},
-		{crlnumPath + ".tmp", crlnumPath},
-		{indexPath + ".tmp", indexPath},
+	// STAGE + COMMIT (ADR-006): rename in order: ca.key, ca.crt, serial, crlnumber, index.db, profiles.json
+	var files []stagedFile
+	if keyPEM != nil {
+		files = append(files, stagedFile{keyPath, keyPEM, 0600})
+	}
+	files = append(files, []stagedFile{
+		{certPath, certPEM, 0644},
+		{serialPath, []byte(serialData), 0644},
+		{crlnumPath, []byte(crlnumData), 0644},
+		{indexPath, indexData, 0644},
+		{filepath.Join(dataDir, "profiles.json"), profilesData, 0644},
+	}...)
+	if policy != nil {
+		policyData, err := marshalPolicy(policy)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, stagedFile{filepath.Join(dataDir, "policy.json"), policyData, 0644})
 	}
-	for _, c := range commitOrder {
-		if err := os.Rename(c.tmp, c.final); err != nil {
-			cleanupTempFiles(tmpPaths)
-			return nil, fmt.Errorf("failed to commit %s: %w", c.final, err)
+	if config != nil && !config.empty() {
+		configData, err := marshalConfig(config)
+		if err != nil {
+			return nil, err
 		}
+		files = append(files, stagedFile{filepath.Join(dataDir, "config.json"), configData, 0644})
 	}
+	if err := stageAndCommit(files); err != nil {
+		return nil, err
+	}
+	audit.rec.Serial = FormatSerialBig(rootSerial)
+	audit.signWith(privKey)
+	audit.checkpoint = true
 
-	return &InitResult{
+	result = &InitResult{
 		Subject:   FormatDN(subject),
-		Algorithm: AlgoDisplayName(keyAlgo),
-		Serial:    FormatSerial(1),
+		Issuer:    FormatDN(subject),
+		Algorithm: keyAlgorithmOf(pub).DisplayName,
+		Serial:    FormatSerialBig(rootSerial),
 		NotAfter:  notAfter,
 		CertPath:  certPath,
-		KeyPath:   keyPath,
-	}, nil
+		Encrypted: len(passphrase) > 0,
+	}
+	result.setKey(keyPath, keyPEM != nil, config)
+	if policy != nil {
+		result.PolicyPath = filepath.Join(dataDir, "policy.json")
+		result.NameConstraints = nameConstraints
+	}
+	if config != nil && !config.empty() {
+		result.ConfigPath = filepath.Join(dataDir, "config.json")
+	}
+	return result, nil
+}
+
+// marshalCAKey encodes the CA key as PEM, encrypted when a passphrase is given.
+// Enforces CON-DI-001: PEM encoding
+func marshalCAKey(key crypto.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) > 0 {
+		return EncryptPrivateKeyPEM(key, passphrase)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
 }
 
 // SignCSR validates a CSR and issues a signed end-entity certificate.
@@ -267,111 +414,164 @@ func InitCA(dataDir string, subject pkix.Name, keyAlgo string, validityDays int)
 // Enforces CON-INV-005: chain of trust integrity (signed by CA key)
 // Enforces CON-INV-008: SHA-256 signature algorithm (explicit)
 // Enforces CON-INV-009: index contains only end-entity certificates
-// Enforces CON-INV-011: no identity verification (CON-MK-001)
+// Enforces CON-INV-011: no identity verification (CON-MK-001); policy.json limits which names may be certified
 // Enforces CON-DI-004: validate-before-mutate + atomic writes (ADR-003, ADR-006)
 // Enforces CON-DI-010: X.509 version 3
 // Enforces CON-DI-012: end-entity certificate extensions
-func SignCSR(dataDir string, csrPEM []byte, csrPath string, validityDays int) (*SignResult, error) {
+// The named profile decides key usages, extended key usages, permitted SANs and the
+// maximum validity; validityDays 0 selects the profile's default validity.
+func SignCSR(dataDir string, csrPEM []byte, csrPath string, profileName string, validityDays int, passphrase PassphraseFunc) (result *SignResult, err error) {
+	audit := startAudit(dataDir, "sign")
+	defer func() { audit.finish(err) }()
+
 	// VALIDATE PHASE (ADR-003, CON-SC-003): all checks before any mutation
 	if !IsInitialized(dataDir) {
-		return nil, fmt.Errorf("Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	csr, err := parseCSR(csrPEM, csrPath)
+	if err != nil {
+		return nil, err
 	}
+	audit.setCSR(csr)
+
+	return issueCertificate(dataDir, csr, profileName, validityDays, passphrase, nil, audit)
+}
 
-	// Parse CSR PEM
+// parseCSR decodes a PEM CSR and verifies its self-signature (CON-SC-003 check 1).
+func parseCSR(csrPEM []byte, csrPath string) (*x509.CertificateRequest, error) {
 	block, _ := pem.Decode(csrPEM)
 	if block == nil {
-		return nil, fmt.Errorf("Error: failed to parse CSR from %s", csrPath) // REQ-ER-008
+		return nil, newCAError(KindInvalidInput, "Error: failed to parse CSR from %s", csrPath) // REQ-ER-008
 	}
 
 	csr, err := x509.ParseCertificateRequest(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("Error: failed to parse CSR from %s", csrPath) // REQ-ER-008
+		return nil, newCAError(KindInvalidInput, "Error: failed to parse CSR from %s", csrPath) // REQ-ER-008
 	}
 
-	// Verify CSR self-signature (CON-SC-003 check 1)
 	if err := csr.CheckSignature(); err != nil {
-		return nil, fmt.Errorf("Error: CSR signature verification failed") // REQ-ER-001
+		return nil, newCAError(KindInvalidInput, "Error: CSR signature verification failed") // REQ-ER-001
 	}
+	return csr, nil
+}
 
-	// Check key algorithm (CON-SC-003 check 2, CON-INV-010)
-	switch pub := csr.PublicKey.(type) {
-	case *ecdsa.PublicKey:
-		if pub.Curve != elliptic.P256() {
-			return nil, fmt.Errorf("Error: unsupported key algorithm in CSR. Supported: ECDSA P-256, RSA 2048") // REQ-ER-006
-		}
-	case *rsa.PublicKey:
-		if pub.N.BitLen() != 2048 {
-			return nil, fmt.Errorf("Error: unsupported key algorithm in CSR. Supported: ECDSA P-256, RSA 2048") // REQ-ER-006
-		}
-	default:
-		return nil, fmt.Errorf("Error: unsupported key algorithm in CSR. Supported: ECDSA P-256, RSA 2048") // REQ-ER-006
+// replacement links a certificate being issued to the one it renews or rekeys.
+type replacement struct {
+	serial    string // the predecessor's serial
+	revokeOld bool   // revoke the predecessor with reason superseded in the same commit
+}
+
+// issueCertificate runs the remaining validation and the mutate phase shared by SignCSR,
+// RenewCert and RekeyCert. csr supplies the subject, SANs and public key; its signature
+// has already been checked (or, for a renewal, it was built from an issued certificate).
+// A non-nil replace records the link in both index entries under the same lock.
+// What is issued is noted in the caller's audit record.
+func issueCertificate(dataDir string, csr *x509.CertificateRequest, profileName string, validityDays int, passphrase PassphraseFunc, replace *replacement, audit *auditOp) (*SignResult, error) {
+	audit.rec.Subject = FormatDN(csr.Subject)
+	if profileName == "" {
+		profileName = DefaultProfileName
+	}
+	audit.rec.Detail = "profile " + profileName
+	if replace != nil {
+		audit.rec.Detail += ", replaces " + replace.serial
+	}
+	profiles, err := LoadProfiles(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := LoadPolicy(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	iss, err := checkIssuance(csr, profileName, validityDays, profiles, policy)
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
 	}
 
 	// MUTATE PHASE
-	caKeyPath := filepath.Join(dataDir, "ca.key")
 	caCertPath := filepath.Join(dataDir, "ca.crt")
 	serialPath := filepath.Join(dataDir, "serial")
 
-	caKey, err := LoadPrivateKey(caKeyPath)
+	caKey, err := LoadCASigner(dataDir, config, passphrase)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load CA key: %w", err)
 	}
+	audit.signWith(caKey)
 
 	caCert, err := LoadCertificate(caCertPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
 	}
 
-	serialVal, err := ReadCounter(serialPath)
+	// Held from drawing the serial until the index commit, so concurrent
+	// signers never draw the same serial (CON-INV-001)
+	unlock, err := lockDataDir(dataDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read serial counter: %w", err)
+		return nil, err
 	}
+	defer unlock()
 
-	// Compute Subject Key Identifier for end-entity cert (CON-DI-012)
-	subjectSKI, err := computeSKI(csr.PublicKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compute subject key identifier: %w", err)
+	// Re-checked under the lock: a rollover meanwhile changes which key signs what
+	if err := checkIssuingCA(dataDir, caCert, caKey); err != nil {
+		return nil, err
 	}
 
-	now := time.Now().UTC() // CON-DI-014: system clock
-	notAfter := now.Add(time.Duration(validityDays) * 24 * time.Hour)
-
-	// Determine key usage based on subject key type (CON-DI-012)
-	keyUsage := x509.KeyUsageDigitalSignature
-	if _, isRSA := csr.PublicKey.(*rsa.PublicKey); isRSA {
-		keyUsage |= x509.KeyUsageKeyEncipherment
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	generation, err := currentGeneration(dataDir)
+	if err != nil {
+		return nil, err
 	}
 
-	// Build end-entity certificate template (CON-DI-012)
-	template := &x509.Certificate{
-		SerialNumber:          big.NewInt(serialVal), // CON-INV-001, CON-INV-002
-		Subject:               csr.Subject,
-		NotBefore:             now,
-		NotAfter:              notAfter,
-		KeyUsage:              keyUsage,
-		BasicConstraintsValid: true,
-		IsCA:                  false, // CON-DI-012: cA=FALSE
-		SubjectKeyId:          subjectSKI,
-		AuthorityKeyId:        caCert.SubjectKeyId, // CON-INV-005
-		DNSNames:              csr.DNSNames,
-		IPAddresses:           csr.IPAddresses,
-		EmailAddresses:        csr.EmailAddresses,
-		SignatureAlgorithm:    sigAlgorithm(caKey), // CON-INV-008: explicit SHA-256
+	serial, newSerialData, err := nextSerial(dataDir, config, store)
+	if err != nil {
+		return nil, err
 	}
 
-	// Sign with CA key (CON-INV-005)
-	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014: system clock; certificates carry whole seconds
+	certDER, template, err := iss.sign(serial, now, caCert, caKey, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create certificate: %w", err)
+		return nil, err
 	}
+	notAfter := template.NotAfter
 
-	serialHex := FormatSerial(serialVal)
+	serialHex := FormatSerialBig(serial)
 	certFilePath := filepath.Join(dataDir, "certs", serialHex+".pem")
+	audit.rec.Serial = serialHex
 
 	// Build new index entry (CON-DI-005, CON-DI-003)
-	index, err := LoadIndex(dataDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load index: %w", err)
+	var updates []IndexEntry // the predecessor's record, if any, then the new one
+	var replacedRevoked bool
+	if replace != nil {
+		// Re-checked under the lock: a concurrent renewal may have replaced it meanwhile
+		old, err := store.Get(replace.serial)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load index: %w", err)
+		}
+		if old == nil {
+			return nil, newCAError(KindNotFound, "Error: certificate with serial %s not found", replace.serial) // REQ-ER-003
+		}
+		if old.ReplacedBy != "" {
+			return nil, newCAError(KindConflict, "Error: certificate with serial %s was already replaced by %s", replace.serial, old.ReplacedBy)
+		}
+		old.ReplacedBy = serialHex
+		if replace.revokeOld && (old.Status != "revoked" || old.RevocationReason == HoldReason) {
+			if old.Status != "revoked" {
+				old.Status = "revoked" // CON-INV-003
+				old.RevokedAt = now.Format(time.RFC3339)
+				old.HoldReleasedAt = ""
+			}
+			old.RevocationReason = "superseded"
+			replacedRevoked = true
+		}
+		updates = append(updates, *old)
 	}
 
 	newEntry := IndexEntry{
@@ -382,144 +582,375 @@ func SignCSR(dataDir string, csrPEM []byte, csrPath string, validityDays int) (*
 		Status:           "active",
 		RevokedAt:        "",
 		RevocationReason: "",
+		Profile:          iss.profileName,
+		SANs:             indexSANs(template.DNSNames, template.IPAddresses, template.EmailAddresses, template.URIs),
+		Generation:       issuedGeneration(generation),
 	}
-	updatedIndex := append(index, newEntry)
+	if replace != nil {
+		newEntry.Replaces = replace.serial
+	}
+	updates = append(updates, newEntry)
 
 	// Prepare all data
 	certPEMData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-	indexData, err := marshalIndex(updatedIndex)
+
+	// STAGE + COMMIT (ADR-006): rename in order: serial, cert; then the index transaction
+	var files []stagedFile
+	if newSerialData != nil {
+		files = append(files, stagedFile{serialPath, newSerialData, 0644}) // Prevents serial reuse (CON-INV-001)
+	}
+	files = append(files, stagedFile{certFilePath, certPEMData, 0644}) // Places artifact
+	if err := stageAndCommit(files); err != nil {
+		return nil, err
+	}
+	if err := store.Commit(updates...); err != nil { // Commit point
+		return nil, err
+	}
+
+	result := &SignResult{
+		Serial:   serialHex,
+		Subject:  FormatDN(csr.Subject),
+		Profile:  iss.profileName,
+		NotAfter: notAfter,
+		CertPath: certFilePath,
+	}
+	if replace != nil {
+		result.Replaces = replace.serial
+		result.ReplacedRevoked = replacedRevoked
+		if replacedRevoked {
+			audit.rec.Detail += " (revoked)"
+		}
+	}
+	return result, nil
+}
+
+// issuance is a CSR that has passed the checks before issuance, with the profile and
+// validity it is to be issued under.
+type issuance struct {
+	csr          *x509.CertificateRequest
+	profileName  string
+	profile      *Profile
+	validityDays int
+}
+
+// checkIssuance runs the checks a CSR must pass before anything is written: key
+// algorithm, profile and requested validity, and the issuance policy, which may be nil.
+// validityDays 0 selects the profile's default validity.
+// Enforces CON-SC-003: checks 2 to 4 (check 1, the CSR signature, is parseCSR's)
+func checkIssuance(csr *x509.CertificateRequest, profileName string, validityDays int, profiles map[string]*Profile, policy *Policy) (*issuance, error) {
+	// Check key algorithm against the registry (CON-SC-003 check 2, CON-INV-010);
+	// the policy may narrow it further
+	if keyAlgorithmOf(csr.PublicKey) == nil {
+		return nil, errUnsupportedCSRKey() // REQ-ER-006
+	}
+
+	// Check the CSR and requested validity against the profile (CON-SC-003 check 3)
+	if profileName == "" {
+		profileName = DefaultProfileName
+	}
+	profile, err := lookupProfile(profiles, profileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := profile.checkCSR(profileName, csr); err != nil {
+		return nil, err
+	}
+	validityDays, err = profile.validityDays(profileName, validityDays)
 	if err != nil {
 		return nil, err
 	}
-	newSerialData := []byte(FormatSerial(serialVal+1) + "\n")
 
-	// STAGE SUB-PHASE (ADR-006)
-	tmpPaths := []string{
-		serialPath + ".tmp",
-		certFilePath + ".tmp",
-		filepath.Join(dataDir, "index.json") + ".tmp",
+	// Check subject and SANs against the issuance policy, if one is configured (CON-SC-003 check 4)
+	if policy != nil {
+		if err := policy.check(csr); err != nil {
+			return nil, err
+		}
 	}
+	return &issuance{csr: csr, profileName: profileName, profile: profile, validityDays: validityDays}, nil
+}
+
+// sign builds the end-entity certificate for the checked CSR with the given serial,
+// valid from now, and signs it with the CA key. It returns the DER certificate and
+// the template it was built from.
+func (iss *issuance) sign(serial *big.Int, now time.Time, caCert *x509.Certificate, caKey crypto.Signer, config *CAConfig) ([]byte, *x509.Certificate, error) {
+	csr := iss.csr
 
-	if err := os.WriteFile(serialPath+".tmp", newSerialData, 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to stage serial: %w", err)
+	// Compute Subject Key Identifier for end-entity cert (CON-DI-012)
+	subjectSKI, err := computeSKI(csr.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compute subject key identifier: %w", err)
 	}
-	if err := os.WriteFile(certFilePath+".tmp", certPEMData, 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to stage certificate: %w", err)
+
+	// Build end-entity certificate template (CON-DI-012)
+	template := &x509.Certificate{
+		SerialNumber:          serial, // CON-INV-001, CON-INV-002
+		Subject:               csr.Subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Duration(iss.validityDays) * 24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  false, // CON-DI-012: cA=FALSE
+		SubjectKeyId:          subjectSKI,
+		AuthorityKeyId:        caCert.SubjectKeyId,                // CON-INV-005
+		SignatureAlgorithm:    sigAlgorithm(caKey, config.RSAPSS), // CON-INV-008: hash matched to the key
 	}
-	if err := os.WriteFile(filepath.Join(dataDir, "index.json")+".tmp", indexData, 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to stage index: %w", err)
+	// Key usages, extended key usages and SANs come from the profile (CON-DI-012)
+	iss.profile.apply(template, csr)
+	// Where relying parties find this CA's status information and certificate (config.json)
+	if err := config.apply(template); err != nil {
+		return nil, nil, err
 	}
 
-	// COMMIT SUB-PHASE (ADR-006): rename in order: serial, cert, index
-	commitOrder := []struct{ tmp, final string }{
-		{serialPath + ".tmp", serialPath},           // Prevents serial reuse (CON-INV-001)
-		{certFilePath + ".tmp", certFilePath},       // Places artifact
-		{filepath.Join(dataDir, "index.json") + ".tmp", filepath.Join(dataDir, "index.json")}, // Commit point
+	// Sign with CA key (CON-INV-005)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
 	}
-	for _, c := range commitOrder {
-		if err := os.Rename(c.tmp, c.final); err != nil {
-			cleanupTempFiles(tmpPaths)
-			return nil, fmt.Errorf("failed to commit %s: %w", c.final, err)
+	return certDER, template, nil
+}
+
+// UnholdResult describes a released certificate hold.
+type UnholdResult struct {
+	Serial     string    `json:"serial"`
+	HeldSince  time.Time `json:"held_since"`
+	ReleasedAt time.Time `json:"released_at"`
+}
+
+// ParseInvalidityDate parses a --invalidity-date value: an RFC 3339 timestamp or a
+// date (YYYY-MM-DD, taken as midnight UTC). The date may not lie in the future.
+func ParseInvalidityDate(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", s); err != nil {
+			return time.Time{}, newCAError(KindInvalidInput, "Error: invalid invalidity date %q (use YYYY-MM-DD or RFC 3339)", s)
 		}
 	}
-
-	return &SignResult{
-		Serial:   serialHex,
-		Subject:  FormatDN(csr.Subject),
-		NotAfter: notAfter,
-		CertPath: certFilePath,
-	}, nil
+	t = t.UTC().Truncate(time.Second)
+	if t.After(time.Now().UTC()) {
+		return time.Time{}, newCAError(KindInvalidInput, "Error: invalidity date %s is in the future", t.Format(time.RFC3339))
+	}
+	return t, nil
 }
 
-// RevokeCert revokes a certificate by serial number.
-// Enforces CON-INV-003: certificate state irreversibility (active → revoked only)
+// RevokeCert revokes a certificate by serial number. A non-zero invalidityDate records
+// when the key is known or suspected to have been compromised (RFC 5280 §5.3.2).
+// A certificate on hold may be revoked again with a final reason; it keeps its
+// original revocation time and, unless a new one is given, its invalidity date.
+// Enforces CON-INV-003: certificate state irreversibility (active → revoked only, except certificateHold)
 // Enforces CON-INV-004: CA initialization prerequisite
 // Enforces CON-BD-007: precondition validation
 // Enforces CON-BD-008: postcondition - status, timestamp, reason set
 // Enforces CON-BD-009: error conditions
 // Enforces CON-DI-004: validate-before-mutate (ADR-003)
-func RevokeCert(dataDir string, serialHex string, reason string) error {
+func RevokeCert(dataDir string, serialHex string, reason string, invalidityDate time.Time) (err error) {
+	audit := startAudit(dataDir, "revoke")
+	audit.rec.Serial = serialHex
+	audit.rec.Detail = "reason " + reason
+	defer func() { audit.finish(err) }()
+
 	// VALIDATE PHASE (ADR-003)
 	if !IsInitialized(dataDir) {
-		return fmt.Errorf("Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+		return newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
 	}
 
-	index, err := LoadIndex(dataDir)
+	unlock, err := lockDataDir(dataDir)
 	if err != nil {
-		return fmt.Errorf("failed to load index: %w", err)
+		return err
 	}
+	defer unlock()
 
-	found := -1
-	for i, entry := range index {
-		if entry.Serial == serialHex {
-			found = i
-			break
-		}
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
 	}
 
-	if found < 0 {
-		return fmt.Errorf("Error: certificate with serial %s not found", serialHex) // REQ-ER-003
+	entry, err := store.Get(serialHex)
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	if entry == nil {
+		return newCAError(KindNotFound, "Error: certificate with serial %s not found", serialHex) // REQ-ER-003
 	}
 
-	if index[found].Status == "revoked" {
-		return fmt.Errorf("Error: certificate with serial %s is already revoked", serialHex) // REQ-ER-004, CON-INV-003
+	onHold := entry.Status == "revoked" && entry.RevocationReason == HoldReason
+	if entry.Status == "revoked" && (!onHold || reason == HoldReason) {
+		return newCAError(KindConflict, "Error: certificate with serial %s is already revoked", serialHex) // REQ-ER-004, CON-INV-003
 	}
 
 	// MUTATE PHASE
-	now := time.Now().UTC() // CON-DI-014: system clock
-	index[found].Status = "revoked"
-	index[found].RevokedAt = now.Format(time.RFC3339)      // CON-DI-003
-	index[found].RevocationReason = reason
+	if !onHold {
+		now := time.Now().UTC() // CON-DI-014: system clock
+		entry.Status = "revoked"
+		entry.RevokedAt = now.Format(time.RFC3339) // CON-DI-003
+		entry.HoldReleasedAt = ""
+	}
+	entry.RevocationReason = reason
+	if !invalidityDate.IsZero() {
+		entry.InvalidityDate = invalidityDate.Format(time.RFC3339)
+	}
 
-	// Single file mutation: writeFileAtomic handles atomicity (ADR-006)
-	if err := SaveIndex(dataDir, index); err != nil {
+	// Single index transaction (ADR-006)
+	if err := store.Commit(*entry); err != nil {
 		return fmt.Errorf("failed to save index: %w", err)
 	}
 
 	return nil
 }
 
-// ListCerts returns all issued certificates with computed display status.
+// UnholdCert releases a certificateHold, returning the certificate to active. It drops
+// out of the next full CRL; HoldReleasedAt records the release so that a delta CRL
+// can list it as removeFromCRL. Certificates revoked for any other reason stay revoked.
+// Enforces CON-INV-003: only certificateHold is reversible
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-DI-004: validate-before-mutate (ADR-003)
+func UnholdCert(dataDir string, serialHex string) (result *UnholdResult, err error) {
+	audit := startAudit(dataDir, "unhold")
+	audit.rec.Serial = serialHex
+	defer func() { audit.finish(err) }()
+
+	// VALIDATE PHASE (ADR-003)
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	entry, err := store.Get(serialHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	if entry == nil {
+		return nil, newCAError(KindNotFound, "Error: certificate with serial %s not found", serialHex) // REQ-ER-003
+	}
+	if entry.Status != "revoked" {
+		return nil, newCAError(KindConflict, "Error: certificate with serial %s is not on hold", serialHex)
+	}
+	if entry.RevocationReason != HoldReason {
+		return nil, newCAError(KindConflict, "Error: certificate with serial %s is revoked (%s), not on hold; revocation is irreversible", serialHex, entry.RevocationReason) // CON-INV-003
+	}
+	heldSince, err := time.Parse(time.RFC3339, entry.RevokedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid revoked_at for serial %s: %w", serialHex, err)
+	}
+
+	// MUTATE PHASE
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014: system clock
+	entry.Status = "active"
+	entry.RevokedAt = ""
+	entry.RevocationReason = ""
+	entry.InvalidityDate = ""
+	entry.HoldReleasedAt = now.Format(time.RFC3339)
+
+	if err := store.Commit(*entry); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return &UnholdResult{Serial: serialHex, HeldSince: heldSince, ReleasedAt: now}, nil
+}
+
+// CertFilter narrows ListCerts. Zero fields match every certificate.
+type CertFilter struct {
+	Status  string // display status: active, revoked or expired
+	Subject string // exact subject DN
+	SAN     string // "dns:www.example.com", "ip:10.0.0.1", ... or a bare name of any type
+}
+
+// ListCerts returns the issued certificates matching filter with computed display status.
 // Enforces CON-INV-004: CA initialization prerequisite
 // Enforces CON-BD-013: precondition
 // Enforces CON-BD-014: display status computed dynamically, read-only
-func ListCerts(dataDir string) ([]CertInfo, error) {
+func ListCerts(dataDir string, filter CertFilter) ([]CertInfo, error) {
 	if !IsInitialized(dataDir) {
-		return nil, fmt.Errorf("Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
 	}
 
-	index, err := LoadIndex(dataDir)
+	store, err := OpenIndex(dataDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load index: %w", err)
 	}
 
 	now := time.Now().UTC() // CON-DI-014: system clock
+	query := IndexQuery{Subject: filter.Subject, SAN: filter.SAN}
+	switch filter.Status {
+	case "revoked":
+		query.Status = "revoked"
+	case "expired":
+		query.Status, query.ExpiresBefore = "active", now
+	case "active":
+		query.Status = "active"
+	}
+	index, err := store.Find(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+
 	var certs []CertInfo
 	for _, entry := range index {
-		notAfter, _ := time.Parse(time.RFC3339, entry.NotAfter)
-
-		// Compute display status (CON-BD-014)
-		status := "active"
-		if entry.Status == "revoked" {
-			status = "revoked"
-		} else if now.After(notAfter) {
-			status = "expired"
+		info := newCertInfo(&entry, now)
+		if filter.Status != "" && info.Status != filter.Status {
+			continue
 		}
-
-		certs = append(certs, CertInfo{
-			Serial:   entry.Serial,
-			Subject:  entry.Subject,
-			NotAfter: notAfter,
-			Status:   status,
-		})
+		certs = append(certs, info)
 	}
 
 	return certs, nil
 }
 
+// newCertInfo returns the display information of an index entry at now.
+// Enforces CON-BD-014: display status computed dynamically
+func newCertInfo(entry *IndexEntry, now time.Time) CertInfo {
+	notAfter, _ := time.Parse(time.RFC3339, entry.NotAfter)
+
+	// Compute display status (CON-BD-014)
+	status := "active"
+	if entry.Status == "revoked" {
+		status = "revoked"
+	} else if now.After(notAfter) {
+		status = "expired"
+	}
+
+	return CertInfo{
+		Serial:   entry.Serial,
+		Subject:  entry.Subject,
+		Profile:  entry.Profile,
+		NotAfter: notAfter,
+		Status:   status,
+
+		Replaces:   entry.Replaces,
+		ReplacedBy: entry.ReplacedBy,
+	}
+}
+
+// GetCert returns the display information and PEM file path for one issued certificate.
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-BD-014: display status computed dynamically, read-only
+func GetCert(dataDir string, serialHex string) (*CertInfo, string, error) {
+	if !IsInitialized(dataDir) {
+		return nil, "", newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load index: %w", err)
+	}
+	entry, err := store.Get(serialHex)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load index: %w", err)
+	}
+	if entry == nil {
+		return nil, "", newCAError(KindNotFound, "Error: certificate with serial %s not found", serialHex) // REQ-ER-003
+	}
+	info := newCertInfo(entry, time.Now().UTC()) // CON-DI-014: system clock
+	return &info, filepath.Join(dataDir, "certs", serialHex+".pem"), nil
+}
+
 // marshalIndex serializes index entries to indented JSON.
 func marshalIndex(entries []IndexEntry) ([]byte, error) {
 	data, err := json.MarshalIndent(entries, "", "  ")
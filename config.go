@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CAConfig holds the CA's publication and signing settings, kept in config.json in
+// the data directory. A missing file is the empty configuration. The URLs are stamped
+// into every certificate the CA issues, leaf or intermediate.
+type CAConfig struct {
+	CRLURLs       []string `json:"crl_urls,omitempty"`        // CRL Distribution Points
+	DeltaCRLURLs  []string `json:"delta_crl_urls,omitempty"`  // Freshest CRL, also on base CRLs
+	OCSPURLs      []string `json:"ocsp_urls,omitempty"`       // Authority Information Access: OCSP
+	CAIssuersURLs []string `json:"ca_issuers_urls,omitempty"` // Authority Information Access: caIssuers
+	RSAPSS        bool     `json:"rsa_pss,omitempty"`         // sign with RSASSA-PSS instead of PKCS #1 v1.5 (RSA CA keys only)
+	Signer        string   `json:"signer,omitempty"`          // signer provider holding the CA key; empty means "file"
+	SignerSocket  string   `json:"signer_socket,omitempty"`   // Unix socket of the remote signer
+	SerialMode    string   `json:"serial_mode,omitempty"`     // "sequential" or "random"; empty means sequential
+}
+
+// configKeys maps the keys accepted by "ca config set" (and the matching "ca init"
+// flags) to the fields they edit.
+var configKeys = map[string]func(*CAConfig) *[]string{
+	"crl-url":        func(c *CAConfig) *[]string { return &c.CRLURLs },
+	"delta-crl-url":  func(c *CAConfig) *[]string { return &c.DeltaCRLURLs },
+	"ocsp-url":       func(c *CAConfig) *[]string { return &c.OCSPURLs },
+	"ca-issuers-url": func(c *CAConfig) *[]string { return &c.CAIssuersURLs },
+}
+
+// Single-valued config keys.
+const (
+	configKeyRSAPSS       = "rsa-pss"
+	configKeySigner       = "signer"
+	configKeySignerSocket = "signer-socket"
+	configKeySerialMode   = "serial-mode"
+)
+
+// configSettings maps the single-valued keys of "ca config set" to the fields they
+// edit. An empty value restores the default.
+var configSettings = map[string]func(c *CAConfig, value string) error{
+	configKeyRSAPSS: func(c *CAConfig, value string) error {
+		if value == "" {
+			c.RSAPSS = false
+			return nil
+		}
+		pss, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q (must be true or false)", configKeyRSAPSS, value)
+		}
+		c.RSAPSS = pss
+		return nil
+	},
+	configKeySigner: func(c *CAConfig, value string) error {
+		c.Signer = value
+		return nil
+	},
+	configKeySignerSocket: func(c *CAConfig, value string) error {
+		c.SignerSocket = value
+		return nil
+	},
+	configKeySerialMode: func(c *CAConfig, value string) error {
+		c.SerialMode = value
+		return nil
+	},
+}
+
+// ConfigKeys returns the URL keys accepted by "ca config set", sorted.
+func ConfigKeys() []string {
+	keys := make([]string, 0, len(configKeys))
+	for k := range configKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Freshest CRL (RFC 5280 §4.2.1.15, §5.2.6) and Delta CRL Indicator (§5.2.4) extensions.
+var (
+	oidFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+	oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+)
+
+// LoadConfig reads config.json from the data directory.
+func LoadConfig(dataDir string) (*CAConfig, error) {
+	path := filepath.Join(dataDir, "config.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &CAConfig{}, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var c CAConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to parse %s: %v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: invalid config in %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// SetConfig replaces the values of one configuration key; no values unsets it.
+// Only certificates and CRLs issued afterwards carry the new values. A changed
+// signing setting must fit the CA key: the selected signer has to hold it.
+// "ca init" takes the same keys as flags.
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-DI-004: validate-before-mutate + atomic write (ADR-003, ADR-006)
+func SetConfig(dataDir string, key string, values []string) (config *CAConfig, err error) {
+	audit := startAudit(dataDir, "config set")
+	if values == nil {
+		audit.rec.Command = "config unset"
+	}
+	audit.rec.Detail = key
+	if len(values) > 0 {
+		audit.rec.Detail += " " + strings.Join(values, ",")
+	}
+	defer func() { audit.finish(err) }()
+
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	field, isList := configKeys[key]
+	setting, isSetting := configSettings[key]
+	if !isList && !isSetting {
+		keys := append(ConfigKeys(), configKeyRSAPSS, configKeySigner, configKeySignerSocket, configKeySerialMode)
+		sort.Strings(keys)
+		return nil, newCAError(KindInvalidInput, "Error: unknown config key %q (valid: %s)", key, strings.Join(keys, ", "))
+	}
+	if isSetting && len(values) > 1 {
+		return nil, newCAError(KindInvalidInput, "Error: %s takes a single value", key)
+	}
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	c, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if isList {
+		*field(c) = values
+	} else {
+		var value string
+		if len(values) == 1 {
+			value = values[0]
+		}
+		if err := setting(c, value); err != nil {
+			return nil, newCAError(KindInvalidInput, "Error: %v", err)
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: %v", err)
+	}
+	if isSetting && key != configKeySerialMode {
+		if err := c.checkSigning(dataDir); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := marshalConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dataDir, "config.json"), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	return c, nil
+}
+
+// checkSigning checks the signing settings against the CA key: RSA-PSS needs an
+// RSA key, and the selected signer must hold the key of ca.crt.
+func (c *CAConfig) checkSigning(dataDir string) error {
+	caCert, err := LoadCertificate(filepath.Join(dataDir, "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	if _, ok := caCert.PublicKey.(*rsa.PublicKey); c.RSAPSS && !ok {
+		return newCAError(KindInvalidInput, "Error: %s requires an RSA CA key", configKeyRSAPSS)
+	}
+	if c.signerProvider() == signerFile {
+		keyPath := filepath.Join(dataDir, "ca.key")
+		if _, err := os.Stat(keyPath); err != nil {
+			return newCAError(KindInvalidInput, "Error: the file signer needs %s; select the signer that holds the CA key", keyPath)
+		}
+		return nil
+	}
+	_, err = LoadCASigner(dataDir, c, nil)
+	return err
+}
+
+// marshalConfig encodes c as config.json.
+func marshalConfig(c *CAConfig) ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// empty reports whether c sets nothing, in which case init writes no config.json.
+func (c *CAConfig) empty() bool {
+	for _, key := range ConfigKeys() {
+		if len(*configKeys[key](c)) > 0 {
+			return false
+		}
+	}
+	return !c.RSAPSS && c.Signer == "" && c.SignerSocket == "" && c.SerialMode == ""
+}
+
+// apply stamps the publication URLs into a certificate this CA is about to issue.
+func (c *CAConfig) apply(template *x509.Certificate) error {
+	template.CRLDistributionPoints = c.CRLURLs
+	template.OCSPServer = c.OCSPURLs
+	template.IssuingCertificateURL = c.CAIssuersURLs
+	if len(c.DeltaCRLURLs) > 0 {
+		freshest, err := freshestCRLExtension(c.DeltaCRLURLs)
+		if err != nil {
+			return err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, freshest)
+	}
+	return nil
+}
+
+func (c *CAConfig) validate() error {
+	for _, key := range ConfigKeys() {
+		for _, u := range *configKeys[key](c) {
+			if err := validatePublicationURL(u); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+	if _, ok := signerProviders[c.signerProvider()]; !ok {
+		return fmt.Errorf("%s: unknown provider %q (valid: %s)", configKeySigner, c.Signer, strings.Join(SignerProviders(), ", "))
+	}
+	if c.signerProvider() == signerRemote && c.SignerSocket == "" {
+		return fmt.Errorf("%s: the %s signer needs %s", configKeySigner, signerRemote, configKeySignerSocket)
+	}
+	if mode := c.serialMode(); mode != serialSequential && mode != serialRandom {
+		return fmt.Errorf("%s: unknown mode %q (valid: %s, %s)", configKeySerialMode, c.SerialMode, serialRandom, serialSequential)
+	}
+	return nil
+}
+
+// validatePublicationURL accepts the absolute http and ldap URLs relying parties can fetch.
+func validatePublicationURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "ldap":
+	default:
+		return fmt.Errorf("URL %q must use http, https or ldap", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", s)
+	}
+	return nil
+}
+
+// distributionPoint mirrors the RFC 5280 DistributionPoint with only a fullName of URIs,
+// which is all this CA publishes.
+type distributionPoint struct {
+	Name struct {
+		FullName []asn1.RawValue `asn1:"optional,tag:0"`
+	} `asn1:"optional,tag:0"`
+}
+
+// freshestCRLExtension returns a Freshest CRL extension pointing at the delta CRL URLs.
+// crypto/x509 only knows CRL Distribution Points, which shares this syntax.
+func freshestCRLExtension(urls []string) (pkix.Extension, error) {
+	var points []distributionPoint
+	for _, u := range urls {
+		var dp distributionPoint
+		dp.Name.FullName = []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(u)}} // uniformResourceIdentifier
+		points = append(points, dp)
+	}
+	value, err := asn1.Marshal(points)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed to marshal Freshest CRL extension: %w", err)
+	}
+	return pkix.Extension{Id: oidFreshestCRL, Value: value}, nil
+}
+
+// freshestCRLURLs returns the URIs of a certificate's Freshest CRL extension.
+func freshestCRLURLs(cert *x509.Certificate) []string {
+	var urls []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidFreshestCRL) {
+			continue
+		}
+		var points []distributionPoint
+		if _, err := asn1.Unmarshal(ext.Value, &points); err != nil {
+			return nil
+		}
+		for _, dp := range points {
+			for _, name := range dp.Name.FullName {
+				if name.Class == asn1.ClassContextSpecific && name.Tag == 6 {
+					urls = append(urls, string(name.Bytes))
+				}
+			}
+		}
+	}
+	return urls
+}
@@ -7,33 +7,57 @@ import (
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
-	"strconv"
 	"time"
 )
 
 // CRLResult contains the results of CRL generation.
 type CRLResult struct {
-	ThisUpdate   time.Time
-	NextUpdate   time.Time
-	CRLNumber    int64
-	RevokedCount int
-	CRLPath      string
+	ThisUpdate   time.Time `json:"this_update"`
+	NextUpdate   time.Time `json:"next_update"`
+	CRLNumber    int64     `json:"crl_number"`
+	RevokedCount int       `json:"revoked_count"`
+	CRLPath      string    `json:"crl_path"`
+
+	Delta         bool   `json:"delta,omitempty"`
+	BaseCRLNumber int64  `json:"base_crl_number,omitempty"` // delta CRLs: the base CRL they extend
+	RemovedCount  int    `json:"removed_count,omitempty"`   // delta CRLs: released holds listed as removeFromCRL
+	ArchivePath   string `json:"archive_path,omitempty"`
+
+	Generation int          `json:"generation,omitempty"` // CA key generation that signed it, after a rollover
+	Retired    []*CRLResult `json:"retired,omitempty"`    // full CRLs signed by the retired generations
 }
 
 // ReasonNames maps RFC 5280 reason code integers back to display strings.
 var ReasonNames = map[int]string{
-	0: "unspecified",
-	1: "keyCompromise",
-	3: "affiliationChanged",
-	4: "superseded",
-	5: "cessationOfOperation",
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
 }
 
-// GenerateCRL generates a signed X.509 CRL v2 containing all revoked certificates.
+// oidInvalidityDate is the CRL entry extension for the date a key became invalid (RFC 5280 §5.3.2).
+var oidInvalidityDate = asn1.ObjectIdentifier{2, 5, 29, 24}
+
+// GenerateCRL generates a signed X.509 CRL v2. A full (base) CRL lists every revoked
+// certificate and replaces ca.crl. A delta CRL (RFC 5280 §5.2.4) lists only what changed
+// since the base CRL in ca.crl: certificates revoked since, or whose reason changed, and
+// released holds as removeFromCRL; it replaces ca-delta.crl. Bases and deltas share the
+// crlnumber sequence, and every CRL is also kept as crls/<number>.crl.
+// After a ca rollover each CRL only lists the certificates of the generation whose key
+// signs it. A full CRL run also has every retired generation that has not expired sign a
+// full CRL of its own, published as generations/NN/ca.crl; deltas are for the current
+// generation only.
 // Enforces CON-INV-004: CA initialization prerequisite
 // Enforces CON-INV-005: chain of trust integrity (CRL signed by CA key)
 // Enforces CON-INV-007: CRL number monotonicity
@@ -45,69 +69,277 @@ var ReasonNames = map[int]string{
 // Enforces CON-DI-009: CRL number counter consistency
 // Enforces CON-DI-013: CRL structure
 // Enforces CON-DI-014: system clock for timestamps
-func GenerateCRL(dataDir string, nextUpdateHours int) (*CRLResult, error) {
+func GenerateCRL(dataDir string, nextUpdateHours int, delta bool, passphrase PassphraseFunc) (result *CRLResult, err error) {
+	audit := startAudit(dataDir, "crl")
+	audit.rec.Detail = "full"
+	if delta {
+		audit.rec.Detail = "delta"
+	}
+	defer func() { audit.finish(err) }()
+
 	// VALIDATE PHASE (ADR-003)
 	if !IsInitialized(dataDir) {
-		return nil, fmt.Errorf("Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
 	}
 
-	caKeyPath := filepath.Join(dataDir, "ca.key")
-	caCertPath := filepath.Join(dataDir, "ca.crt")
 	crlnumPath := filepath.Join(dataDir, "crlnumber")
-	crlPath := filepath.Join(dataDir, "ca.crl")
+	basePath := filepath.Join(dataDir, "ca.crl")
+	crlPath := basePath
+	if delta {
+		crlPath = filepath.Join(dataDir, "ca-delta.crl")
+	}
+
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	gens, err := LoadGenerations(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	current := gens[len(gens)-1]
+	caCert := current.Cert
 
-	caKey, err := LoadPrivateKey(caKeyPath)
+	caKey, err := LoadCASigner(dataDir, config, passphrase)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load CA key: %w", err)
 	}
+	audit.signWith(caKey)
 
-	caCert, err := LoadCertificate(caCertPath)
+	// Retired generations sign until they expire; their certificates cannot outlive them
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014: system clock; certificates carry whole seconds
+	nextUpdate := now.Add(time.Duration(nextUpdateHours) * time.Hour)
+	var retired []*CAGeneration
+	retiredKeys := map[int]crypto.Signer{}
+	if !delta {
+		for _, g := range gens[:len(gens)-1] {
+			if now.After(g.Cert.NotAfter) {
+				continue
+			}
+			key, err := g.loadSigner(config, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load generation %d CA key: %w", g.Number, err)
+			}
+			retired = append(retired, g)
+			retiredKeys[g.Number] = key
+		}
+	}
+
+	unlock, err := lockDataDir(dataDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+		return nil, err
+	}
+	defer unlock()
+
+	// Re-checked under the lock: a rollover meanwhile changes which key signs what
+	if n, err := currentGeneration(dataDir); err != nil || n != current.Number {
+		return nil, newCAError(KindConflict, "Error: the CA was rolled over during CRL generation; run it again")
 	}
 
-	index, err := LoadIndex(dataDir)
+	store, err := OpenIndex(dataDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load index: %w", err)
 	}
+	// A full CRL needs only the revoked entries; a delta also lists released holds
+	var all []IndexEntry
+	if delta {
+		all, err = store.Entries()
+	} else {
+		all, err = store.Find(IndexQuery{Status: "revoked"})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	byGeneration := map[int][]IndexEntry{}
+	for _, e := range all {
+		byGeneration[entryGeneration(e)] = append(byGeneration[entryGeneration(e)], e)
+	}
+	index := byGeneration[current.Number]
 
 	crlNumber, err := ReadCounter(crlnumPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read CRL number: %w", err)
 	}
+	audit.rec.CRLNumber = FormatSerial(crlNumber)
 
-	// Build revoked certificate entries (CON-DI-006: exactly the revoked set)
+	// A delta is computed against the published base, so it is exact whatever
+	// happened to the index since: base reason codes by serial
+	var base *x509.RevocationList
+	baseReasons := map[string]int{}
+	if delta {
+		if _, err := os.Stat(basePath); errors.Is(err, os.ErrNotExist) {
+			return nil, newCAError(KindConflict, "Error: no base CRL to build a delta CRL on. Run 'ca crl' first.")
+		}
+		if base, err = LoadCRL(basePath); err != nil {
+			return nil, fmt.Errorf("failed to load base CRL: %w", err)
+		}
+		for _, e := range base.RevokedCertificateEntries {
+			baseReasons[FormatSerialBig(e.SerialNumber)] = e.ReasonCode
+		}
+	}
+
+	// Build revoked certificate entries (CON-DI-006: exactly the revoked set,
+	// or for a delta its changes since the base)
 	var revokedEntries []x509.RevocationListEntry
+	removed := 0
 	for _, entry := range index {
+		if delta && entry.Status != "revoked" {
+			if _, inBase := baseReasons[entry.Serial]; inBase && entry.HoldReleasedAt != "" {
+				serial, ok := new(big.Int).SetString(entry.Serial, 16)
+				releasedAt, err := time.Parse(time.RFC3339, entry.HoldReleasedAt)
+				if !ok || err != nil {
+					return nil, fmt.Errorf("invalid hold release for serial %s", entry.Serial)
+				}
+				revokedEntries = append(revokedEntries, x509.RevocationListEntry{
+					SerialNumber:   serial,
+					RevocationTime: releasedAt,
+					ReasonCode:     8, // removeFromCRL
+				})
+				removed++
+			}
+			continue
+		}
 		if entry.Status != "revoked" {
 			continue
 		}
-
-		serial, err := strconv.ParseInt(entry.Serial, 16, 64)
+		if code, inBase := baseReasons[entry.Serial]; delta && inBase && code == ReasonCodes[entry.RevocationReason] {
+			continue // unchanged since the base
+		}
+		revoked, err := revocationEntry(entry)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse serial %s: %w", entry.Serial, err)
+			return nil, err
 		}
+		revokedEntries = append(revokedEntries, revoked)
+	}
 
-		revokedAt, err := time.Parse(time.RFC3339, entry.RevokedAt)
+	var extensions []pkix.Extension
+	if delta {
+		indicator, err := asn1.Marshal(base.Number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal delta CRL indicator: %w", err)
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidDeltaCRLIndicator, Critical: true, Value: indicator})
+	} else if len(config.DeltaCRLURLs) > 0 {
+		freshest, err := freshestCRLExtension(config.DeltaCRLURLs)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse revocation time for serial %s: %w", entry.Serial, err)
+			return nil, err
 		}
+		extensions = append(extensions, freshest)
+	}
+
+	// Sign CRL with CA key (CON-INV-005)
+	crlPEM, err := signCRL(caCert, caKey, revokedEntries, crlNumber, now, nextUpdate, config.RSAPSS, extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveDir := filepath.Join(dataDir, "crls") // absent in data directories from before the archive
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create CRL archive directory: %w", err)
+	}
+	archivePath := filepath.Join(archiveDir, FormatSerial(crlNumber)+".crl")
+
+	// STAGE + COMMIT (ADR-006): rename in order: archives, ca.crl or ca-delta.crl and
+	// the retired generations' CRLs, crlnumber
+	files := []stagedFile{
+		{archivePath, crlPEM, 0644}, // Archived copy, never rewritten
+		{crlPath, crlPEM, 0644},     // Published CRL updated next
+	}
+
+	result = &CRLResult{
+		ThisUpdate:   now,
+		NextUpdate:   nextUpdate,
+		CRLNumber:    crlNumber,
+		RevokedCount: len(revokedEntries) - removed,
+		CRLPath:      crlPath,
+		Delta:        delta,
+		RemovedCount: removed,
+		ArchivePath:  archivePath,
+	}
+	if delta {
+		result.BaseCRLNumber = base.Number.Int64()
+	}
+	if len(gens) > 1 {
+		result.Generation = current.Number
+	}
 
-		reasonCode, ok := ReasonCodes[entry.RevocationReason]
-		if !ok {
-			reasonCode = 0 // default to unspecified
+	// Each retired generation lists the revoked certificates it issued (CON-DI-006)
+	next := crlNumber + 1
+	for _, g := range retired {
+		var entries []x509.RevocationListEntry
+		for _, e := range byGeneration[g.Number] {
+			revoked, err := revocationEntry(e)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, revoked)
+		}
+		pemData, err := signCRL(g.Cert, retiredKeys[g.Number], entries, next, now, nextUpdate, config.RSAPSS, nil)
+		if err != nil {
+			return nil, err
 		}
+		gResult := &CRLResult{
+			ThisUpdate:   now,
+			NextUpdate:   nextUpdate,
+			CRLNumber:    next,
+			RevokedCount: len(entries),
+			CRLPath:      g.crlPath(),
+			ArchivePath:  filepath.Join(archiveDir, FormatSerial(next)+".crl"),
+			Generation:   g.Number,
+		}
+		files = append([]stagedFile{{gResult.ArchivePath, pemData, 0644}}, files...)
+		files = append(files, stagedFile{gResult.CRLPath, pemData, 0644})
+		result.Retired = append(result.Retired, gResult)
+		audit.rec.Detail += fmt.Sprintf(", generation %d CRL %s", g.Number, FormatSerial(next))
+		next++
+	}
+	files = append(files, stagedFile{crlnumPath, []byte(FormatSerial(next) + "\n"), 0644}) // Counter advanced after
+	if err := stageAndCommit(files); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-		revokedEntries = append(revokedEntries, x509.RevocationListEntry{
-			SerialNumber:   big.NewInt(serial),
-			RevocationTime: revokedAt,
-			ReasonCode:     reasonCode,
-		})
+// revocationEntry is the CRL entry of a revoked index entry.
+func revocationEntry(entry IndexEntry) (x509.RevocationListEntry, error) {
+	serial, ok := new(big.Int).SetString(entry.Serial, 16)
+	if !ok {
+		return x509.RevocationListEntry{}, fmt.Errorf("failed to parse serial %s", entry.Serial)
 	}
 
-	now := time.Now().UTC() // CON-DI-014: system clock
-	nextUpdate := now.Add(time.Duration(nextUpdateHours) * time.Hour)
+	revokedAt, err := time.Parse(time.RFC3339, entry.RevokedAt)
+	if err != nil {
+		return x509.RevocationListEntry{}, fmt.Errorf("failed to parse revocation time for serial %s: %w", entry.Serial, err)
+	}
 
+	reasonCode, ok := ReasonCodes[entry.RevocationReason]
+	if !ok {
+		reasonCode = 0 // default to unspecified
+	}
+
+	revoked := x509.RevocationListEntry{
+		SerialNumber:   serial,
+		RevocationTime: revokedAt,
+		ReasonCode:     reasonCode,
+	}
+	if entry.InvalidityDate != "" {
+		invalidity, err := time.Parse(time.RFC3339, entry.InvalidityDate)
+		if err != nil {
+			return x509.RevocationListEntry{}, fmt.Errorf("failed to parse invalidity date for serial %s: %w", entry.Serial, err)
+		}
+		value, err := asn1.MarshalWithParams(invalidity.UTC(), "generalized")
+		if err != nil {
+			return x509.RevocationListEntry{}, fmt.Errorf("failed to marshal invalidity date: %w", err)
+		}
+		revoked.ExtraExtensions = []pkix.Extension{{Id: oidInvalidityDate, Value: value}}
+	}
+	return revoked, nil
+}
+
+// signCRL builds and signs a CRL issued by caCert and returns it PEM-encoded.
+// Enforces CON-DI-013: CRL structure
+func signCRL(caCert *x509.Certificate, caKey crypto.Signer, entries []x509.RevocationListEntry, number int64, thisUpdate, nextUpdate time.Time, pss bool, extensions []pkix.Extension) ([]byte, error) {
 	// Build Authority Key Identifier extension (CON-DI-013)
 	akiValue, err := asn1.Marshal(struct {
 		KeyIdentifier []byte `asn1:"optional,tag:0"`
@@ -120,65 +352,37 @@ func GenerateCRL(dataDir string, nextUpdateHours int) (*CRLResult, error) {
 
 	// Build CRL template (CON-DI-013)
 	template := &x509.RevocationList{
-		RevokedCertificateEntries: revokedEntries,
-		Number:                    big.NewInt(crlNumber), // CON-INV-007
-		ThisUpdate:                now,
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(number), // CON-INV-007
+		ThisUpdate:                thisUpdate,
 		NextUpdate:                nextUpdate,
-		SignatureAlgorithm:        sigAlgorithm(caKey), // CON-INV-008: explicit SHA-256
-		ExtraExtensions: []pkix.Extension{
+		SignatureAlgorithm:        sigAlgorithm(caKey, pss), // CON-INV-008: hash matched to the key
+		ExtraExtensions: append([]pkix.Extension{
 			{
 				Id:       asn1.ObjectIdentifier{2, 5, 29, 35}, // AuthorityKeyIdentifier OID
 				Critical: false,
 				Value:    akiValue,
 			},
-		},
+		}, extensions...),
 	}
 
-	// Sign CRL with CA key (CON-INV-005)
-	signer, ok := caKey.(crypto.Signer)
-	if !ok {
-		return nil, fmt.Errorf("CA key does not implement crypto.Signer")
-	}
-	crlDER, err := x509.CreateRevocationList(rand.Reader, template, caCert, signer)
+	crlDER, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CRL: %w", err)
 	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER}), nil
+}
 
-	// STAGE SUB-PHASE (ADR-006)
-	tmpPaths := []string{
-		crlPath + ".tmp",
-		crlnumPath + ".tmp",
-	}
-
-	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER})
-	if err := os.WriteFile(crlPath+".tmp", crlPEM, 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to stage CRL: %w", err)
-	}
-
-	newCRLNumData := []byte(FormatSerial(crlNumber+1) + "\n")
-	if err := os.WriteFile(crlnumPath+".tmp", newCRLNumData, 0644); err != nil {
-		cleanupTempFiles(tmpPaths)
-		return nil, fmt.Errorf("failed to stage CRL number: %w", err)
-	}
-
-	// COMMIT SUB-PHASE (ADR-006): rename in order: ca.crl, crlnumber
-	commitOrder := []struct{ tmp, final string }{
-		{crlPath + ".tmp", crlPath},       // CRL updated first
-		{crlnumPath + ".tmp", crlnumPath}, // Counter advanced after
-	}
-	for _, c := range commitOrder {
-		if err := os.Rename(c.tmp, c.final); err != nil {
-			cleanupTempFiles(tmpPaths)
-			return nil, fmt.Errorf("failed to commit %s: %w", c.final, err)
+// deltaCRLBase returns the base CRL number named by a delta CRL's Delta CRL Indicator,
+// or false for a full CRL.
+func deltaCRLBase(crl *x509.RevocationList) (int64, bool) {
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidDeltaCRLIndicator) {
+			var base *big.Int
+			if _, err := asn1.Unmarshal(ext.Value, &base); err == nil && base.IsInt64() {
+				return base.Int64(), true
+			}
 		}
 	}
-
-	return &CRLResult{
-		ThisUpdate:   now,
-		NextUpdate:   nextUpdate,
-		CRLNumber:    crlNumber,
-		RevokedCount: len(revokedEntries),
-		CRLPath:      crlPath,
-	}, nil
+	return 0, false
 }
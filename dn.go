@@ -117,12 +117,8 @@ func ParseSANs(sanList string) (dnsNames []string, ips []net.IP, err error) {
 
 // AlgoDisplayName maps CLI key algorithm flags to display names.
 func AlgoDisplayName(keyAlgo string) string {
-	switch keyAlgo {
-	case "ecdsa-p256":
-		return "ECDSA P-256"
-	case "rsa-2048":
-		return "RSA 2048"
-	default:
-		return keyAlgo
+	if a, ok := lookupKeyAlgorithm(keyAlgo); ok {
+		return a.DisplayName
 	}
+	return keyAlgo
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies an operational failure so that each front-end (CLI exit
+// codes, HTTP statuses) can map it consistently.
+type ErrorKind int
+
+const (
+	KindInternal           ErrorKind = iota // I/O failures and anything unclassified
+	KindNotInitialized                      // REQ-ER-002
+	KindAlreadyInitialized                  // REQ-ER-005
+	KindNotFound                            // REQ-ER-003
+	KindConflict                            // REQ-ER-004: state does not allow the operation
+	KindInvalidInput                        // REQ-ER-001, REQ-ER-006, REQ-ER-008: rejected request data
+)
+
+// caError is an operational error with a kind. Its message is shown to the user
+// unchanged, so existing "Error: ..." texts keep their exact wording.
+type caError struct {
+	kind ErrorKind
+	msg  string
+}
+
+func (e *caError) Error() string {
+	return e.msg
+}
+
+// newCAError formats an operational error of the given kind.
+func newCAError(kind ErrorKind, format string, args ...interface{}) error {
+	return &caError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorKind returns the kind of err, or KindInternal if it carries none.
+func errorKind(err error) ErrorKind {
+	var ce *caError
+	if errors.As(err, &ce) {
+		return ce.kind
+	}
+	return KindInternal
+}
+
+// errorCodes are the stable names of the error kinds in structured CLI output.
+var errorCodes = map[ErrorKind]string{
+	KindInternal:           "internal",
+	KindNotInitialized:     "not_initialized",
+	KindAlreadyInitialized: "already_initialized",
+	KindNotFound:           "not_found",
+	KindConflict:           "conflict",
+	KindInvalidInput:       "invalid_input",
+}
+
+// errorCode returns the structured-output code for err's kind.
+func errorCode(err error) string {
+	return errorCodes[errorKind(err)]
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expiry levels, named and numbered as monitoring plugin states (Nagios, Icinga)
+// so that "ca expiring" can be used as a check command directly.
+const (
+	ExpiryOK       = 0
+	ExpiryWarning  = 1
+	ExpiryCritical = 2
+	ExpiryUnknown  = 3
+)
+
+// ExpiryLevelNames maps expiry levels to their plugin status names.
+var ExpiryLevelNames = map[int]string{
+	ExpiryOK:       "OK",
+	ExpiryWarning:  "WARNING",
+	ExpiryCritical: "CRITICAL",
+	ExpiryUnknown:  "UNKNOWN",
+}
+
+// ExpiringCert is a certificate whose NotAfter falls within the requested window.
+type ExpiringCert struct {
+	Serial        string    `json:"serial"` // "ca" for the CA certificate itself
+	Subject       string    `json:"subject"`
+	NotAfter      time.Time `json:"not_after"`
+	Status        string    `json:"status"`         // "active" or "expired"
+	RemainingDays int       `json:"remaining_days"` // whole days left, negative once expired
+	IsCA          bool      `json:"is_ca,omitempty"`
+}
+
+// ExpiryReport is the structured form of "ca expiring": the plugin status, the windows
+// as given on the command line and the certificates found.
+type ExpiryReport struct {
+	Status         string         `json:"status"`
+	Within         string         `json:"within"`
+	Warn           string         `json:"warn"`
+	Crit           string         `json:"crit"`
+	IncludeExpired string         `json:"include_expired,omitempty"`
+	Certificates   []ExpiringCert `json:"certificates"`
+}
+
+// ParseWindow parses a time window given as days ("30d", or a bare "30"), weeks ("2w")
+// or a Go duration ("36h").
+func ParseWindow(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	unit := 24 * time.Hour
+	num := s
+	switch {
+	case strings.HasSuffix(s, "d"):
+		num = strings.TrimSuffix(s, "d")
+	case strings.HasSuffix(s, "w"):
+		num = strings.TrimSuffix(s, "w")
+		unit = 7 * 24 * time.Hour
+	}
+	if n, err := strconv.Atoi(num); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("window %q is negative", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid window %q (use e.g. 30d, 2w or 36h)", s)
+	}
+	return d, nil
+}
+
+// ExpiringCerts returns the certificates that expire within the window from now,
+// soonest first. Certificates that have already expired are only included if they
+// expired within expiredWithin before now, so a check clears once that has passed.
+// Revoked certificates and certificates replaced by a renewal or rekey are skipped:
+// nothing should still depend on them. With includeCA the CA's own certificate is
+// checked too.
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-BD-014: status computed dynamically, read-only
+func ExpiringCerts(dataDir string, within time.Duration, expiredWithin time.Duration, includeCA bool) ([]ExpiringCert, error) {
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	now := time.Now().UTC() // CON-DI-014: system clock
+	horizon := now.Add(within)
+	since := now.Add(-expiredWithin)
+
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	// The expiry index yields only certificates ending within the window (inclusive)
+	index, err := store.Find(IndexQuery{Status: "active", ExpiresAfter: since, ExpiresBefore: horizon.Add(time.Second)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	var certs []ExpiringCert
+	add := func(serial, subject string, notAfter time.Time, isCA bool) {
+		if notAfter.After(horizon) || notAfter.Before(since) {
+			return
+		}
+		status := "active"
+		if now.After(notAfter) {
+			status = "expired"
+		}
+		certs = append(certs, ExpiringCert{
+			Serial:        serial,
+			Subject:       subject,
+			NotAfter:      notAfter,
+			Status:        status,
+			RemainingDays: int(notAfter.Sub(now).Hours() / 24),
+			IsCA:          isCA,
+		})
+	}
+
+	if includeCA {
+		caCert, err := LoadCertificate(filepath.Join(dataDir, "ca.crt"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+		}
+		add("ca", FormatDN(caCert.Subject), caCert.NotAfter.UTC(), true)
+	}
+	for _, entry := range index {
+		if entry.Status == "revoked" || entry.ReplacedBy != "" {
+			continue
+		}
+		notAfter, err := time.Parse(time.RFC3339, entry.NotAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid not_after for serial %s: %w", entry.Serial, err)
+		}
+		add(entry.Serial, entry.Subject, notAfter, entry.IsCA)
+	}
+
+	sort.SliceStable(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter) })
+	return certs, nil
+}
+
+// ExpiryLevel grades a set of expiring certificates: critical if any expires within crit
+// (or has expired), warning if any expires within warn, OK otherwise.
+func ExpiryLevel(certs []ExpiringCert, warn, crit time.Duration) int {
+	now := time.Now().UTC()
+	level := ExpiryOK
+	for _, c := range certs {
+		left := c.NotAfter.Sub(now)
+		if left <= crit {
+			return ExpiryCritical
+		}
+		if left <= warn {
+			level = ExpiryWarning
+		}
+	}
+	return level
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tempFileGrace is how old a *.tmp file must be before fsck treats it as orphaned.
+// acme.json is written under acmeLockFile rather than the data directory lock, so a
+// younger one may still be about to be renamed into place.
+const tempFileGrace = time.Minute
+
+// FsckIssue is one discrepancy found by "ca fsck".
+type FsckIssue struct {
+	Path     string `json:"path"`
+	Problem  string `json:"problem"`
+	Repair   string `json:"repair,omitempty"` // what --repair does; empty when it takes an operator
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckResult is the report of "ca fsck". Clean means no issue is left unrepaired.
+type FsckResult struct {
+	DataDir      string      `json:"data_dir"`
+	Certificates int         `json:"certificates"`
+	IndexEntries int         `json:"index_entries"`
+	CRLs         int         `json:"crls"`
+	Issues       []FsckIssue `json:"issues"`
+	Clean        bool        `json:"clean"`
+}
+
+// fsck carries the state of one CheckDataDir run.
+type fsck struct {
+	dataDir string
+	repair  bool
+	result  *FsckResult
+	gens    []*CAGeneration // oldest first, the current one last
+}
+
+// issue records a discrepancy. fix, if not nil, is what --repair does about it.
+func (c *fsck) issue(path string, problem string, repair string, fix func() error) {
+	is := FsckIssue{Path: path, Problem: problem}
+	if fix != nil {
+		is.Repair = repair
+		if c.repair {
+			if err := fix(); err != nil {
+				is.Problem += fmt.Sprintf(" (repair failed: %v)", err)
+			} else {
+				is.Repaired = true
+			}
+		}
+	}
+	c.result.Issues = append(c.result.Issues, is)
+}
+
+// CheckDataDir cross-checks the files of a data directory against each other: the CA
+// certificate against its key and chain, every certificate in certs/ against the CA
+// signature and the index, the serial and CRL number counters against what has been
+// issued, and ca.crl against the index (CON-DI-006). It also finds orphaned temporary
+// files and incomplete records at the end of index.db and audit.log. With repair it
+// fixes what can be fixed from the files themselves: a certificate without an index
+// entry, which a crash between the renames of an issuance leaves behind, gets one
+// rebuilt from the certificate (revoked if ca.crl lists it); counters are advanced
+// past what has been issued; temporary files and incomplete records are removed.
+// Enforces CON-INV-001: serial number uniqueness
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-DI-006: CRL-index consistency
+// Enforces CON-DI-007: certificate-index correspondence
+// Enforces CON-DI-008: serial counter consistency
+// Enforces CON-DI-009: CRL number counter consistency
+func CheckDataDir(dataDir string, repair bool) (result *FsckResult, err error) {
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	audit := startAudit(dataDir, "fsck")
+	defer func() {
+		if repair { // a check alone changes nothing
+			if result != nil {
+				audit.rec.Detail = fmt.Sprintf("%d issues, %d repaired", len(result.Issues), result.repaired())
+			}
+			audit.finish(err)
+		}
+	}()
+
+	// Held throughout, so the files are checked and repaired as one consistent state
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	c := &fsck{dataDir: dataDir, repair: repair, result: &FsckResult{DataDir: dataDir, Issues: []FsckIssue{}}}
+
+	c.checkRollover()
+	if c.gens, err = LoadGenerations(dataDir); err != nil {
+		return nil, err
+	}
+	caCerts := c.checkCA()
+	c.removeTempFiles()
+
+	// The index, and the certificates it describes
+	store, err := OpenIndex(dataDir)
+	var entries map[string]IndexEntry
+	if err != nil {
+		c.issue(filepath.Join(dataDir, indexDBFile), err.Error(), "", nil)
+	} else {
+		entries = c.checkIndex(store)
+	}
+	// Base CRLs by generation, checked with the other CRLs below
+	baseCRLs := map[int]*x509.RevocationList{}
+	for _, g := range c.gens {
+		if crl, err := LoadCRL(g.crlPath()); err == nil {
+			baseCRLs[g.Number] = crl
+		}
+	}
+	maxSerial, err := c.checkCertificates(caCerts, store, entries, baseCRLs)
+	if err != nil {
+		return nil, err
+	}
+	c.checkSerialCounter(maxSerial)
+
+	maxCRLNumber := c.checkCRLs()
+	c.checkCRLCounter(maxCRLNumber)
+	if entries != nil {
+		for _, g := range c.gens {
+			if crl := baseCRLs[g.Number]; crl != nil && crl.CheckSignatureFrom(g.Cert) == nil {
+				c.checkCRLConsistency(g, crl, entries)
+			}
+		}
+	}
+
+	c.checkAuditLog()
+
+	c.result.IndexEntries = len(entries)
+	c.result.Clean = c.result.repaired() == len(c.result.Issues)
+	return c.result, nil
+}
+
+// repaired counts the issues that were repaired.
+func (r *FsckResult) repaired() int {
+	n := 0
+	for _, is := range r.Issues {
+		if is.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// checkRollover finds a ca rollover that stopped before its commit point: a last
+// generations/NN/ that holds the current ca.crt, or no ca.crt at all. Repair puts back
+// the old ca.key if the new one was already renamed into place, and removes the
+// directory; the rollover can then be run again.
+func (c *fsck) checkRollover() {
+	numbers, err := retiredGenerationNumbers(c.dataDir)
+	if err != nil || len(numbers) == 0 {
+		return
+	}
+	dir := generationDir(c.dataDir, numbers[len(numbers)-1])
+	caCert, err := LoadCertificate(filepath.Join(c.dataDir, "ca.crt"))
+	if err != nil {
+		return
+	}
+	cert, err := LoadCertificate(filepath.Join(dir, "ca.crt"))
+	if err == nil && !cert.Equal(caCert) || err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	c.issue(dir, "is left over from an interrupted rollover", "restore ca.key from it and remove it", func() error {
+		keyPath := filepath.Join(c.dataDir, "ca.key")
+		if oldKey, err := os.ReadFile(filepath.Join(dir, "ca.key")); err == nil && cert != nil {
+			if current, err := os.ReadFile(keyPath); err != nil || !bytes.Equal(current, oldKey) {
+				if err := writeFileAtomic(keyPath, oldKey, 0600); err != nil {
+					return err
+				}
+			}
+		}
+		return os.RemoveAll(dir)
+	})
+}
+
+// checkCA checks that ca.crt chains to the root through chain.pem and that a plaintext
+// ca.key holds its key. Retired generations must be self-signed roots with their own
+// key, linked to their successor by the two cross certificates. It returns these CA
+// certificates, whose serials no other certificate may reuse.
+func (c *fsck) checkCA() []*x509.Certificate {
+	current := c.gens[len(c.gens)-1]
+	caCertPath := filepath.Join(c.dataDir, "ca.crt")
+	chain, err := LoadChain(c.dataDir)
+	if err != nil {
+		c.issue(filepath.Join(c.dataDir, "chain.pem"), err.Error(), "", nil)
+	} else if _, err := buildChain(current.Cert, append(chain, current.Cert)); err != nil {
+		c.issue(caCertPath, fmt.Sprintf("does not chain to a root: %v", err), "", nil)
+	}
+	certs := []*x509.Certificate{current.Cert}
+
+	config, err := LoadConfig(c.dataDir)
+	if err != nil {
+		c.issue(filepath.Join(c.dataDir, "config.json"), strings.TrimPrefix(err.Error(), "Error: "), "", nil)
+		return certs
+	}
+	for i, g := range c.gens {
+		keyPath := filepath.Join(g.Dir, "ca.key")
+		if !g.Current {
+			certs = append(certs, g.Cert)
+			if _, err := buildChain(g.Cert, []*x509.Certificate{g.Cert}); err != nil {
+				c.issue(filepath.Join(g.Dir, "ca.crt"), fmt.Sprintf("is not a self-signed root: %v", err), "", nil)
+			}
+			certs = append(certs, c.checkCrossCertificates(g, c.gens[i+1])...)
+		}
+		if encrypted, err := IsKeyEncrypted(keyPath); err != nil || encrypted {
+			continue // held by a signer provider, or unreadable without the passphrase
+		}
+		if g.Current {
+			key, err := LoadPrivateKey(keyPath, nil)
+			if err != nil {
+				c.issue(keyPath, err.Error(), "", nil)
+				continue
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				c.issue(keyPath, "does not hold a signing key", "", nil)
+			} else if err := checkSignerKey(c.dataDir, config, signer); err != nil {
+				c.issue(keyPath, strings.TrimPrefix(err.Error(), "Error: "), "", nil)
+			}
+		} else if _, err := g.loadSigner(config, nil); err != nil {
+			c.issue(keyPath, strings.TrimPrefix(err.Error(), "Error: "), "", nil)
+		}
+	}
+	return certs
+}
+
+// checkCrossCertificates checks that the cross certificates in a retired generation's
+// directory certify each key with the other, and returns them.
+func (c *fsck) checkCrossCertificates(g, next *CAGeneration) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, cross := range []struct {
+		name            string
+		subject, signer *CAGeneration
+	}{{newWithOldFile, next, g}, {oldWithNewFile, g, next}} {
+		path := filepath.Join(g.Dir, cross.name)
+		cert, err := LoadCertificate(path)
+		if err != nil {
+			c.issue(path, err.Error(), "", nil)
+			continue
+		}
+		certs = append(certs, cert)
+		want, _ := publicKeyBytes(cross.subject.Cert.PublicKey)
+		got, _ := publicKeyBytes(cert.PublicKey)
+		switch {
+		case !bytes.Equal(want, got):
+			c.issue(path, fmt.Sprintf("does not certify the key of generation %d", cross.subject.Number), "", nil)
+		case cert.CheckSignatureFrom(cross.signer.Cert) != nil:
+			c.issue(path, fmt.Sprintf("is not signed by generation %d", cross.signer.Number), "", nil)
+		}
+	}
+	return certs
+}
+
+// removeTempFiles finds the temporary files of interrupted atomic writes.
+func (c *fsck) removeTempFiles() {
+	dirs := []string{c.dataDir, filepath.Join(c.dataDir, "certs"), filepath.Join(c.dataDir, "crls")}
+	for _, g := range c.gens[:len(c.gens)-1] {
+		dirs = append(dirs, g.Dir)
+	}
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < tempFileGrace {
+				continue
+			}
+			path := path
+			c.issue(path, "orphaned temporary file of an interrupted write", "remove it", func() error {
+				return os.Remove(path)
+			})
+		}
+	}
+}
+
+// checkIndex looks for incomplete or duplicate records and returns the entries by serial.
+func (c *fsck) checkIndex(store IndexStore) map[string]IndexEntry {
+	switch s := store.(type) {
+	case *logStore:
+		torn, err := s.tornTail()
+		if err != nil {
+			c.issue(s.path, err.Error(), "", nil)
+			return nil
+		}
+		if torn > 0 {
+			c.issue(s.path, fmt.Sprintf("ends in an incomplete transaction (%d bytes)", torn), "truncate it", s.cutTornTail)
+		}
+		if err := s.checkKeys(); err != nil {
+			c.issue(s.keysPath(), err.Error(), "rebuild it", s.rebuildKeys)
+		}
+	case jsonStore:
+		// index.json entries are a list, which unlike index.db may name a serial twice
+		if data, err := os.ReadFile(s.path); err == nil {
+			var list []IndexEntry
+			if json.Unmarshal(data, &list) == nil {
+				seen := map[string]bool{}
+				for _, e := range list {
+					if seen[e.Serial] {
+						c.issue(s.path, fmt.Sprintf("serial %s has more than one entry", e.Serial), "", nil)
+					}
+					seen[e.Serial] = true
+				}
+			}
+		}
+	}
+	list, err := store.Entries()
+	if err != nil {
+		c.issue(filepath.Join(c.dataDir, indexDBFile), err.Error(), "", nil)
+		return nil
+	}
+	entries := make(map[string]IndexEntry, len(list))
+	for _, e := range list {
+		entries[e.Serial] = e
+	}
+	return entries
+}
+
+// checkCertificates checks every file in certs/ and every index entry against each
+// other, and returns the highest serial that the counter could have issued.
+func (c *fsck) checkCertificates(caCerts []*x509.Certificate, store IndexStore, entries map[string]IndexEntry, baseCRLs map[int]*x509.RevocationList) (*big.Int, error) {
+	maxSerial := new(big.Int)
+	counted := func(serial *big.Int) {
+		// Counter serials fit the int64 counter; a 128-bit random serial never does in practice
+		if serial.BitLen() < 64 && serial.Cmp(maxSerial) > 0 {
+			maxSerial.Set(serial)
+		}
+	}
+	// A root's own certificates draw from the counter; an intermediate's come from its parent
+	caCert := c.gens[len(c.gens)-1].Cert
+	selfSigned := bytes.Equal(caCert.RawSubject, caCert.RawIssuer)
+	if selfSigned {
+		for _, ca := range caCerts {
+			counted(ca.SerialNumber)
+		}
+	}
+
+	certsDir := filepath.Join(c.dataDir, "certs")
+	paths, err := filepath.Glob(filepath.Join(certsDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	onDisk := map[string]bool{}
+	for _, path := range paths {
+		cert, err := LoadCertificate(path)
+		if err != nil {
+			c.issue(path, err.Error(), "", nil)
+			continue
+		}
+		c.result.Certificates++
+		serialHex := FormatSerialBig(cert.SerialNumber)
+		onDisk[serialHex] = true
+		counted(cert.SerialNumber)
+
+		if name := strings.TrimSuffix(filepath.Base(path), ".pem"); name != serialHex {
+			c.issue(path, fmt.Sprintf("holds the certificate with serial %s", serialHex), "", nil)
+			continue
+		}
+		gen := generationOf(c.gens, cert)
+		if gen == nil {
+			err := cert.CheckSignatureFrom(caCert)
+			c.issue(path, fmt.Sprintf("is not signed by the CA certificate: %v", err), "", nil)
+			continue
+		}
+		for _, ca := range caCerts {
+			// ca rollover files the certificates it issues in certs/ too
+			if selfSigned && cert.SerialNumber.Cmp(ca.SerialNumber) == 0 && !cert.Equal(ca) {
+				c.issue(path, fmt.Sprintf("reuses serial %s of a CA certificate", serialHex), "", nil) // CON-INV-001
+			}
+		}
+		if entries == nil {
+			continue
+		}
+
+		entry, ok := entries[serialHex]
+		if !ok {
+			rebuilt := entryFromCertificate(cert, gen, baseCRLs[gen.Number])
+			c.issue(path, "has no index entry", "add one rebuilt from the certificate", func() error {
+				if err := store.Commit(rebuilt); err != nil {
+					return err
+				}
+				entries[serialHex] = rebuilt
+				return nil
+			})
+			continue
+		}
+		if diff := entryMismatch(entry, cert, gen); diff != "" {
+			fixed := entry
+			fixed.Subject = FormatDN(cert.Subject)
+			fixed.NotBefore = cert.NotBefore.UTC().Format(time.RFC3339)
+			fixed.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+			fixed.IsCA = cert.IsCA
+			fixed.Generation = issuedGeneration(gen.Number)
+			c.issue(path, "index entry has a different "+diff, "correct the entry from the certificate", func() error {
+				if err := store.Commit(fixed); err != nil {
+					return err
+				}
+				entries[serialHex] = fixed
+				return nil
+			})
+		}
+	}
+
+	// Index entries need their certificate (CON-DI-007)
+	serials := make([]string, 0, len(entries))
+	for serial := range entries {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+	for _, serial := range serials {
+		if n, ok := new(big.Int).SetString(serial, 16); ok {
+			counted(n)
+		}
+		if !onDisk[serial] {
+			c.issue(filepath.Join(certsDir, serial+".pem"), "is missing; the index has an entry for it", "", nil)
+		}
+	}
+	return maxSerial, nil
+}
+
+// entryFromCertificate rebuilds the index entry of cert, which gen issued. Its status
+// comes from gen's base CRL; the profile it was issued under is not recorded in the
+// certificate.
+func entryFromCertificate(cert *x509.Certificate, gen *CAGeneration, baseCRL *x509.RevocationList) IndexEntry {
+	e := IndexEntry{
+		Serial:    FormatSerialBig(cert.SerialNumber),
+		Subject:   FormatDN(cert.Subject),
+		NotBefore: cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:  cert.NotAfter.UTC().Format(time.RFC3339),
+		Status:    "active",
+		IsCA:      cert.IsCA,
+		SANs:      indexSANs(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs),
+	}
+	e.Generation = issuedGeneration(gen.Number)
+	if baseCRL == nil {
+		return e
+	}
+	for _, r := range baseCRL.RevokedCertificateEntries {
+		if r.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		e.Status = "revoked"
+		e.RevokedAt = r.RevocationTime.UTC().Format(time.RFC3339)
+		e.RevocationReason = "unspecified"
+		for name, code := range ReasonCodes {
+			if code == r.ReasonCode {
+				e.RevocationReason = name
+			}
+		}
+	}
+	return e
+}
+
+// entryMismatch names the first field in which entry disagrees with its certificate,
+// which gen issued.
+func entryMismatch(entry IndexEntry, cert *x509.Certificate, gen *CAGeneration) string {
+	switch {
+	case entry.Subject != FormatDN(cert.Subject):
+		return "subject"
+	case entry.NotBefore != cert.NotBefore.UTC().Format(time.RFC3339):
+		return "not_before"
+	case entry.NotAfter != cert.NotAfter.UTC().Format(time.RFC3339):
+		return "not_after"
+	case entry.IsCA != cert.IsCA:
+		return "is_ca"
+	case entryGeneration(entry) != gen.Number:
+		return "generation"
+	}
+	return ""
+}
+
+// checkSerialCounter checks that the serial counter is past every counter serial issued.
+func (c *fsck) checkSerialCounter(maxSerial *big.Int) {
+	path := filepath.Join(c.dataDir, "serial")
+	next := maxSerial.Int64() + 1
+	if next < 2 {
+		next = 2 // the root has 01
+	}
+	fix := func() error { return WriteCounter(path, next) }
+	val, err := ReadCounter(path)
+	switch {
+	case err != nil:
+		c.issue(path, err.Error(), "rewrite it as "+FormatSerial(next), fix)
+	case val < next:
+		c.issue(path, fmt.Sprintf("counter %s would reissue serial %s", FormatSerial(val), FormatSerialBig(maxSerial)), "advance it to "+FormatSerial(next), fix) // CON-DI-008
+	}
+}
+
+// checkCRLs checks the signature of every CRL, published or archived, and returns the
+// highest CRL number issued. A published CRL must be signed by its generation's key,
+// an archived one by the key of any generation.
+func (c *fsck) checkCRLs() int64 {
+	var maxNumber int64
+	type crlFile struct {
+		path     string
+		gen      *CAGeneration // nil for the archive
+		archived bool
+	}
+	var files []crlFile
+	for i := len(c.gens) - 1; i >= 0; i-- {
+		g := c.gens[i]
+		files = append(files, crlFile{g.crlPath(), g, false}, crlFile{filepath.Join(g.Dir, "ca-delta.crl"), g, false})
+	}
+	paths, _ := filepath.Glob(filepath.Join(c.dataDir, "crls", "*.crl"))
+	sort.Strings(paths)
+	for _, path := range paths {
+		files = append(files, crlFile{path, nil, true})
+	}
+	for _, f := range files {
+		path := f.path
+		crl, err := LoadCRL(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			c.issue(path, err.Error(), "", nil)
+			continue
+		}
+		if f.archived {
+			c.result.CRLs++
+		}
+		signer := crlGeneration(c.gens, crl)
+		switch {
+		case signer == nil:
+			err := crl.CheckSignatureFrom(c.gens[len(c.gens)-1].Cert)
+			c.issue(path, fmt.Sprintf("is not signed by the CA certificate: %v", err), "", nil)
+		case f.gen != nil && signer != f.gen && f.gen.Current:
+			// The old generation's CRL, still published after a rollover that did not get to remove it
+			c.issue(path, fmt.Sprintf("is a CRL of generation %d, left over from a rollover", signer.Number), "remove it", func() error {
+				return os.Remove(path)
+			})
+		case f.gen != nil && signer != f.gen:
+			c.issue(path, fmt.Sprintf("is signed by generation %d, not %d", signer.Number, f.gen.Number), "", nil)
+		}
+		if crl.Number == nil {
+			c.issue(path, "has no CRL number", "", nil)
+			continue
+		}
+		if f.archived && strings.TrimSuffix(filepath.Base(path), ".crl") != FormatSerialBig(crl.Number) {
+			c.issue(path, fmt.Sprintf("holds CRL number %s", FormatSerialBig(crl.Number)), "", nil)
+		}
+		if crl.Number.IsInt64() && crl.Number.Int64() > maxNumber {
+			maxNumber = crl.Number.Int64()
+		}
+	}
+	return maxNumber
+}
+
+// checkCRLCounter checks that the CRL number counter is past every CRL issued.
+func (c *fsck) checkCRLCounter(maxNumber int64) {
+	path := filepath.Join(c.dataDir, "crlnumber")
+	next := maxNumber + 1
+	fix := func() error { return WriteCounter(path, next) }
+	val, err := ReadCounter(path)
+	switch {
+	case err != nil:
+		c.issue(path, err.Error(), "rewrite it as "+FormatSerial(next), fix)
+	case val < next:
+		c.issue(path, fmt.Sprintf("counter %s would reuse CRL number %s", FormatSerial(val), FormatSerial(maxNumber)), "advance it to "+FormatSerial(next), fix) // CON-DI-009
+	}
+}
+
+// checkCRLConsistency compares the base CRL of a generation with the index as of the
+// CRL's thisUpdate: it lists exactly the certificates of that generation revoked by
+// then (CON-DI-006). Revocations since are for the next CRL, and a hold released since
+// may still be listed.
+func (c *fsck) checkCRLConsistency(gen *CAGeneration, crl *x509.RevocationList, entries map[string]IndexEntry) {
+	path := gen.crlPath()
+	listed := map[string]bool{}
+	for _, r := range crl.RevokedCertificateEntries {
+		serial := FormatSerialBig(r.SerialNumber)
+		listed[serial] = true
+		e, ok := entries[serial]
+		switch {
+		case !ok:
+			c.issue(path, fmt.Sprintf("lists serial %s, which the index does not have", serial), "", nil)
+		case entryGeneration(e) != gen.Number:
+			c.issue(path, fmt.Sprintf("lists serial %s, which generation %d issued", serial, entryGeneration(e)), "", nil)
+		case e.Status != "revoked" && !after(e.HoldReleasedAt, crl.ThisUpdate):
+			c.issue(path, fmt.Sprintf("lists serial %s, which the index has as %s", serial, e.Status), "", nil)
+		}
+	}
+	serials := make([]string, 0, len(entries))
+	for serial, e := range entries {
+		if e.Status == "revoked" && entryGeneration(e) == gen.Number && !listed[serial] && !after(e.RevokedAt, crl.ThisUpdate) {
+			serials = append(serials, serial)
+		}
+	}
+	sort.Strings(serials)
+	for _, serial := range serials {
+		c.issue(path, fmt.Sprintf("does not list serial %s, revoked at %s", serial, entries[serial].RevokedAt), "", nil)
+	}
+}
+
+// after reports whether the RFC 3339 timestamp ts lies after t.
+func after(ts string, t time.Time) bool {
+	parsed, err := time.Parse(time.RFC3339, ts)
+	return err == nil && parsed.After(t)
+}
+
+// checkAuditLog finds a record torn by a crash at the end of audit.log. Everything
+// else about the log is for "ca audit verify".
+func (c *fsck) checkAuditLog() {
+	path := filepath.Join(c.dataDir, auditLogFile)
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 || data[len(data)-1] == '\n' {
+		return
+	}
+	keep := int64(bytes.LastIndexByte(data, '\n') + 1)
+	c.issue(path, fmt.Sprintf("ends in an incomplete record (%d bytes)", int64(len(data))-keep), "truncate it", func() error {
+		unlock, err := acquireLock(filepath.Join(c.dataDir, auditLockFile), "audit log "+c.dataDir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return os.Truncate(path, keep)
+	})
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// index.keys holds the secondary indexes of index.db on disk, so that a command finds
+// a serial, subject, SAN, status or expiry range with a binary search and reads only
+// the frames holding the matches, instead of replaying the whole log. It describes
+// the log up to a recorded length; the frames appended after that are replayed in
+// memory, and the file is rewritten under the data directory lock once they number
+// indexKeysEvery records, or when the log is compacted or migrated. Layout, with
+// big-endian integers:
+//
+//	"CAKEYS1\n"
+//	log length (8), offset (8) and CRC-32C (4) of the last frame it covers,
+//	records (8) and live entries (8) in the covered frames
+//	per section, serial, subject, SAN, status and expiry: offset (8) and count (8)
+//	the sections: [16-byte key][8-byte frame offset][4-byte position in the frame][4-byte issuance ordinal]
+//
+// Records are sorted by key within a section. A serial, subject, SAN or status key is
+// the first 16 bytes of the value's SHA-256; an expiry key is the not_after Unix time
+// plus 2^63, so that it sorts as unsigned, padded with zeros. Each record points at
+// the latest record of its serial in the covered log, and every entry read through it
+// is checked against the query, so a hash collision costs a read, not a wrong answer.
+// The file is only an accelerator: one that does not match index.db is ignored and
+// rewritten by the next commit, and ca fsck checks it against the log.
+const (
+	indexKeysFile   = "index.keys"
+	indexKeysMagic  = "CAKEYS1\n"
+	indexKeysEvery  = 1024 // records appended after index.keys before it is rewritten
+	indexKeyRecord  = 32
+	indexKeysHeader = len(indexKeysMagic) + 8 + 8 + 4 + 8 + 8 + indexKeySections*16
+)
+
+// Sections of index.keys.
+const (
+	keySerial = iota
+	keySubject
+	keySAN
+	keyStatus
+	keyExpiry
+	indexKeySections
+)
+
+type indexKey [16]byte
+
+func hashKey(value string) indexKey {
+	var k indexKey
+	sum := sha256.Sum256([]byte(value))
+	copy(k[:], sum[:])
+	return k
+}
+
+func expiryIndexKey(t time.Time) indexKey {
+	var k indexKey
+	binary.BigEndian.PutUint64(k[:8], uint64(t.Unix())+1<<63)
+	return k
+}
+
+// recordLoc locates the record of an entry in index.db.
+type recordLoc struct {
+	frame int64 // offset of its frame
+	pos   int   // index in the frame's JSON array
+	ord   int   // issuance ordinal: the entry's position in issuance order
+}
+
+// indexKeys is an open index.keys that matches the log it was read against.
+type indexKeys struct {
+	f        *os.File
+	info     os.FileInfo
+	logSize  int64
+	lastOff  int64
+	lastCRC  uint32
+	records  int
+	live     int
+	sections [indexKeySections]struct{ off, count int64 }
+}
+
+// openIndexKeys opens path if it describes a prefix of the log in f, which is size
+// bytes long. It returns nil when the file does not exist or describes another log.
+func openIndexKeys(path string, log *os.File, size int64) (*indexKeys, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	k, err := readIndexKeysHeader(f)
+	if err != nil || !k.covers(log, size) {
+		f.Close()
+		return nil, nil
+	}
+	return k, nil
+}
+
+func readIndexKeysHeader(f *os.File) (*indexKeys, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, indexKeysHeader)
+	if _, err := f.ReadAt(header, 0); err != nil || string(header[:len(indexKeysMagic)]) != indexKeysMagic {
+		return nil, errors.New("not an index keys file")
+	}
+	k := &indexKeys{f: f, info: info}
+	h := header[len(indexKeysMagic):]
+	k.logSize = int64(binary.BigEndian.Uint64(h[0:]))
+	k.lastOff = int64(binary.BigEndian.Uint64(h[8:]))
+	k.lastCRC = binary.BigEndian.Uint32(h[16:])
+	k.records = int(binary.BigEndian.Uint64(h[20:]))
+	k.live = int(binary.BigEndian.Uint64(h[28:]))
+	h = h[36:]
+	for i := range k.sections {
+		k.sections[i].off = int64(binary.BigEndian.Uint64(h[16*i:]))
+		k.sections[i].count = int64(binary.BigEndian.Uint64(h[16*i+8:]))
+		s := k.sections[i]
+		if s.off < int64(indexKeysHeader) || s.count < 0 || s.off+s.count*indexKeyRecord > info.Size() {
+			return nil, errors.New("index keys section out of range")
+		}
+	}
+	return k, nil
+}
+
+// covers reports whether k was written for the first k.logSize bytes of the log:
+// the frame it names last must end there and carry the recorded checksum.
+func (k *indexKeys) covers(log *os.File, size int64) bool {
+	if k.logSize > size || k.logSize < int64(len(indexDBMagic)) {
+		return false
+	}
+	if k.logSize == int64(len(indexDBMagic)) {
+		return k.lastOff == 0 && k.records == 0
+	}
+	var header [indexFrameHeader]byte
+	if _, err := log.ReadAt(header[:], k.lastOff); err != nil {
+		return false
+	}
+	n := int64(binary.BigEndian.Uint32(header[0:4]))
+	return k.lastOff+indexFrameHeader+n == k.logSize && binary.BigEndian.Uint32(header[4:8]) == k.lastCRC
+}
+
+func (k *indexKeys) close() {
+	k.f.Close()
+}
+
+// record reads record i of section s.
+func (k *indexKeys) record(s int, i int64) (indexKey, recordLoc, error) {
+	var buf [indexKeyRecord]byte
+	if _, err := k.f.ReadAt(buf[:], k.sections[s].off+i*indexKeyRecord); err != nil {
+		return indexKey{}, recordLoc{}, fmt.Errorf("failed to read %s: %w", indexKeysFile, err)
+	}
+	var key indexKey
+	copy(key[:], buf[:16])
+	return key, recordLoc{
+		frame: int64(binary.BigEndian.Uint64(buf[16:24])),
+		pos:   int(binary.BigEndian.Uint32(buf[24:28])),
+		ord:   int(binary.BigEndian.Uint32(buf[28:32])),
+	}, nil
+}
+
+// search returns the first record of section s whose key is not below key.
+func (k *indexKeys) search(s int, key indexKey) (int64, error) {
+	lo, hi := int64(0), k.sections[s].count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		got, _, err := k.record(s, mid)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Compare(got[:], key[:]) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// span returns the records of section s with keys from lo up to but excluding hi.
+func (k *indexKeys) span(s int, lo, hi indexKey) (from, to int64, err error) {
+	if from, err = k.search(s, lo); err != nil {
+		return 0, 0, err
+	}
+	to, err = k.search(s, hi)
+	return from, to, err
+}
+
+// exact returns the records of section s with key.
+func (k *indexKeys) exact(s int, key indexKey) (from, to int64, err error) {
+	next := key
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			return k.span(s, key, next)
+		}
+	}
+	from, err = k.search(s, key)
+	return from, k.sections[s].count, err
+}
+
+// locs reads the locations of records from up to to of section s.
+func (k *indexKeys) locs(s int, from, to int64) ([]recordLoc, error) {
+	if to <= from {
+		return nil, nil
+	}
+	buf := make([]byte, (to-from)*indexKeyRecord)
+	if _, err := k.f.ReadAt(buf, k.sections[s].off+from*indexKeyRecord); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read %s: %w", indexKeysFile, err)
+	}
+	locs := make([]recordLoc, to-from)
+	for i := range locs {
+		r := buf[i*indexKeyRecord:]
+		locs[i] = recordLoc{
+			frame: int64(binary.BigEndian.Uint64(r[16:24])),
+			pos:   int(binary.BigEndian.Uint32(r[24:28])),
+			ord:   int(binary.BigEndian.Uint32(r[28:32])),
+		}
+	}
+	return locs, nil
+}
+
+// ordOf returns the issuance ordinal of serial among the covered entries.
+func (k *indexKeys) ordOf(serial string) (int, bool, error) {
+	from, to, err := k.exact(keySerial, hashKey(serial))
+	if err != nil || from == to {
+		return 0, false, err
+	}
+	locs, err := k.locs(keySerial, from, to)
+	if err != nil {
+		return 0, false, err
+	}
+	return locs[0].ord, true, nil
+}
+
+// readFrameEntries reads the entries of the frame at off in the log.
+func readFrameEntries(log *os.File, off int64) ([]IndexEntry, error) {
+	var header [indexFrameHeader]byte
+	if _, err := log.ReadAt(header[:], off); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := log.ReadAt(payload, off+indexFrameHeader); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if crc32.Checksum(payload, indexCRC) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("failed to read index: frame at offset %d is corrupt (checksum mismatch)", off)
+	}
+	var batch []IndexEntry
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return nil, fmt.Errorf("failed to read index: frame at offset %d is corrupt: %v", off, err)
+	}
+	return batch, nil
+}
+
+// marshalIndexKeys returns the index.keys content for table t, which holds every
+// record of the first logSize bytes of the log, whose last frame is at lastOff.
+func marshalIndexKeys(t *indexTable, logSize int64, lastOff int64, lastCRC uint32, records int) []byte {
+	type keyRecord struct {
+		key indexKey
+		loc recordLoc
+	}
+	var sections [indexKeySections][]keyRecord
+	for i, e := range t.entries {
+		loc := t.locs[i]
+		loc.ord = i
+		add := func(s int, key indexKey) {
+			sections[s] = append(sections[s], keyRecord{key, loc})
+		}
+		add(keySerial, hashKey(e.Serial))
+		add(keySubject, hashKey(e.Subject))
+		add(keyStatus, hashKey(e.Status))
+		for _, san := range e.SANs {
+			add(keySAN, hashKey(san))
+		}
+		if notAfter, err := time.Parse(time.RFC3339, e.NotAfter); err == nil {
+			add(keyExpiry, expiryIndexKey(notAfter))
+		}
+	}
+
+	data := make([]byte, indexKeysHeader)
+	copy(data, indexKeysMagic)
+	h := data[len(indexKeysMagic):]
+	binary.BigEndian.PutUint64(h[0:], uint64(logSize))
+	binary.BigEndian.PutUint64(h[8:], uint64(lastOff))
+	binary.BigEndian.PutUint32(h[16:], lastCRC)
+	binary.BigEndian.PutUint64(h[20:], uint64(records))
+	binary.BigEndian.PutUint64(h[28:], uint64(len(t.entries)))
+	table := len(indexKeysMagic) + 36 // the section table; data grows below, so it is addressed by offset
+	for s, recs := range sections {
+		sort.Slice(recs, func(i, j int) bool {
+			if c := bytes.Compare(recs[i].key[:], recs[j].key[:]); c != 0 {
+				return c < 0
+			}
+			return recs[i].loc.ord < recs[j].loc.ord
+		})
+		binary.BigEndian.PutUint64(data[table+16*s:], uint64(len(data)))
+		binary.BigEndian.PutUint64(data[table+16*s+8:], uint64(len(recs)))
+		for _, r := range recs {
+			var buf [indexKeyRecord]byte
+			copy(buf[:16], r.key[:])
+			binary.BigEndian.PutUint64(buf[16:24], uint64(r.loc.frame))
+			binary.BigEndian.PutUint32(buf[24:28], uint32(r.loc.pos))
+			binary.BigEndian.PutUint32(buf[28:32], uint32(r.loc.ord))
+			data = append(data, buf[:]...)
+		}
+	}
+	return data
+}
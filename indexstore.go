@@ -0,0 +1,1013 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The certificate index lives in index.db, an append-only log of transactions.
+// After an 8-byte header each transaction is one frame:
+//
+//	[4-byte big-endian payload length][4-byte CRC-32C of the payload][payload]
+//
+// where the payload is a JSON array of index entries; a later record for a serial
+// supersedes earlier ones. A commit appends and fsyncs one frame, so it is atomic and
+// durable: a frame torn by a crash runs past the end of the file or fails its checksum,
+// is treated as never committed and is cut off by the next commit. Once superseded
+// records outnumber live ones the log is rewritten as a snapshot. The serial, subject,
+// SAN, status and expiry indexes are kept on disk in index.keys (indexkeys.go) for all
+// but the latest frames, which a process replays in memory.
+//
+// index.json, the array the index used to be, is still read and written in data
+// directories that have not run "ca migrate-store"; "ca export-index" produces it on demand.
+const (
+	indexDBFile   = "index.db"
+	indexJSONFile = "index.json"
+
+	indexDBMagic     = "CAIDX01\n"
+	indexFrameHeader = 8
+	indexBatchSize   = 1024 // entries per frame when writing a snapshot
+	indexCompactMin  = 4096 // records before superseded ones are worth compacting away
+)
+
+var indexCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// IndexStore is the certificate index of one data directory. Mutations run under
+// the data directory lock (ADR-007); reads need no lock.
+// Enforces CON-DI-005: index schema completeness
+type IndexStore interface {
+	// Get returns a copy of the entry for serial, or nil if there is none.
+	Get(serial string) (*IndexEntry, error)
+	// Entries returns every entry in issuance order.
+	Entries() ([]IndexEntry, error)
+	// Find returns the entries matching q in issuance order.
+	Find(q IndexQuery) ([]IndexEntry, error)
+	// Commit adds entries, replacing any with the same serial, in one atomic transaction.
+	Commit(entries ...IndexEntry) error
+}
+
+// IndexQuery selects index entries. Zero fields match everything.
+type IndexQuery struct {
+	Subject       string    // exact subject DN
+	SAN           string    // "dns:www.example.com", "ip:10.0.0.1", "email:…", "uri:…", or a bare name of any type
+	Status        string    // stored status: "active" or "revoked"
+	ExpiresBefore time.Time // not_after before this instant
+	ExpiresAfter  time.Time // not_after at or after this instant
+}
+
+// indexSANs returns the index keys of a certificate's subject alternative names,
+// each prefixed by its profile SAN type (sanTypes).
+func indexSANs(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	var sans []string
+	for _, name := range dnsNames {
+		sans = append(sans, normalizeSAN("dns", name))
+	}
+	for _, ip := range ips {
+		sans = append(sans, "ip:"+ip.String())
+	}
+	for _, email := range emails {
+		sans = append(sans, normalizeSAN("email", email))
+	}
+	for _, u := range uris {
+		sans = append(sans, "uri:"+u.String())
+	}
+	return sans
+}
+
+func normalizeSAN(typ, name string) string {
+	switch typ {
+	case "dns", "email":
+		name = strings.ToLower(name)
+	case "ip":
+		if ip := net.ParseIP(name); ip != nil {
+			name = ip.String()
+		}
+	}
+	return typ + ":" + name
+}
+
+// sanKeys returns the index keys a SAN query matches: one for a typed query,
+// one per type for a bare name.
+func (q IndexQuery) sanKeys() []string {
+	if typ, name, ok := strings.Cut(q.SAN, ":"); ok && contains(sanTypes, typ) {
+		return []string{normalizeSAN(typ, name)}
+	}
+	keys := make([]string, 0, len(sanTypes))
+	for _, typ := range sanTypes {
+		keys = append(keys, normalizeSAN(typ, q.SAN))
+	}
+	return keys
+}
+
+// matches reports whether e satisfies every field of q.
+func (q IndexQuery) matches(e *IndexEntry) bool {
+	if q.Subject != "" && e.Subject != q.Subject {
+		return false
+	}
+	if q.Status != "" && e.Status != q.Status {
+		return false
+	}
+	if q.SAN != "" {
+		found := false
+		for _, key := range q.sanKeys() {
+			found = found || contains(e.SANs, key)
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.ExpiresBefore.IsZero() || !q.ExpiresAfter.IsZero() {
+		notAfter, err := time.Parse(time.RFC3339, e.NotAfter)
+		if err != nil || !q.ExpiresBefore.IsZero() && !notAfter.Before(q.ExpiresBefore) || notAfter.Before(q.ExpiresAfter) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexTable holds the entries of an index with its secondary indexes.
+type indexTable struct {
+	entries   []IndexEntry               // issuance order
+	locs      []recordLoc                // where each entry's latest record is in index.db
+	pos       map[string]int             // serial → position in entries
+	bySubject map[string]map[string]bool // subject → serials
+	bySAN     map[string]map[string]bool // SAN key → serials
+	byStatus  map[string]map[string]bool // status → serials
+	byExpiry  []expiryKey                // ordered by not_after; rebuilt on demand after changes
+}
+
+type expiryKey struct {
+	notAfter time.Time
+	serial   string
+}
+
+func newIndexTable() *indexTable {
+	return &indexTable{
+		pos:       map[string]int{},
+		bySubject: map[string]map[string]bool{},
+		bySAN:     map[string]map[string]bool{},
+		byStatus:  map[string]map[string]bool{},
+	}
+}
+
+// put adds e, replacing the entry with the same serial.
+func (t *indexTable) put(e IndexEntry) {
+	t.putAt(e, recordLoc{})
+}
+
+// putAt adds e, read from loc in index.db, replacing the entry with the same serial.
+func (t *indexTable) putAt(e IndexEntry, loc recordLoc) {
+	if i, ok := t.pos[e.Serial]; ok {
+		t.link(t.entries[i], false)
+		t.entries[i] = e
+		t.locs[i] = loc
+	} else {
+		t.pos[e.Serial] = len(t.entries)
+		t.entries = append(t.entries, e)
+		t.locs = append(t.locs, loc)
+	}
+	t.link(e, true)
+	t.byExpiry = nil
+}
+
+// link adds e to (or removes it from) the secondary indexes.
+func (t *indexTable) link(e IndexEntry, add bool) {
+	update := func(m map[string]map[string]bool, key string) {
+		if add {
+			if m[key] == nil {
+				m[key] = map[string]bool{}
+			}
+			m[key][e.Serial] = true
+			return
+		}
+		delete(m[key], e.Serial)
+		if len(m[key]) == 0 {
+			delete(m, key)
+		}
+	}
+	update(t.bySubject, e.Subject)
+	update(t.byStatus, e.Status)
+	for _, san := range e.SANs {
+		update(t.bySAN, san)
+	}
+}
+
+func (t *indexTable) get(serial string) *IndexEntry {
+	i, ok := t.pos[serial]
+	if !ok {
+		return nil
+	}
+	e := t.entries[i]
+	return &e
+}
+
+func (t *indexTable) all() []IndexEntry {
+	return append([]IndexEntry(nil), t.entries...)
+}
+
+// find answers q from the smallest candidate set the indexes offer, then checks
+// every field of q on each candidate.
+func (t *indexTable) find(q IndexQuery) []IndexEntry {
+	var candidates []string
+	narrowed := false
+	narrow := func(serials []string) {
+		if !narrowed || len(serials) < len(candidates) {
+			candidates, narrowed = serials, true
+		}
+	}
+	keys := func(set map[string]bool) []string {
+		serials := make([]string, 0, len(set))
+		for serial := range set {
+			serials = append(serials, serial)
+		}
+		return serials
+	}
+
+	if q.Subject != "" {
+		narrow(keys(t.bySubject[q.Subject]))
+	}
+	if q.Status != "" {
+		narrow(keys(t.byStatus[q.Status]))
+	}
+	if q.SAN != "" {
+		union := map[string]bool{}
+		for _, key := range q.sanKeys() {
+			for serial := range t.bySAN[key] {
+				union[serial] = true
+			}
+		}
+		narrow(keys(union))
+	}
+	if !q.ExpiresBefore.IsZero() || !q.ExpiresAfter.IsZero() {
+		expiry := t.expiryOrder()
+		from := sort.Search(len(expiry), func(i int) bool { return !expiry[i].notAfter.Before(q.ExpiresAfter) })
+		to := len(expiry)
+		if !q.ExpiresBefore.IsZero() {
+			to = sort.Search(len(expiry), func(i int) bool { return !expiry[i].notAfter.Before(q.ExpiresBefore) })
+		}
+		var serials []string
+		for i := from; i < to; i++ {
+			serials = append(serials, expiry[i].serial)
+		}
+		narrow(serials)
+	}
+	if !narrowed {
+		return t.all()
+	}
+
+	positions := make([]int, 0, len(candidates))
+	for _, serial := range candidates {
+		if i, ok := t.pos[serial]; ok && q.matches(&t.entries[i]) {
+			positions = append(positions, i)
+		}
+	}
+	sort.Ints(positions)
+	found := make([]IndexEntry, len(positions))
+	for i, p := range positions {
+		found[i] = t.entries[p]
+	}
+	return found
+}
+
+// expiryOrder returns the entries' serials ordered by not_after, skipping entries
+// whose not_after does not parse.
+func (t *indexTable) expiryOrder() []expiryKey {
+	if t.byExpiry == nil {
+		t.byExpiry = make([]expiryKey, 0, len(t.entries))
+		for _, e := range t.entries {
+			if notAfter, err := time.Parse(time.RFC3339, e.NotAfter); err == nil {
+				t.byExpiry = append(t.byExpiry, expiryKey{notAfter, e.Serial})
+			}
+		}
+		sort.SliceStable(t.byExpiry, func(i, j int) bool { return t.byExpiry[i].notAfter.Before(t.byExpiry[j].notAfter) })
+	}
+	return t.byExpiry
+}
+
+// logStores caches the open index.db of each data directory, so that long-running
+// servers only read the frames committed since their last request.
+var logStores = struct {
+	sync.Mutex
+	m map[string]*logStore
+}{m: map[string]*logStore{}}
+
+// OpenIndex returns the certificate index of dataDir: index.db, or index.json in a
+// data directory whose index has not been migrated.
+func OpenIndex(dataDir string) (IndexStore, error) {
+	dbPath := filepath.Join(dataDir, indexDBFile)
+	if _, err := os.Stat(dbPath); err == nil {
+		key := dbPath
+		if abs, err := filepath.Abs(dbPath); err == nil {
+			key = abs
+		}
+		logStores.Lock()
+		defer logStores.Unlock()
+		s := logStores.m[key]
+		if s == nil {
+			s = &logStore{path: dbPath}
+			logStores.m[key] = s
+		}
+		return s, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	jsonPath := filepath.Join(dataDir, indexJSONFile)
+	if _, err := os.Stat(jsonPath); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	return jsonStore{path: jsonPath}, nil
+}
+
+// logStore is the index.db backend. Lookups go through index.keys for the frames it
+// covers and through an in-memory table of the frames committed after them.
+type logStore struct {
+	mu       sync.Mutex
+	path     string
+	log      *os.File    // index.db as last opened
+	info     os.FileInfo // its identity and length when last read
+	keys     *indexKeys  // index.keys, when it matches the log
+	keysSeen os.FileInfo // the index.keys last considered, used or not
+	tail     *indexTable // entries of the committed frames after keys (every frame without keys)
+	size     int64       // length of the committed frames
+	records  int         // entry records in the committed frames, live and superseded
+}
+
+func (s *logStore) keysPath() string {
+	return filepath.Join(filepath.Dir(s.path), indexKeysFile)
+}
+
+func (s *logStore) Get(serial string) (*IndexEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	if e := s.tail.get(serial); e != nil || s.keys == nil {
+		return e, nil
+	}
+	from, to, err := s.keys.exact(keySerial, hashKey(serial))
+	if err != nil {
+		return nil, err
+	}
+	locs, err := s.keys.locs(keySerial, from, to)
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.readLocs(locs, IndexQuery{})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range found {
+		if f.entry.Serial == serial {
+			e := f.entry
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+// Entries replays the whole log.
+func (s *logStore) Entries() ([]IndexEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	if s.keys == nil {
+		return s.tail.all(), nil
+	}
+	t, _, err := replayIndexLog(s.log, s.size)
+	if err != nil {
+		return nil, err
+	}
+	return t.entries, nil
+}
+
+// keySpan is a run of index.keys records.
+type keySpan struct {
+	section  int
+	from, to int64
+}
+
+func (s *logStore) Find(q IndexQuery) ([]IndexEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	if s.keys == nil {
+		return s.tail.find(q), nil
+	}
+
+	// Read the smallest candidate set the indexes offer
+	var best []keySpan
+	bestCount := int64(-1)
+	consider := func(spans ...keySpan) {
+		var n int64
+		for _, sp := range spans {
+			n += sp.to - sp.from
+		}
+		if bestCount < 0 || n < bestCount {
+			best, bestCount = spans, n
+		}
+	}
+	exact := func(section int, value string) (keySpan, error) {
+		from, to, err := s.keys.exact(section, hashKey(value))
+		return keySpan{section, from, to}, err
+	}
+	if q.Subject != "" {
+		sp, err := exact(keySubject, q.Subject)
+		if err != nil {
+			return nil, err
+		}
+		consider(sp)
+	}
+	if q.Status != "" {
+		sp, err := exact(keyStatus, q.Status)
+		if err != nil {
+			return nil, err
+		}
+		consider(sp)
+	}
+	if q.SAN != "" {
+		var spans []keySpan
+		for _, key := range q.sanKeys() {
+			sp, err := exact(keySAN, key)
+			if err != nil {
+				return nil, err
+			}
+			spans = append(spans, sp)
+		}
+		consider(spans...)
+	}
+	if !q.ExpiresBefore.IsZero() || !q.ExpiresAfter.IsZero() {
+		// Keys have one-second resolution; matches() applies the exact bounds
+		sp := keySpan{section: keyExpiry, to: s.keys.sections[keyExpiry].count}
+		var err error
+		if !q.ExpiresAfter.IsZero() {
+			if sp.from, err = s.keys.search(keyExpiry, expiryIndexKey(q.ExpiresAfter.Add(-time.Second))); err != nil {
+				return nil, err
+			}
+		}
+		if !q.ExpiresBefore.IsZero() {
+			if sp.to, err = s.keys.search(keyExpiry, expiryIndexKey(q.ExpiresBefore.Add(time.Second))); err != nil {
+				return nil, err
+			}
+		}
+		consider(sp)
+	}
+	if best == nil {
+		t, _, err := replayIndexLog(s.log, s.size)
+		if err != nil {
+			return nil, err
+		}
+		return t.entries, nil
+	}
+
+	var locs []recordLoc
+	for _, sp := range best {
+		l, err := s.keys.locs(sp.section, sp.from, sp.to)
+		if err != nil {
+			return nil, err
+		}
+		locs = append(locs, l...)
+	}
+	found, err := s.readLocs(locs, q)
+	if err != nil {
+		return nil, err
+	}
+	// Entries committed since index.keys was written replace what it points at
+	for _, e := range s.tail.find(q) {
+		ord, ok, err := s.keys.ordOf(e.Serial)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			ord = s.keys.live + s.tail.pos[e.Serial]
+		}
+		found = append(found, foundEntry{ord, e})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ord < found[j].ord })
+	entries := make([]IndexEntry, len(found))
+	for i, f := range found {
+		entries[i] = f.entry
+	}
+	return entries, nil
+}
+
+// foundEntry is an entry read through index.keys, with its issuance ordinal.
+type foundEntry struct {
+	ord   int
+	entry IndexEntry
+}
+
+// readLocs reads the entries at locs that match q, reading each frame once. Entries
+// the tail has replaced, and repeated locations, are skipped.
+func (s *logStore) readLocs(locs []recordLoc, q IndexQuery) ([]foundEntry, error) {
+	sort.Slice(locs, func(i, j int) bool {
+		if locs[i].frame != locs[j].frame {
+			return locs[i].frame < locs[j].frame
+		}
+		return locs[i].pos < locs[j].pos
+	})
+	var found []foundEntry
+	var batch []IndexEntry
+	frame := int64(-1)
+	seen := map[string]bool{}
+	for _, loc := range locs {
+		if loc.frame != frame {
+			var err error
+			if batch, err = readFrameEntries(s.log, loc.frame); err != nil {
+				return nil, err
+			}
+			frame = loc.frame
+		}
+		if loc.pos >= len(batch) {
+			return nil, fmt.Errorf("failed to read index: %s points past the frame at offset %d", indexKeysFile, loc.frame)
+		}
+		e := batch[loc.pos]
+		if seen[e.Serial] || s.tail.get(e.Serial) != nil || !q.matches(&e) {
+			continue
+		}
+		seen[e.Serial] = true
+		found = append(found, foundEntry{loc.ord, e})
+	}
+	return found, nil
+}
+
+// Commit appends entries as one frame and fsyncs it. The caller holds the data
+// directory lock, so no other process appends meanwhile. Every indexKeysEvery
+// records index.keys is rewritten, and the log compacted when superseded records
+// outnumber live ones; both happen after the commit point and only warn on failure.
+// Enforces CON-DI-004: atomicity of index mutations (ADR-006)
+func (s *logStore) Commit(entries ...IndexEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	frame, err := indexFrame(entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open index: %w", err)
+	}
+	defer f.Close()
+	// A frame torn by an earlier crash is cut off so that this one follows the last commit.
+	if err := f.Truncate(s.size); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if _, err := f.WriteAt(frame, s.size); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(s.size) // best effort: the frame must not surface as committed
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if s.info, err = f.Stat(); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	off := s.size
+	s.size += int64(len(frame))
+	s.records += len(entries)
+	for i, e := range entries {
+		s.tail.putAt(e, recordLoc{frame: off, pos: i})
+	}
+
+	covered := 0
+	if s.keys != nil {
+		covered = s.keys.records
+	}
+	if s.records-covered >= indexKeysEvery {
+		if err := s.checkpoint(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// checkpoint rewrites index.keys for the whole log, compacting the log first when
+// superseded records outnumber live ones.
+func (s *logStore) checkpoint() error {
+	defer s.reset() // re-read on next use
+	t, _, err := replayIndexLog(s.log, s.size)
+	if err != nil {
+		return err
+	}
+	if s.records >= indexCompactMin && s.records > 2*len(t.entries) {
+		data, err := marshalIndexDB(t.entries)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.path, data, 0644); err != nil {
+			return fmt.Errorf("failed to compact index: %w", err)
+		}
+	}
+	return writeIndexKeys(s.path)
+}
+
+// reset drops what was read, so the next refresh starts over.
+func (s *logStore) reset() {
+	if s.log != nil {
+		s.log.Close()
+	}
+	if s.keys != nil {
+		s.keys.close()
+	}
+	s.log, s.info, s.keys, s.keysSeen = nil, nil, nil, nil
+}
+
+// refresh brings the state up to date with the files: the frames appended since the
+// last read, or everything after index.keys when the log or index.keys was replaced.
+func (s *logStore) refresh() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	keysInfo, err := os.Stat(s.keysPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", indexKeysFile, err)
+	}
+	keysChanged := (keysInfo == nil) != (s.keysSeen == nil) || keysInfo != nil && !os.SameFile(keysInfo, s.keysSeen)
+	if s.info == nil || !os.SameFile(s.info, info) || info.Size() < s.size || keysChanged {
+		s.reset()
+		if s.log, err = os.Open(s.path); err != nil {
+			return fmt.Errorf("failed to read index: %w", err)
+		}
+		if info, err = s.log.Stat(); err != nil {
+			return fmt.Errorf("failed to read index: %w", err)
+		}
+		magic := make([]byte, len(indexDBMagic))
+		if _, err := s.log.ReadAt(magic, 0); err != nil || string(magic) != indexDBMagic {
+			return fmt.Errorf("failed to read index: %s is not an index database", s.path)
+		}
+		if s.keys, err = openIndexKeys(s.keysPath(), s.log, info.Size()); err != nil {
+			return err
+		}
+		s.keysSeen = keysInfo
+		s.tail = newIndexTable()
+		s.size, s.records = int64(len(indexDBMagic)), 0
+		if s.keys != nil {
+			s.size, s.records = s.keys.logSize, s.keys.records
+		}
+	} else if info.Size() == s.size {
+		return nil
+	}
+
+	end, err := scanIndexLog(s.log, s.size, info.Size(), func(off int64, _ uint32, batch []IndexEntry) {
+		for i, e := range batch {
+			s.tail.putAt(e, recordLoc{frame: off, pos: i})
+		}
+		s.records += len(batch)
+	})
+	if err != nil {
+		return err
+	}
+	s.size = end
+	s.info = info
+	return nil
+}
+
+// scanIndexLog calls fn for each committed frame of the log between from and to, both
+// frame boundaries, and returns where the committed frames end: to, or the start of a
+// frame torn by a crash.
+func scanIndexLog(f *os.File, from, to int64, fn func(off int64, crc uint32, batch []IndexEntry)) (int64, error) {
+	data, err := io.ReadAll(io.NewSectionReader(f, from, to-from))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read index: %w", err)
+	}
+	off := from
+	for len(data) >= indexFrameHeader {
+		n := int64(binary.BigEndian.Uint32(data[0:4]))
+		if indexFrameHeader+n > int64(len(data)) {
+			break // torn: runs past the end of the file
+		}
+		payload := data[indexFrameHeader : indexFrameHeader+n]
+		crc := binary.BigEndian.Uint32(data[4:8])
+		if crc32.Checksum(payload, indexCRC) != crc {
+			if indexFrameHeader+n == int64(len(data)) {
+				break // torn: the last frame was not completely written
+			}
+			return 0, fmt.Errorf("failed to read index: %s is corrupt at offset %d (checksum mismatch)", f.Name(), off)
+		}
+		var batch []IndexEntry
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return 0, fmt.Errorf("failed to read index: %s is corrupt at offset %d: %v", f.Name(), off, err)
+		}
+		fn(off, crc, batch)
+		off += indexFrameHeader + n
+		data = data[indexFrameHeader+n:]
+	}
+	return off, nil
+}
+
+// indexLogState describes the committed frames of a log as index.keys records them.
+type indexLogState struct {
+	size    int64
+	lastOff int64
+	lastCRC uint32
+	records int
+}
+
+// replayIndexLog reads every committed frame of the log up to limit into a table.
+func replayIndexLog(f *os.File, limit int64) (*indexTable, indexLogState, error) {
+	t := newIndexTable()
+	st := indexLogState{}
+	end, err := scanIndexLog(f, int64(len(indexDBMagic)), limit, func(off int64, crc uint32, batch []IndexEntry) {
+		for i, e := range batch {
+			t.putAt(e, recordLoc{frame: off, pos: i})
+		}
+		st.lastOff, st.lastCRC = off, crc
+		st.records += len(batch)
+	})
+	st.size = end
+	return t, st, err
+}
+
+// writeIndexKeys replays the index.db at path and writes its index.keys. The caller
+// holds the data directory lock.
+func writeIndexKeys(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	t, st, err := replayIndexLog(f, info.Size())
+	if err != nil {
+		return err
+	}
+	data := marshalIndexKeys(t, st.size, st.lastOff, st.lastCRC, st.records)
+	if err := writeFileAtomic(filepath.Join(filepath.Dir(path), indexKeysFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", indexKeysFile, err)
+	}
+	return nil
+}
+
+// checkKeys compares index.keys, if there is one, with what the log it covers gives.
+func (s *logStore) checkKeys() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.keysPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.keys == nil {
+		return errors.New("does not match index.db")
+	}
+	t, st, err := replayIndexLog(s.log, s.keys.logSize)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, marshalIndexKeys(t, st.size, st.lastOff, st.lastCRC, st.records)) {
+		return errors.New("does not match the index.db records it covers")
+	}
+	return nil
+}
+
+// rebuildKeys rewrites index.keys from the log. The caller holds the data directory lock.
+func (s *logStore) rebuildKeys() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.reset()
+	return writeIndexKeys(s.path)
+}
+
+// tornTail returns the length of the incomplete frame at the end of the log, if any.
+// Readers ignore it and the next commit cuts it off.
+func (s *logStore) tornTail() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return 0, err
+	}
+	return s.info.Size() - s.size, nil
+}
+
+// cutTornTail truncates the log to its committed frames. The caller holds the data
+// directory lock.
+func (s *logStore) cutTornTail() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return err
+	}
+	if err := os.Truncate(s.path, s.size); err != nil {
+		return fmt.Errorf("failed to truncate index: %w", err)
+	}
+	s.reset() // re-read on next use
+	return nil
+}
+
+// indexFrame encodes entries as one log frame.
+func indexFrame(entries []IndexEntry) ([]byte, error) {
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal index: %w", err)
+	}
+	frame := make([]byte, indexFrameHeader, indexFrameHeader+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, indexCRC))
+	return append(frame, payload...), nil
+}
+
+// marshalIndexDB returns the content of an index.db holding entries.
+func marshalIndexDB(entries []IndexEntry) ([]byte, error) {
+	data := []byte(indexDBMagic)
+	for len(entries) > 0 {
+		n := len(entries)
+		if n > indexBatchSize {
+			n = indexBatchSize
+		}
+		frame, err := indexFrame(entries[:n])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, frame...)
+		entries = entries[n:]
+	}
+	return data, nil
+}
+
+// jsonStore is the legacy index.json backend. Every read parses the file and every
+// commit rewrites it whole.
+type jsonStore struct {
+	path string
+}
+
+func (s jsonStore) load() (*indexTable, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	var entries []IndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	t := newIndexTable()
+	for _, e := range entries {
+		t.put(e)
+	}
+	return t, nil
+}
+
+func (s jsonStore) Get(serial string) (*IndexEntry, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return t.get(serial), nil
+}
+
+func (s jsonStore) Entries() ([]IndexEntry, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return t.entries, nil
+}
+
+func (s jsonStore) Find(q IndexQuery) ([]IndexEntry, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return t.find(q), nil
+}
+
+// Commit rewrites index.json with entries applied.
+// Enforces CON-DI-004: atomicity via atomic file replacement (ADR-006)
+func (s jsonStore) Commit(entries ...IndexEntry) error {
+	t, err := s.load()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		t.put(e)
+	}
+	data, err := marshalIndex(t.entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0644)
+}
+
+// MigrateResult describes the import of index.json into index.db.
+type MigrateResult struct {
+	Entries      int    `json:"entries"`
+	SANsRecorded int    `json:"sans_recorded"` // entries whose SANs were read from certs/
+	StorePath    string `json:"store_path"`
+	BackupPath   string `json:"backup_path"` // the imported index.json, renamed
+}
+
+// MigrateStore imports a data directory's index.json into a new index.db. SANs,
+// which index.json entries predating them lack, are read from the issued
+// certificates. index.json is kept as index.json.migrated.
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-DI-004: validate-before-mutate (ADR-003)
+func MigrateStore(dataDir string) (result *MigrateResult, err error) {
+	audit := startAudit(dataDir, "migrate-store")
+	defer func() { audit.finish(err) }()
+
+	// VALIDATE PHASE (ADR-003)
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dbPath := filepath.Join(dataDir, indexDBFile)
+	jsonPath := filepath.Join(dataDir, indexJSONFile)
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil, newCAError(KindConflict, "Error: the index is already stored in %s", dbPath)
+	}
+	entries, err := jsonStore{path: jsonPath}.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	result = &MigrateResult{Entries: len(entries), StorePath: dbPath, BackupPath: jsonPath + ".migrated"}
+	for i, e := range entries {
+		if len(e.SANs) > 0 {
+			continue
+		}
+		cert, err := LoadCertificate(filepath.Join(dataDir, "certs", e.Serial+".pem"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to load certificate %s: %w", e.Serial, err)
+		}
+		if entries[i].SANs = indexSANs(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs); entries[i].SANs != nil {
+			result.SANsRecorded++
+		}
+	}
+	data, err := marshalIndexDB(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	// MUTATE PHASE: index.db takes over as soon as it exists; index.json is set aside after
+	if err := writeFileAtomic(dbPath, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := writeIndexKeys(dbPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(jsonPath, result.BackupPath); err != nil {
+		return nil, fmt.Errorf("failed to set aside %s: %w", jsonPath, err)
+	}
+	audit.rec.Detail = fmt.Sprintf("%d entries", result.Entries)
+	return result, nil
+}
+
+// ExportIndex returns the index of dataDir in the index.json format.
+// Enforces CON-INV-004: CA initialization prerequisite
+func ExportIndex(dataDir string) ([]byte, int, error) {
+	if !IsInitialized(dataDir) {
+		return nil, 0, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	entries, err := LoadIndex(dataDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	if entries == nil {
+		entries = []IndexEntry{}
+	}
+	data, err := marshalIndex(entries)
+	return data, len(entries), err
+}
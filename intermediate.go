@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InitIntermediateCA initializes a subordinate CA whose certificate is issued by the
+// CA in parentDir. The parent consumes one serial number and records the
+// intermediate in its own index so it can later be revoked and listed on its CRL.
+// The new data directory receives chain.pem holding the issuer chain up to the root.
+// parentPassphrase unlocks the parent's key, through the parent's signer provider; a
+// non-empty passphrase encrypts the new one.
+// policy, nameConstraints and config behave as for InitCA. The intermediate certificate
+// carries the parent's publication URLs, like any certificate the parent issues.
+// Enforces CON-INV-001: intermediate serial drawn by the parent (counter or random)
+// Enforces CON-INV-005: chain of trust integrity (signed by parent CA key)
+// Enforces CON-INV-008: SHA-256 signature algorithm (explicit)
+// Enforces CON-INV-010: supported key algorithms only
+// Enforces CON-DI-004: validate-before-mutate + atomic writes (ADR-003, ADR-006)
+// Enforces CON-DI-010: X.509 version 3
+func InitIntermediateCA(dataDir string, parentDir string, subject pkix.Name, keyAlgo string, validityDays int, pathLen int, policy *Policy, nameConstraints bool, config *CAConfig, parentPassphrase PassphraseFunc, passphrase []byte) (result *InitResult, err error) {
+	// Both CAs log the operation: the parent as the issuance of a CA certificate, the
+	// child as its init
+	parentAudit := startAudit(parentDir, "intermediate")
+	parentAudit.rec.Subject = FormatDN(subject)
+	defer func() { parentAudit.finish(err) }()
+	audit := startAudit(dataDir, "init")
+	audit.rec.Subject = FormatDN(subject)
+	defer func() { audit.finish(err) }()
+
+	// VALIDATE PHASE (ADR-003): all checks before any state change
+	if IsInitialized(dataDir) {
+		return nil, newCAError(KindAlreadyInitialized, "Error: CA already initialized at %s", dataDir) // REQ-ER-005
+	}
+	if !IsInitialized(parentDir) {
+		return nil, newCAError(KindNotInitialized, "Error: parent CA not initialized at %s", parentDir)
+	}
+	if nameConstraints && policy == nil {
+		return nil, newCAError(KindInvalidInput, "Error: name constraints require a policy")
+	}
+	if config != nil {
+		if err := config.validate(); err != nil {
+			return nil, newCAError(KindInvalidInput, "Error: %v", err)
+		}
+	}
+	parentConfig, err := LoadConfig(parentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parent is locked first: its serial counter and index are read here and
+	// rewritten in the commit below.
+	unlockParent, err := lockDataDir(parentDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockParent()
+
+	parentKey, err := LoadCASigner(parentDir, parentConfig, parentPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parent CA key: %w", err)
+	}
+	parentAudit.signWith(parentKey)
+	parentCert, err := LoadCertificate(filepath.Join(parentDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parent CA certificate: %w", err)
+	}
+
+	// The parent's own pathLenConstraint bounds how deep the hierarchy may grow.
+	if parentCert.MaxPathLen == 0 && parentCert.MaxPathLenZero {
+		return nil, newCAError(KindConflict, "Error: parent CA %s has pathLenConstraint 0 and cannot issue CA certificates", FormatDN(parentCert.Subject))
+	}
+	if parentCert.MaxPathLen > 0 && pathLen >= parentCert.MaxPathLen {
+		return nil, newCAError(KindInvalidInput, "Error: --path-len must be less than the parent's pathLenConstraint (%d)", parentCert.MaxPathLen)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014: system clock; certificates carry whole seconds
+	notAfter := now.Add(time.Duration(validityDays) * 24 * time.Hour)
+	if notAfter.After(parentCert.NotAfter) {
+		return nil, newCAError(KindInvalidInput, "Error: requested validity extends beyond the parent CA's expiry (%s)", parentCert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	parentChain, err := LoadChain(parentDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parent CA chain: %w", err)
+	}
+
+	parentIndex, err := OpenIndex(parentDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parent index: %w", err)
+	}
+	parentGeneration, err := currentGeneration(parentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	parentSerialPath := filepath.Join(parentDir, "serial")
+	serial, parentSerialData, err := nextSerial(parentDir, parentConfig, parentIndex)
+	if err != nil {
+		return nil, err
+	}
+	parentAudit.rec.Serial = FormatSerialBig(serial)
+
+	// MUTATE PHASE
+	// Generate a key pair using CSPRNG (CON-SC-002), or use the signer provider's key
+	privKey, keyPEM, err := newCAKey(dataDir, config, keyAlgo, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := privKey.Public()
+
+	ski, err := computeSKI(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute subject key identifier: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial, // CON-INV-001: drawn by the parent
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+		SubjectKeyId:          ski,
+		AuthorityKeyId:        parentCert.SubjectKeyId,                      // CON-INV-005
+		SignatureAlgorithm:    sigAlgorithm(parentKey, parentConfig.RSAPSS), // CON-INV-008: hash matched to the key
+	}
+	if nameConstraints {
+		if err := policy.applyNameConstraints(template); err != nil {
+			return nil, err
+		}
+	}
+	if err := parentConfig.apply(template); err != nil {
+		return nil, err
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parentCert, pub, parentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	serialHex := FormatSerialBig(serial)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	parentEntry := IndexEntry{
+		Serial:     serialHex,
+		Subject:    FormatDN(subject),
+		NotBefore:  now.Format(time.RFC3339),      // CON-DI-003
+		NotAfter:   notAfter.Format(time.RFC3339), // CON-DI-003
+		Status:     "active",
+		IsCA:       true,
+		Generation: issuedGeneration(parentGeneration),
+	}
+	indexData, err := marshalIndexDB(nil)
+	if err != nil {
+		return nil, err
+	}
+	profilesData, err := marshalProfiles(builtinProfiles())
+	if err != nil {
+		return nil, err
+	}
+
+	// The child's chain is the parent certificate followed by the parent's own chain.
+	var chainPEM []byte
+	chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: parentCert.Raw})...)
+	for _, c := range parentChain {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+
+	if err := InitDataDir(dataDir); err != nil {
+		return nil, err
+	}
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if IsInitialized(dataDir) {
+		return nil, newCAError(KindAlreadyInitialized, "Error: CA already initialized at %s", dataDir) // REQ-ER-005
+	}
+
+	keyPath := filepath.Join(dataDir, "ca.key")
+	certPath := filepath.Join(dataDir, "ca.crt")
+	chainPath := filepath.Join(dataDir, "chain.pem")
+
+	// STAGE + COMMIT (ADR-006): the parent's records are committed first so that a
+	// crash part-way leaves at worst an issued-but-unused intermediate, never a
+	// child CA whose certificate the parent has no record of.
+	var parentFiles []stagedFile
+	if parentSerialData != nil {
+		parentFiles = append(parentFiles, stagedFile{parentSerialPath, parentSerialData, 0644})
+	}
+	parentFiles = append(parentFiles, stagedFile{filepath.Join(parentDir, "certs", serialHex+".pem"), certPEM, 0644})
+	if err := stageAndCommit(parentFiles); err != nil {
+		return nil, err
+	}
+	if err := parentIndex.Commit(parentEntry); err != nil {
+		return nil, err
+	}
+
+	var files []stagedFile
+	if keyPEM != nil {
+		files = append(files, stagedFile{keyPath, keyPEM, 0600})
+	}
+	files = append(files, []stagedFile{
+		{certPath, certPEM, 0644},
+		{chainPath, chainPEM, 0644},
+		{filepath.Join(dataDir, "serial"), []byte(FormatSerial(2) + "\n"), 0644},
+		{filepath.Join(dataDir, "crlnumber"), []byte(FormatSerial(1) + "\n"), 0644},
+		{filepath.Join(dataDir, indexDBFile), indexData, 0644},
+		{filepath.Join(dataDir, "profiles.json"), profilesData, 0644},
+	}...)
+	if policy != nil {
+		policyData, err := marshalPolicy(policy)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, stagedFile{filepath.Join(dataDir, "policy.json"), policyData, 0644})
+	}
+	if config != nil && !config.empty() {
+		configData, err := marshalConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, stagedFile{filepath.Join(dataDir, "config.json"), configData, 0644})
+	}
+	if err := stageAndCommit(files); err != nil {
+		return nil, err
+	}
+	audit.rec.Serial = serialHex
+	audit.rec.Detail = "issued by " + FormatDN(parentCert.Subject)
+	audit.signWith(privKey)
+	audit.checkpoint = true
+
+	result = &InitResult{
+		Subject:   FormatDN(subject),
+		Issuer:    FormatDN(parentCert.Subject),
+		Algorithm: keyAlgorithmOf(pub).DisplayName,
+		Serial:    serialHex,
+		NotAfter:  notAfter,
+		CertPath:  certPath,
+		ChainPath: chainPath,
+		Encrypted: len(passphrase) > 0,
+	}
+	result.setKey(keyPath, keyPEM != nil, config)
+	if policy != nil {
+		result.PolicyPath = filepath.Join(dataDir, "policy.json")
+		result.NameConstraints = nameConstraints
+	}
+	if config != nil && !config.empty() {
+		result.ConfigPath = filepath.Join(dataDir, "config.json")
+	}
+	return result, nil
+}
+
+// buildChain walks from cert up to a self-signed root through the candidate issuers,
+// checking each signature, every pathLenConstraint and every NameConstraints extension on the way.
+// Returns the chain starting with cert itself and ending with the root.
+func buildChain(cert *x509.Certificate, issuers []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain := []*x509.Certificate{cert}
+	current := cert
+	for len(chain) <= len(issuers)+1 {
+		var issuer *x509.Certificate
+		var lastErr error
+		for _, candidate := range issuers {
+			if !bytes.Equal(candidate.RawSubject, current.RawIssuer) {
+				continue
+			}
+			if err := current.CheckSignatureFrom(candidate); err != nil {
+				lastErr = err
+				continue
+			}
+			issuer = candidate
+			break
+		}
+		if issuer == nil {
+			if lastErr != nil {
+				return chain, lastErr
+			}
+			return chain, fmt.Errorf("x509: certificate signed by unknown authority")
+		}
+
+		// pathLenConstraint counts the CA certificates below the issuer, excluding the leaf.
+		if issuer.MaxPathLen >= 0 && (issuer.MaxPathLen > 0 || issuer.MaxPathLenZero) {
+			if len(chain)-1 > issuer.MaxPathLen {
+				return chain, fmt.Errorf("x509: path length constraint of %s exceeded", FormatDN(issuer.Subject))
+			}
+		}
+
+		// Names in every certificate below the issuer must satisfy its NameConstraints.
+		if err := checkNameConstraints(issuer, chain); err != nil {
+			return chain, err
+		}
+
+		chain = append(chain, issuer)
+		if bytes.Equal(issuer.RawSubject, issuer.RawIssuer) && issuer.CheckSignatureFrom(issuer) == nil {
+			return chain, nil
+		}
+		current = issuer
+	}
+	return chain, fmt.Errorf("x509: certificate chain too long or contains a loop")
+}
+
+// caIssuers returns every certificate this CA can use to build a verification path:
+// its own certificate, its issuer chain, and any intermediates it has issued. The
+// certificates ca rollover records certify the CA's own keys and are left out.
+func caIssuers(dataDir string, caCert *x509.Certificate) ([]*x509.Certificate, error) {
+	issuers := []*x509.Certificate{caCert}
+
+	chain, err := LoadChain(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA chain: %w", err)
+	}
+	issuers = append(issuers, chain...)
+
+	gens, err := LoadGenerations(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	ownKeys := map[string]bool{}
+	for _, g := range gens {
+		if key, err := publicKeyBytes(g.Cert.PublicKey); err == nil {
+			ownKeys[string(key)] = true
+		}
+	}
+
+	index, err := LoadIndex(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	for _, entry := range index {
+		if !entry.IsCA {
+			continue
+		}
+		sub, err := LoadCertificate(filepath.Join(dataDir, "certs", entry.Serial+".pem"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to load intermediate %s: %w", entry.Serial, err)
+		}
+		if key, err := publicKeyBytes(sub.PublicKey); err == nil && ownKeys[string(key)] {
+			continue
+		}
+		issuers = append(issuers, sub)
+	}
+	return issuers, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jwsMessage is the flattened JSON serialization of a JWS (RFC 7515 §7.2.2),
+// the only serialization ACME permits.
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwsHeader is the protected header of an ACME request (RFC 8555 §6.2).
+// Exactly one of JWK and Kid is present.
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+	Kid   string          `json:"kid,omitempty"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+}
+
+// jsonWebKey holds the public members of an EC or RSA JWK (RFC 7517, RFC 7518 §6).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// b64 encodes data as unpadded base64url, the encoding used throughout JOSE.
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// b64Decode decodes unpadded base64url.
+func b64Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// parseJWK returns the public key described by raw together with its RFC 7638
+// thumbprint. Only P-256, P-384 and RSA keys of at least 2048 bits are accepted.
+func parseJWK(raw json.RawMessage) (crypto.PublicKey, string, error) {
+	var k jsonWebKey
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, "", fmt.Errorf("invalid JWK: %w", err)
+	}
+
+	var pub crypto.PublicKey
+	var canonical string // required members in lexicographic order (RFC 7638 §3.2)
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, "", fmt.Errorf("unsupported JWK curve %q", k.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, errX := b64Decode(k.X)
+		y, errY := b64Decode(k.Y)
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, "", errors.New("invalid JWK coordinates")
+		}
+		ecKey := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return nil, "", errors.New("JWK point is not on the curve")
+		}
+		pub = ecKey
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		n, errN := b64Decode(k.N)
+		e, errE := b64Decode(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, "", errors.New("invalid JWK modulus or exponent")
+		}
+		rsaKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if rsaKey.N.BitLen() < 2048 {
+			return nil, "", errors.New("RSA account keys must be at least 2048 bits")
+		}
+		if rsaKey.E < 3 || rsaKey.E%2 == 0 {
+			return nil, "", errors.New("invalid RSA public exponent")
+		}
+		pub = rsaKey
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	default:
+		return nil, "", fmt.Errorf("unsupported JWK key type %q", k.Kty)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return pub, b64(sum[:]), nil
+}
+
+// verifyJWS checks sig over signingInput for the JWS alg, which must match the key type.
+// Supported: ES256 (P-256), ES384 (P-384), RS256 (RSA).
+func verifyJWS(alg string, pub crypto.PublicKey, signingInput []byte, sig []byte) error {
+	switch alg {
+	case "ES256", "ES384":
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the account key type", alg)
+		}
+		var digest []byte
+		if alg == "ES256" {
+			if ecKey.Curve != elliptic.P256() {
+				return errors.New("ES256 requires a P-256 key")
+			}
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		} else {
+			if ecKey.Curve != elliptic.P384() {
+				return errors.New("ES384 requires a P-384 key")
+			}
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		}
+		// JWS ECDSA signatures are R || S, each padded to the coordinate size (RFC 7518 §3.4).
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("malformed ECDSA signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("JWS signature is invalid")
+		}
+		return nil
+	case "RS256":
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the account key type", alg)
+		}
+		sum := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, sum[:], sig); err != nil {
+			return errors.New("JWS signature is invalid")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported JWS algorithm %q", alg)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"path/filepath"
+)
+
+// PassphraseFunc supplies the passphrase protecting the CA private key.
+// It is only called when the key on disk is actually encrypted.
+type PassphraseFunc func() ([]byte, error)
+
+// pbkdf2Iterations is the PBKDF2-HMAC-SHA256 work factor for newly encrypted keys.
+const pbkdf2Iterations = 600000
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo structure (RFC 5958).
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params is the PBES2-params structure (RFC 8018 §A.4).
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params is the PBKDF2-params structure (RFC 8018 §A.2).
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// pbkdf2Key derives a key with PBKDF2 (RFC 8018 §5.2).
+func pbkdf2Key(password, salt []byte, iterations, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		buf[0] = byte(block >> 24)
+		buf[1] = byte(block >> 16)
+		buf[2] = byte(block >> 8)
+		buf[3] = byte(block)
+		prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// EncryptPrivateKeyPEM marshals key as a PKCS#8 "ENCRYPTED PRIVATE KEY" PEM block
+// using PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC, readable by OpenSSL.
+// Enforces CON-DI-001: PEM encoding
+func EncryptPrivateKeyPEM(key crypto.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+
+	derived := pbkdf2Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS#7 padding
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encrypted key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
+
+// decryptPKCS8 decrypts a PBES2 EncryptedPrivateKeyInfo and parses the inner key.
+// Accepts PBKDF2 with HMAC-SHA1 or HMAC-SHA256 and AES-CBC, covering keys written by
+// EncryptPrivateKeyPEM and by "openssl pkcs8 -topk8 -v2 aes-256-cbc".
+func decryptPKCS8(der []byte, passphrase []byte) (crypto.PrivateKey, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption scheme %s", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 parameters: %w", err)
+	}
+	// The count comes from the file; a damaged or crafted one must not hang the command.
+	if kdf.IterationCount <= 0 || kdf.IterationCount > 10*pbkdf2Iterations {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %d (expected 1 to %d)", kdf.IterationCount, 10*pbkdf2Iterations)
+	}
+	prf := sha1.New
+	switch {
+	case kdf.PRF.Algorithm == nil, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF %s", kdf.PRF.Algorithm)
+	}
+
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported key cipher %s", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid key cipher IV")
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted key length")
+	}
+
+	derived := pbkdf2Key(passphrase, kdf.Salt, kdf.IterationCount, keyLen, prf)
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+
+	// A wrong passphrase almost always shows up as bad padding or unparsable PKCS#8.
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errIncorrectPassphrase
+	}
+	key, err := x509.ParsePKCS8PrivateKey(plain[:len(plain)-pad])
+	if err != nil {
+		return nil, errIncorrectPassphrase
+	}
+	return key, nil
+}
+
+// errIncorrectPassphrase is returned when an encrypted key cannot be decrypted.
+var errIncorrectPassphrase = fmt.Errorf("incorrect passphrase")
+
+// IsKeyEncrypted reports whether the PEM file at path holds an encrypted private key.
+func IsKeyEncrypted(path string) (bool, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return false, err
+	}
+	return block.Type == "ENCRYPTED PRIVATE KEY", nil
+}
+
+// CheckKeyState checks that dataDir is initialized with a ca.key file that is
+// encrypted, or plaintext, as the key command about to run expects. It returns the
+// key's path. "ca key" calls it before prompting for a new passphrase (ADR-003).
+func CheckKeyState(dataDir string, encrypted bool) (keyPath string, err error) {
+	if !IsInitialized(dataDir) {
+		return "", newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	if err := requireKeyFile(dataDir); err != nil {
+		return "", err
+	}
+	keyPath = filepath.Join(dataDir, "ca.key")
+	is, err := IsKeyEncrypted(keyPath)
+	if err != nil {
+		return "", err
+	}
+	if is && !encrypted {
+		return "", newCAError(KindConflict, "Error: CA key at %s is already encrypted. Use 'ca key change-passphrase'.", keyPath)
+	}
+	if !is && encrypted {
+		return "", newCAError(KindConflict, "Error: CA key at %s is not encrypted. Use 'ca key encrypt'.", keyPath)
+	}
+	return keyPath, nil
+}
+
+// KeyResult describes the CA key after "ca key encrypt" or "ca key passwd".
+type KeyResult struct {
+	KeyPath   string `json:"key_path"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// EncryptCAKey encrypts an existing plaintext ca.key in place. This is the
+// migration path for CAs initialized before key encryption was available.
+// Enforces CON-SC-001: key material only written to file, never to output
+// Enforces CON-DI-004: atomic file replacement (ADR-006)
+func EncryptCAKey(dataDir string, newPassphrase []byte) (keyPath string, err error) {
+	audit := startAudit(dataDir, "key encrypt")
+	audit.checkpoint = true
+	defer func() { audit.finish(err) }()
+
+	if !IsInitialized(dataDir) {
+		return "", newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if keyPath, err = CheckKeyState(dataDir, false); err != nil {
+		return "", err
+	}
+
+	key, err := LoadPrivateKey(keyPath, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to load CA key: %w", err)
+	}
+	audit.signWith(key)
+	if err := saveEncryptedCAKeys(dataDir, key, nil, newPassphrase); err != nil {
+		return "", err
+	}
+	return keyPath, nil
+}
+
+// ChangeKeyPassphrase re-encrypts an encrypted ca.key under a new passphrase.
+// Enforces CON-DI-004: atomic file replacement (ADR-006)
+func ChangeKeyPassphrase(dataDir string, current PassphraseFunc, newPassphrase []byte) (keyPath string, err error) {
+	audit := startAudit(dataDir, "key change-passphrase")
+	audit.checkpoint = true
+	defer func() { audit.finish(err) }()
+
+	if !IsInitialized(dataDir) {
+		return "", newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if keyPath, err = CheckKeyState(dataDir, true); err != nil {
+		return "", err
+	}
+
+	key, err := LoadPrivateKey(keyPath, current)
+	if err != nil {
+		return "", fmt.Errorf("Error: failed to load CA key: %w", err)
+	}
+	audit.signWith(key)
+	if err := saveEncryptedCAKeys(dataDir, key, current, newPassphrase); err != nil {
+		return "", err
+	}
+	return keyPath, nil
+}
+
+// saveEncryptedCAKeys writes key to ca.key encrypted under passphrase, together with
+// the keys of the retired generations, which current unlocks (nil when they are
+// plaintext). One passphrase then covers every generation of the CA.
+// Enforces CON-DI-004: atomic file replacement (ADR-006)
+func saveEncryptedCAKeys(dataDir string, key crypto.PrivateKey, current PassphraseFunc, passphrase []byte) error {
+	gens, err := LoadGenerations(dataDir)
+	if err != nil {
+		return err
+	}
+	var files []stagedFile
+	for _, g := range gens[:len(gens)-1] {
+		path := filepath.Join(g.Dir, "ca.key")
+		retired, err := LoadPrivateKey(path, current)
+		if err != nil {
+			return fmt.Errorf("Error: failed to load the generation %d CA key: %w", g.Number, err)
+		}
+		data, err := EncryptPrivateKeyPEM(retired, passphrase)
+		if err != nil {
+			return err
+		}
+		files = append(files, stagedFile{path: path, data: data, perm: 0600})
+	}
+	data, err := EncryptPrivateKeyPEM(key, passphrase)
+	if err != nil {
+		return err
+	}
+	files = append(files, stagedFile{path: filepath.Join(dataDir, "ca.key"), data: data, perm: 0600})
+	return stageAndCommit(files)
+}
+
+// requireKeyFile rejects key file management for a CA whose key a signer provider holds.
+func requireKeyFile(dataDir string) error {
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return err
+	}
+	if p := config.signerProvider(); p != signerFile {
+		return newCAError(KindConflict, "Error: the CA key is held by the %s signer; there is no ca.key to manage", p)
+	}
+	return nil
+}
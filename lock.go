@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultLockTimeout is how long a mutating operation waits for another one to
+// release the data directory. CA_LOCK_TIMEOUT (a duration such as "30s") overrides it.
+const defaultLockTimeout = 10 * time.Second
+
+// errLockHeld is returned by tryLock when another holder has the lock.
+var errLockHeld = errors.New("lock is held")
+
+// lockDataDir takes the exclusive advisory lock on <dataDir>/.lock, retrying until
+// the timeout. Every mutating operation holds it from reading the current state
+// until its commit, so concurrent invocations never issue the same serial or
+// overwrite each other's index updates. The returned function releases the lock.
+// Enforces CON-DI-004: read-modify-write of serial/index is serialized across processes
+func lockDataDir(dataDir string) (func(), error) {
+	return acquireLock(filepath.Join(dataDir, ".lock"), "data directory "+dataDir)
+}
+
+// acquireLock takes the exclusive lock file at path, retrying until CA_LOCK_TIMEOUT
+// (default defaultLockTimeout). what names the locked resource in errors.
+func acquireLock(path string, what string) (func(), error) {
+	timeout := defaultLockTimeout
+	if v := os.Getenv("CA_LOCK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, newCAError(KindInvalidInput, "Error: invalid CA_LOCK_TIMEOUT %q: expected a duration such as 30s", v)
+		}
+		timeout = d
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		unlock, err := tryLock(path)
+		if err == nil {
+			return unlock, nil
+		}
+		if !errors.Is(err, errLockHeld) {
+			return nil, fmt.Errorf("failed to lock %s: %w", what, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, newCAError(KindConflict, "Error: %s is locked by another operation (waited %s)", what, timeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
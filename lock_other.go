@@ -0,0 +1,25 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tryLock falls back to an exclusively created lock file on platforms without flock(2).
+// The file is removed on release; one left behind by a crashed process must be
+// deleted by hand.
+func tryLock(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, errLockHeld
+		}
+		return nil, err
+	}
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	f.Close()
+	return func() { os.Remove(path) }, nil
+}
@@ -1,23 +1,41 @@
 package main
 
 import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"flag"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	args, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(usageError("%v", err))
+	}
+	if len(args) < 1 {
 		printUsage()
 		os.Exit(2) // CON-BD-023: exit code 2 for usage error
 	}
 
-	cmd := os.Args[1]
-	args := os.Args[2:]
+	cmd := args[0]
+	args = args[1:]
+
+	// Commands with subcommands are recorded as e.g. "key encrypt" in structured output
+	output.command = cmd
+	if contains(subcommandGroups, cmd) && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		output.command += " " + args[0]
+	}
 
 	var exitCode int
 	switch cmd {
@@ -25,25 +43,67 @@ func main() {
 		exitCode = runInit(args)
 	case "sign":
 		exitCode = runSign(args)
+	case "sign-batch":
+		exitCode = runSignBatch(args)
+	case "renew":
+		exitCode = runRenew(args, false)
+	case "rekey":
+		exitCode = runRenew(args, true)
 	case "revoke":
 		exitCode = runRevoke(args)
+	case "unhold":
+		exitCode = runUnhold(args)
 	case "crl":
 		exitCode = runCRL(args)
 	case "list":
 		exitCode = runList(args)
+	case "expiring":
+		exitCode = runExpiring(args)
+	case "migrate-store":
+		exitCode = runMigrateStore(args)
+	case "export-index":
+		exitCode = runExportIndex(args)
+	case "fsck":
+		exitCode = runFsck(args)
+	case "backup":
+		exitCode = runBackup(args)
+	case "restore":
+		exitCode = runRestore(args)
+	case "rollover":
+		exitCode = runRollover(args)
 	case "verify":
 		exitCode = runVerify(args)
 	case "request":
 		exitCode = runRequest(args)
+	case "key":
+		exitCode = runKey(args)
+	case "ocsp":
+		exitCode = runOCSP(args)
+	case "serve":
+		exitCode = runServe(args)
+	case "acme":
+		exitCode = runACME(args)
+	case "policy":
+		exitCode = runPolicy(args)
+	case "config":
+		exitCode = runConfig(args)
+	case "signer":
+		exitCode = runSigner(args)
+	case "audit":
+		exitCode = runAudit(args)
 	default:
-		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", cmd) // REQ-CL-009
-		printUsage()
-		exitCode = 2
+		exitCode = usageError("unknown command %q", cmd) // REQ-CL-009
+		if !structured() {
+			printUsage()
+		}
 	}
 
 	os.Exit(exitCode)
 }
 
+// subcommandGroups are the commands whose first argument names a subcommand.
+var subcommandGroups = []string{"key", "ocsp", "acme", "policy", "config", "signer", "audit"}
+
 // resolveDataDir implements CON-BD-022: --data-dir flag > CA_DATA_DIR env > "./ca-data"
 func resolveDataDir(flagValue string) string {
 	if flagValue != "" {
@@ -55,6 +115,36 @@ func resolveDataDir(flagValue string) string {
 	return "./ca-data"
 }
 
+// flagWasSet reports whether the named flag was given explicitly on the command line.
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// urlListFlag collects a comma-separated list of URLs into a config field.
+type urlListFlag struct{ urls *[]string }
+
+func (f urlListFlag) String() string {
+	if f.urls == nil {
+		return ""
+	}
+	return strings.Join(*f.urls, ",")
+}
+
+func (f urlListFlag) Set(v string) error {
+	for _, u := range strings.Split(v, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			*f.urls = append(*f.urls, u)
+		}
+	}
+	return nil
+}
+
 // runInit handles the "ca init" command.
 // Enforces CON-BD-001: precondition validation (subject required, algo valid, validity positive)
 // Enforces CON-BD-023: exit codes (0 success, 1 operational, 2 usage)
@@ -62,58 +152,154 @@ func resolveDataDir(flagValue string) string {
 func runInit(args []string) int {
 	fs := flag.NewFlagSet("init", flag.ContinueOnError)
 	fs.SetOutput(io.Discard) // Suppress default flag error messages
+	addOutputFlag(fs)
 
 	subject := fs.String("subject", "", "Distinguished Name for the root CA")
-	keyAlgo := fs.String("key-algorithm", "ecdsa-p256", "Key algorithm: ecdsa-p256 or rsa-2048")
-	validity := fs.Int("validity", 3650, "Validity period in days")
+	keyAlgo := fs.String("key-algorithm", "ecdsa-p256", "Key algorithm: "+KeyAlgorithmNames())
+	validity := fs.Int("validity", 3650, "Validity period in days (1825 for an intermediate)")
 	dataDir := fs.String("data-dir", "", "CA data directory path")
+	parent := fs.String("parent", "", "Data directory of the issuing CA (creates an intermediate CA)")
+	pathLen := fs.Int("path-len", 0, "pathLenConstraint for an intermediate CA")
+	encryptKey := fs.Bool("encrypt-key", false, "Encrypt the CA private key with a passphrase")
+	policyFile := fs.String("policy", "", "Issuance policy file to install as policy.json")
+	nameConstraints := fs.Bool("name-constraints", false, "Embed the policy's name rules as X.509 NameConstraints")
+	var config CAConfig
+	for _, key := range ConfigKeys() {
+		fs.Var(urlListFlag{configKeys[key](&config)}, key, "Comma-separated URLs for config key "+key+" (see 'ca config')")
+	}
+	fs.BoolVar(&config.RSAPSS, configKeyRSAPSS, false, "Sign with RSASSA-PSS (RSA keys only)")
+	fs.StringVar(&config.Signer, configKeySigner, "", "Signer provider holding the CA key: "+strings.Join(SignerProviders(), " or ")+" (default file)")
+	fs.StringVar(&config.SignerSocket, configKeySignerSocket, "", "Unix socket of the remote signer")
+	fs.StringVar(&config.SerialMode, configKeySerialMode, "", fmt.Sprintf("Serial numbers: %s (default) or %s (%d random bits)", serialSequential, serialRandom, randomSerialBits))
+	pass := addPassphraseFlags(fs, "", "new CA key")
+	parentPass := addPassphraseFlags(fs, "parent-", "parent CA key")
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 2
+		return usageError("%v", err)
 	}
 
 	// Validate required flags (CON-BD-001)
 	if *subject == "" {
-		fmt.Fprintln(os.Stderr, "Error: --subject is required")
-		return 2
+		return usageError("--subject is required")
+	}
+
+	if _, ok := lookupKeyAlgorithm(*keyAlgo); !ok {
+		return usageError("invalid key algorithm %q. Must be one of: %s", *keyAlgo, KeyAlgorithmNames())
+	}
+
+	// A provider key already exists: its algorithm is whatever the provider holds
+	external := config.Signer != "" && config.Signer != signerFile
+	if external && (flagWasSet(fs, "key-algorithm") || *encryptKey) {
+		return usageError("--key-algorithm and --encrypt-key do not apply when the %s signer holds the key", config.Signer)
 	}
 
-	if *keyAlgo != "ecdsa-p256" && *keyAlgo != "rsa-2048" {
-		fmt.Fprintf(os.Stderr, "Error: invalid key algorithm %q. Must be ecdsa-p256 or rsa-2048\n", *keyAlgo)
-		return 2
+	if config.RSAPSS && !external && !strings.HasPrefix(*keyAlgo, "rsa-") {
+		return usageError("--rsa-pss requires an RSA key algorithm")
 	}
 
 	if *validity <= 0 {
-		fmt.Fprintln(os.Stderr, "Error: --validity must be a positive integer")
-		return 2
+		return usageError("--validity must be a positive integer")
+	}
+
+	if *pathLen < 0 {
+		return usageError("--path-len must be zero or a positive integer")
+	}
+
+	if *nameConstraints && *policyFile == "" {
+		return usageError("--name-constraints requires --policy")
+	}
+
+	if err := config.validate(); err != nil {
+		return usageError("--%v", err)
 	}
 
 	dir := resolveDataDir(*dataDir)
 
 	parsedSubject, err := ParseDN(*subject)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: invalid subject: %v\n", err)
-		return 2
+		return usageError("invalid subject: %v", err)
+	}
+
+	// Intermediates default to half the root's default lifetime, capped so they
+	// always expire before their parent does.
+	if *parent != "" && !flagWasSet(fs, "validity") {
+		*validity = 1825
+		if parentCert, err := LoadCertificate(filepath.Join(*parent, "ca.crt")); err == nil {
+			remaining := int(time.Until(parentCert.NotAfter).Hours()/24) - 1
+			if remaining < 1 {
+				return reportError(newCAError(KindInvalidInput, "Error: parent CA certificate expires %s, too soon to issue an intermediate; renew or roll over the parent first", parentCert.NotAfter.UTC().Format(time.RFC3339)))
+			}
+			if remaining < *validity {
+				*validity = remaining
+			}
+		}
 	}
 
-	result, err := InitCA(dir, parsedSubject, *keyAlgo, *validity)
+	var passphrase []byte
+	if *encryptKey {
+		passphrase, err = pass.newPassphrase("CA_KEY_PASSPHRASE", "New CA key passphrase: ")
+		if err != nil {
+			return usageError("%v", err)
+		}
+	}
+
+	var policy *Policy
+	if *policyFile != "" {
+		if policy, err = LoadPolicyFile(*policyFile); err != nil {
+			return reportError(err)
+		}
+	}
+
+	var result *InitResult
+	if *parent != "" {
+		result, err = InitIntermediateCA(dir, *parent, parsedSubject, *keyAlgo, *validity, *pathLen, policy, *nameConstraints, &config,
+			parentPass.source("CA_PARENT_KEY_PASSPHRASE", "Parent CA key passphrase: "), passphrase)
+	} else {
+		result, err = InitCA(dir, parsedSubject, *keyAlgo, *validity, policy, *nameConstraints, &config, passphrase)
+	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("InitResult", result)
+		return 0
 	}
 
 	// Format output per SPEC.md §4.1.1 (REQ-MK-005)
 	// Enforces CON-SC-001: only print file path for key
 	fmt.Println("CA initialized successfully.")
 	fmt.Printf("  Subject:     %s\n", result.Subject)
+	if result.ChainPath != "" {
+		fmt.Printf("  Issuer:      %s\n", result.Issuer)
+	}
 	fmt.Printf("  Algorithm:   %s\n", result.Algorithm)
 	fmt.Printf("  Serial:      %s\n", result.Serial)
 	fmt.Printf("  Not After:   %s\n", result.NotAfter.Format(time.RFC3339))
 	fmt.Printf("  Certificate: %s\n", result.CertPath)
-	fmt.Printf("  Key:         %s\n", result.KeyPath)
+	switch {
+	case result.Signer != "":
+		fmt.Printf("  Key:         held by the %s signer at %s\n", result.Signer, result.SignerSocket)
+	case result.Encrypted:
+		fmt.Printf("  Key:         %s (encrypted)\n", result.KeyPath)
+	default:
+		fmt.Printf("  Key:         %s\n", result.KeyPath)
+	}
+	if result.ChainPath != "" {
+		fmt.Printf("  Chain:       %s\n", result.ChainPath)
+	}
+	if result.NameConstraints {
+		fmt.Printf("  Policy:      %s (embedded as name constraints)\n", result.PolicyPath)
+	} else if result.PolicyPath != "" {
+		fmt.Printf("  Policy:      %s\n", result.PolicyPath)
+	}
+	if result.ConfigPath != "" {
+		fmt.Printf("  Config:      %s\n", result.ConfigPath)
+	}
 	// REQ-MK-002: warning about unencrypted key
-	fmt.Printf("Warning: CA private key is stored unencrypted at %s. Protect this file.\n", result.KeyPath)
+	if result.KeyPath != "" && !result.Encrypted {
+		fmt.Printf("Warning: CA private key is stored unencrypted at %s. Protect this file or run 'ca key encrypt'.\n", result.KeyPath)
+	}
 
 	return 0
 }
@@ -124,46 +310,204 @@ func runInit(args []string) int {
 func runSign(args []string) int {
 	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
 
-	validity := fs.Int("validity", 365, "Validity period in days")
+	validity := fs.Int("validity", 0, "Validity period in days (default 365, or the profile maximum if lower)")
+	profile := fs.String("profile", DefaultProfileName, "Certificate profile from profiles.json")
 	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "CA key")
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 2
+		return usageError("%v", err)
 	}
 
 	// Positional argument: CSR file path
 	remaining := fs.Args()
 	if len(remaining) < 1 {
-		fmt.Fprintln(os.Stderr, "Error: CSR file path is required")
-		return 2
+		return usageError("CSR file path is required")
 	}
 	csrFile := remaining[0]
 
-	if *validity <= 0 {
-		fmt.Fprintln(os.Stderr, "Error: --validity must be a positive integer")
-		return 2
+	if *validity < 0 || (*validity == 0 && flagWasSet(fs, "validity")) {
+		return usageError("--validity must be a positive integer")
 	}
 
 	dir := resolveDataDir(*dataDir)
 
 	csrPEM, err := os.ReadFile(csrFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to read CSR file %s: %v\n", csrFile, err)
-		return 1
+		return reportError(newCAError(KindInvalidInput, "Error: failed to read CSR file %s: %v", csrFile, err))
 	}
 
-	result, err := SignCSR(dir, csrPEM, csrFile, *validity)
+	result, err := SignCSR(dir, csrPEM, csrFile, *profile, *validity, pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("SignResult", result)
+		return 0
 	}
 
 	// Format output per SPEC.md §4.1.2 (REQ-MK-005)
 	fmt.Println("Certificate issued successfully.")
 	fmt.Printf("  Serial:      %s\n", result.Serial)
 	fmt.Printf("  Subject:     %s\n", result.Subject)
+	fmt.Printf("  Profile:     %s\n", result.Profile)
+	fmt.Printf("  Not After:   %s\n", result.NotAfter.Format(time.RFC3339))
+	fmt.Printf("  Certificate: %s\n", result.CertPath)
+
+	return 0
+}
+
+// runSignBatch handles "ca sign-batch": every CSR of a directory or manifest is
+// checked first, then all are issued in one transaction, or none if any is invalid.
+// Exits 1 when nothing was issued because of invalid CSRs (CON-BD-023).
+func runSignBatch(args []string) int {
+	fs := flag.NewFlagSet("sign-batch", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	csrDir := fs.String("dir", "", "Sign every *.csr file in this directory")
+	manifest := fs.String("manifest", "", "Sign the CSRs listed in this file (path [profile [validity]] per line)")
+	validity := fs.Int("validity", 0, "Default validity period in days (default 365, or the profile maximum if lower)")
+	profile := fs.String("profile", DefaultProfileName, "Default certificate profile from profiles.json")
+	report := fs.String("report", "", "Also write the per-CSR results to this file as JSON")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "CA key")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+	if fs.NArg() > 0 || (*csrDir == "") == (*manifest == "") {
+		return usageError("usage: ca sign-batch --dir directory | --manifest file [--profile name] [--validity days] [--report file]")
+	}
+	if *validity < 0 || (*validity == 0 && flagWasSet(fs, "validity")) {
+		return usageError("--validity must be a positive integer")
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	var items []BatchItem
+	var err error
+	if *csrDir != "" {
+		items, err = BatchFromDir(*csrDir, *profile, *validity)
+	} else {
+		items, err = BatchFromManifest(*manifest, *profile, *validity)
+	}
+	if err != nil {
+		return reportError(err)
+	}
+
+	result, err := SignBatch(dir, items, pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "))
+	if err != nil {
+		return reportError(err)
+	}
+	if *report != "" {
+		if err := WriteBatchReport(*report, result); err != nil {
+			return reportError(err)
+		}
+	}
+
+	exitCode := 0
+	if result.Invalid > 0 {
+		exitCode = 1
+	}
+	if structured() {
+		printResult("BatchResult", result)
+		return exitCode
+	}
+
+	for _, item := range result.Items {
+		name := item.CSR
+		if item.Line > 0 {
+			name = fmt.Sprintf("%s (line %d)", item.CSR, item.Line)
+		}
+		switch item.Status {
+		case "issued":
+			fmt.Printf("  %s  %s  %s  %s\n", item.Serial, item.Subject, item.Profile, item.CertPath)
+		case "invalid":
+			fmt.Printf("  INVALID  %s: %s\n", name, item.Error)
+		}
+	}
+	if exitCode != 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d CSRs are invalid; nothing was issued\n", result.Invalid, len(result.Items))
+		return exitCode
+	}
+	fmt.Printf("Issued %d certificates.\n", result.Issued)
+	return 0
+}
+
+// runRenew handles "ca renew <serial>" and, with rekey set, "ca rekey <serial> <csr-file>".
+// Enforces CON-BD-023: exit codes
+func runRenew(args []string, rekey bool) int {
+	name := "renew"
+	if rekey {
+		name = "rekey"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	validity := fs.Int("validity", 0, "Validity period in days (default 365, or the profile maximum if lower)")
+	revokeOld := fs.Bool("revoke-old", false, "Revoke the replaced certificate with reason superseded")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "CA key")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+
+	remaining := fs.Args()
+	if len(remaining) < 1 {
+		return usageError("serial number is required")
+	}
+	if rekey && len(remaining) < 2 {
+		return usageError("CSR file path is required")
+	}
+	serialHex := NormalizeSerial(remaining[0])
+
+	if *validity < 0 || (*validity == 0 && flagWasSet(fs, "validity")) {
+		return usageError("--validity must be a positive integer")
+	}
+
+	dir := resolveDataDir(*dataDir)
+	source := pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: ")
+
+	var result *SignResult
+	var err error
+	if rekey {
+		csrFile := remaining[1]
+		csrPEM, readErr := os.ReadFile(csrFile)
+		if readErr != nil {
+			return reportError(newCAError(KindInvalidInput, "Error: failed to read CSR file %s: %v", csrFile, readErr))
+		}
+		result, err = RekeyCert(dir, serialHex, csrPEM, csrFile, *validity, *revokeOld, source)
+	} else {
+		result, err = RenewCert(dir, serialHex, *validity, *revokeOld, source)
+	}
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("SignResult", result)
+		return 0
+	}
+
+	if rekey {
+		fmt.Println("Certificate rekeyed successfully.")
+	} else {
+		fmt.Println("Certificate renewed successfully.")
+	}
+	fmt.Printf("  Serial:      %s\n", result.Serial)
+	if result.ReplacedRevoked {
+		fmt.Printf("  Replaces:    %s (revoked: superseded)\n", result.Replaces)
+	} else {
+		fmt.Printf("  Replaces:    %s\n", result.Replaces)
+	}
+	fmt.Printf("  Subject:     %s\n", result.Subject)
+	fmt.Printf("  Profile:     %s\n", result.Profile)
 	fmt.Printf("  Not After:   %s\n", result.NotAfter.Format(time.RFC3339))
 	fmt.Printf("  Certificate: %s\n", result.CertPath)
 
@@ -176,46 +520,92 @@ func runSign(args []string) int {
 func runRevoke(args []string) int {
 	fs := flag.NewFlagSet("revoke", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
 
 	reason := fs.String("reason", "unspecified", "Reason code")
+	invalidity := fs.String("invalidity-date", "", "Date the key became invalid (YYYY-MM-DD or RFC 3339)")
 	dataDir := fs.String("data-dir", "", "CA data directory path")
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 2
+		return usageError("%v", err)
 	}
 
 	remaining := fs.Args()
 	if len(remaining) < 1 {
-		fmt.Fprintln(os.Stderr, "Error: serial number is required")
-		return 2
+		return usageError("serial number is required")
 	}
-	serialHex := strings.ToLower(remaining[0])
+	serialHex := NormalizeSerial(remaining[0])
 
 	// Validate reason code
-	validReason := false
-	for _, r := range ValidReasons {
-		if *reason == r {
-			validReason = true
-			break
-		}
+	if !isValidReason(*reason) {
+		return usageError("invalid reason code %q. Valid: %s", *reason, strings.Join(ValidReasons, ", "))
 	}
-	if !validReason {
-		fmt.Fprintf(os.Stderr, "Error: invalid reason code %q. Valid: %s\n", *reason, strings.Join(ValidReasons, ", "))
-		return 2
+
+	var invalidityDate time.Time
+	if *invalidity != "" {
+		var err error
+		if invalidityDate, err = ParseInvalidityDate(*invalidity); err != nil {
+			return usageError("%s", strings.TrimPrefix(err.Error(), "Error: "))
+		}
 	}
 
 	dir := resolveDataDir(*dataDir)
 
-	if err := RevokeCert(dir, serialHex, *reason); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+	if err := RevokeCert(dir, serialHex, *reason, invalidityDate); err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("RevokeResult", RevokeResult{Serial: serialHex, Reason: *reason})
+		return 0
 	}
 
 	// Format output per SPEC.md §4.1.3 (REQ-MK-005)
 	fmt.Println("Certificate revoked successfully.")
 	fmt.Printf("  Serial: %s\n", serialHex)
 	fmt.Printf("  Reason: %s\n", *reason)
+	if !invalidityDate.IsZero() {
+		fmt.Printf("  Invalidity date: %s\n", invalidityDate.Format(time.RFC3339))
+	}
+
+	return 0
+}
+
+// runUnhold handles the "ca unhold" command: releases a certificateHold.
+// Enforces CON-BD-023: exit codes
+func runUnhold(args []string) int {
+	fs := flag.NewFlagSet("unhold", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+
+	remaining := fs.Args()
+	if len(remaining) < 1 {
+		return usageError("serial number is required")
+	}
+	serialHex := NormalizeSerial(remaining[0])
+
+	dir := resolveDataDir(*dataDir)
+
+	result, err := UnholdCert(dir, serialHex)
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("UnholdResult", result)
+		return 0
+	}
+
+	fmt.Println("Certificate hold released.")
+	fmt.Printf("  Serial:     %s\n", result.Serial)
+	fmt.Printf("  Held since: %s\n", result.HeldSince.Format(time.RFC3339))
+	fmt.Println("  The certificate is omitted from the next CRL.")
 
 	return 0
 }
@@ -226,35 +616,54 @@ func runRevoke(args []string) int {
 func runCRL(args []string) int {
 	fs := flag.NewFlagSet("crl", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
 
 	nextUpdate := fs.Int("next-update", 24, "Hours until next CRL update")
+	delta := fs.Bool("delta", false, "Generate a delta CRL against the current ca.crl")
 	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "CA key")
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 2
+		return usageError("%v", err)
 	}
 
 	if *nextUpdate <= 0 {
-		fmt.Fprintln(os.Stderr, "Error: --next-update must be a positive integer")
-		return 2
+		return usageError("--next-update must be a positive integer")
 	}
 
 	dir := resolveDataDir(*dataDir)
 
-	result, err := GenerateCRL(dir, *nextUpdate)
+	result, err := GenerateCRL(dir, *nextUpdate, *delta, pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return reportError(err)
 	}
 
 	// Format output per SPEC.md §4.1.4 (REQ-MK-005)
-	fmt.Println("CRL generated successfully.")
+	if structured() {
+		printResult("CRLResult", result)
+		return 0
+	}
+
+	if result.Delta {
+		fmt.Println("Delta CRL generated successfully.")
+	} else {
+		fmt.Println("CRL generated successfully.")
+	}
 	fmt.Printf("  This Update:          %s\n", result.ThisUpdate.Format(time.RFC3339))
 	fmt.Printf("  Next Update:          %s\n", result.NextUpdate.Format(time.RFC3339))
 	fmt.Printf("  CRL Number:           %d\n", result.CRLNumber)
+	if result.Delta {
+		fmt.Printf("  Base CRL Number:      %d\n", result.BaseCRLNumber)
+	}
 	fmt.Printf("  Revoked certificates: %d\n", result.RevokedCount)
+	if result.Delta {
+		fmt.Printf("  Removed from CRL:     %d\n", result.RemovedCount)
+	}
 	fmt.Printf("  CRL: %s\n", result.CRLPath)
+	fmt.Printf("  Archived as: %s\n", result.ArchivePath)
+	for _, r := range result.Retired {
+		fmt.Printf("  Generation %d CRL %d (%d revoked): %s\n", r.Generation, r.CRLNumber, r.RevokedCount, r.CRLPath)
+	}
 
 	return 0
 }
@@ -266,151 +675,644 @@ func runCRL(args []string) int {
 func runList(args []string) int {
 	fs := flag.NewFlagSet("list", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
 
+	var filter CertFilter
+	fs.StringVar(&filter.Status, "status", "", "Only certificates with this status: active, revoked or expired")
+	fs.StringVar(&filter.Subject, "subject", "", "Only certificates with exactly this subject DN")
+	fs.StringVar(&filter.SAN, "san", "", "Only certificates with this SAN (dns:NAME, ip:ADDR, email:ADDR, uri:URI, or a bare name)")
 	dataDir := fs.String("data-dir", "", "CA data directory path")
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 2
+		return usageError("%v", err)
+	}
+	if filter.Status != "" && !contains([]string{"active", "revoked", "expired"}, filter.Status) {
+		return usageError("--status must be active, revoked or expired")
 	}
 
 	dir := resolveDataDir(*dataDir)
 
-	certs, err := ListCerts(dir)
+	certs, err := ListCerts(dir, filter)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return reportError(err)
+	}
+
+	if structured() {
+		if certs == nil {
+			certs = []CertInfo{}
+		}
+		printResult("CertInfoList", certs)
+		return 0
 	}
 
 	if len(certs) == 0 {
-		fmt.Println("No certificates issued.")
+		if filter != (CertFilter{}) {
+			fmt.Println("No matching certificates.")
+		} else {
+			fmt.Println("No certificates issued.")
+		}
 		return 0
 	}
 
 	// Format table per SPEC.md §4.1.5
-	fmt.Printf("%-8s%-9s%-22s%s\n", "SERIAL", "STATUS", "NOT AFTER", "SUBJECT")
+	serials := make([]string, len(certs))
+	for i, c := range certs {
+		serials[i] = c.Serial
+	}
+	w := serialColumn(serials)
+	fmt.Printf("%-*s%-9s%-22s%s\n", w, "SERIAL", "STATUS", "NOT AFTER", "SUBJECT")
 	for _, c := range certs {
-		fmt.Printf("%-8s%-9s%-22s%s\n", c.Serial, c.Status, c.NotAfter.Format(time.RFC3339), c.Subject)
+		fmt.Printf("%-*s%-9s%-22s%s\n", w, c.Serial, c.Status, c.NotAfter.Format(time.RFC3339), c.Subject)
 	}
 
 	return 0
 }
 
-// runVerify handles the "ca verify" command.
-// Enforces CON-BD-016: precondition validation
-// Enforces CON-BD-017: verification report format
-// Enforces CON-BD-023: exit codes
-func runVerify(args []string) int {
-	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+// serialColumn returns the width of a SERIAL table column: 8, as SPEC.md §4.1.5
+// lays it out, or wide enough for the longest random serial.
+func serialColumn(serials []string) int {
+	w := 8
+	for _, s := range serials {
+		if len(s)+2 > w {
+			w = len(s) + 2
+		}
+	}
+	return w
+}
+
+// runRollover handles the "ca rollover" command.
+// Enforces CON-BD-023: exit codes (0 success, 1 operational, 2 usage)
+// Enforces CON-SC-001: only print key file path, never key content
+func runRollover(args []string) int {
+	fs := flag.NewFlagSet("rollover", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
 
 	dataDir := fs.String("data-dir", "", "CA data directory path")
+	subject := fs.String("subject", "", "Distinguished Name of the new root (default: the current root's)")
+	keyAlgo := fs.String("key-algorithm", "", "Key algorithm of the new root: "+KeyAlgorithmNames()+" (default: the current one)")
+	validity := fs.Int("validity", 3650, "Validity period of the new root in days")
+	pass := addPassphraseFlags(fs, "", "CA key")
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 2
+		return usageError("%v", err)
 	}
-
-	remaining := fs.Args()
-	if len(remaining) < 1 {
-		fmt.Fprintln(os.Stderr, "Error: certificate file path is required")
-		return 2
+	if fs.NArg() > 0 {
+		return usageError("usage: ca rollover [--subject DN] [--key-algorithm alg] [--validity days]")
+	}
+	if *keyAlgo != "" {
+		if _, ok := lookupKeyAlgorithm(*keyAlgo); !ok {
+			return usageError("invalid key algorithm %q. Must be one of: %s", *keyAlgo, KeyAlgorithmNames())
+		}
+	}
+	if *validity <= 0 {
+		return usageError("--validity must be a positive integer")
+	}
+	var newSubject *pkix.Name
+	if *subject != "" {
+		parsed, err := ParseDN(*subject)
+		if err != nil {
+			return usageError("invalid subject: %v", err)
+		}
+		newSubject = &parsed
 	}
-	certFile := remaining[0]
 
 	dir := resolveDataDir(*dataDir)
 
-	certPEM, err := os.ReadFile(certFile)
+	result, err := RolloverCA(dir, newSubject, *keyAlgo, *validity, pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to read certificate file %s: %v\n", certFile, err)
-		return 1
+		return reportError(err)
 	}
 
-	result, err := VerifyCert(dir, certPEM, certFile)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+	if structured() {
+		printResult("RolloverResult", result)
+		return 0
 	}
+	fmt.Printf("CA rolled over to generation %d.\n", result.Generation)
+	fmt.Printf("  Subject:      %s\n", result.Subject)
+	fmt.Printf("  Algorithm:    %s\n", result.Algorithm)
+	fmt.Printf("  Serial:       %s\n", result.Serial)
+	fmt.Printf("  Not After:    %s\n", result.NotAfter.Format(time.RFC3339))
+	fmt.Printf("  Certificate:  %s\n", result.CertPath)
+	fmt.Printf("  Key:          %s\n", result.KeyPath)
+	fmt.Printf("  Previous:     %s (signs CRLs until %s)\n", result.PreviousDir, result.PreviousNotAfter.Format(time.RFC3339))
+	fmt.Printf("  New with old: %s\n", result.NewWithOldPath)
+	fmt.Printf("  Old with new: %s\n", result.OldWithNewPath)
+	fmt.Println("Run 'ca crl' to publish the new generation's first CRL.")
+	return 0
+}
 
-	// Format verification report per SPEC.md §4.1.6
-	if result.Valid {
-		fmt.Println("Certificate verification: VALID")
-	} else {
-		fmt.Println("Certificate verification: INVALID")
-	}
+// runMigrateStore handles the "ca migrate-store" command.
+// Enforces CON-BD-023: exit codes
+func runMigrateStore(args []string) int {
+	fs := flag.NewFlagSet("migrate-store", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
 
-	fmt.Printf("  Subject:    %s\n", result.Subject)
-	fmt.Printf("  Serial:     %s\n", result.Serial)
-	fmt.Printf("  Issuer:     %s\n", result.Issuer)
-	fmt.Printf("  Not Before: %s\n", result.NotBefore.Format(time.RFC3339))
-	fmt.Printf("  Not After:  %s\n", result.NotAfter.Format(time.RFC3339))
+	dataDir := fs.String("data-dir", "", "CA data directory path")
 
-	if result.SigOK {
-		fmt.Println("  Signature:  OK")
-	} else {
-		fmt.Println("  Signature:  FAILED")
-		if result.Valid {
-			return 0
-		}
-		return 1 // Early return — no further checks shown (CON-BD-017)
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
 	}
 
-	if result.ExpiryOK {
-		fmt.Println("  Expiry:     OK")
-	} else {
-		fmt.Println("  Expiry:     EXPIRED")
-	}
+	dir := resolveDataDir(*dataDir)
 
-	fmt.Printf("  Revocation: %s\n", result.RevStatus)
+	result, err := MigrateStore(dir)
+	if err != nil {
+		return reportError(err)
+	}
 
-	if result.Valid {
+	if structured() {
+		printResult("MigrateResult", result)
 		return 0
 	}
-	return 1
+
+	fmt.Println("Index migrated.")
+	fmt.Printf("  Entries:      %d (%d with SANs read from certs/)\n", result.Entries, result.SANsRecorded)
+	fmt.Printf("  Store:        %s\n", result.StorePath)
+	fmt.Printf("  Old index:    %s\n", result.BackupPath)
+
+	return 0
 }
 
-// runRequest handles the "ca request" command.
-// Enforces CON-BD-019: precondition validation
-// Enforces CON-BD-021: SAN format validation
+// ExportResult describes an index export written to a file.
+type ExportResult struct {
+	Entries int    `json:"entries"`
+	Path    string `json:"path"`
+}
+
+// runExportIndex handles the "ca export-index" command. Without --out the index is
+// written to stdout, which is JSON whatever the output format.
 // Enforces CON-BD-023: exit codes
-func runRequest(args []string) int {
-	fs := flag.NewFlagSet("request", flag.ContinueOnError)
+func runExportIndex(args []string) int {
+	fs := flag.NewFlagSet("export-index", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
 
-	subject := fs.String("subject", "", "Distinguished Name for the CSR")
-	san := fs.String("san", "", "Comma-separated SANs: DNS:name,IP:addr")
-	keyAlgo := fs.String("key-algorithm", "ecdsa-p256", "Key algorithm: ecdsa-p256 or rsa-2048")
-	outKey := fs.String("out-key", "", "Output path for generated private key")
-	outCSR := fs.String("out-csr", "", "Output path for generated CSR")
+	out := fs.String("out", "", "Write the export to this file instead of stdout")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
 
 	if err := fs.Parse(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 2
+		return usageError("%v", err)
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	data, n, err := ExportIndex(dir)
+	if err != nil {
+		return reportError(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := writeFileAtomic(*out, data, 0644); err != nil {
+		return reportError(fmt.Errorf("failed to write %s: %w", *out, err))
+	}
+
+	if structured() {
+		printResult("ExportResult", &ExportResult{Entries: n, Path: *out})
+		return 0
+	}
+	fmt.Printf("Exported %d index entries to %s\n", n, *out)
+	return 0
+}
+
+// runExpiring handles the "ca expiring" command. Unlike the other commands it exits
+// with monitoring plugin codes: 0 OK, 1 WARNING, 2 CRITICAL, 3 UNKNOWN (any error,
+// including usage errors), so it can run unchanged from cron or as a Nagios check.
+func runExpiring(args []string) int {
+	fs := flag.NewFlagSet("expiring", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	within := fs.String("within", "30d", "Report certificates expiring within this window (30d, 2w, 36h)")
+	warn := fs.String("warn", "", "WARNING when a certificate expires within this window (default: --within)")
+	crit := fs.String("crit", "7d", "CRITICAL when a certificate expires within this window")
+	includeCA := fs.Bool("include-ca", false, "Also check the CA certificate itself")
+	includeExpired := fs.String("include-expired", "", "Also report certificates that expired within this window before now, as CRITICAL")
+	jsonOut := fs.Bool("json", false, "Same as --output json")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+
+	if err := fs.Parse(args); err != nil {
+		return printError(ExpiryUnknown, "usage", fmt.Sprintf("Error: %v", err))
+	}
+	if *warn == "" {
+		*warn = *within
+	}
+
+	windows := map[string]time.Duration{}
+	for name, value := range map[string]string{"within": *within, "warn": *warn, "crit": *crit} {
+		d, err := ParseWindow(value)
+		if err != nil {
+			return printError(ExpiryUnknown, "usage", fmt.Sprintf("Error: --%s: %v", name, err))
+		}
+		windows[name] = d
+	}
+	if windows["crit"] > windows["warn"] || windows["warn"] > windows["within"] {
+		return printError(ExpiryUnknown, "usage", "Error: windows must satisfy --crit <= --warn <= --within")
+	}
+	var expiredWithin time.Duration
+	if *includeExpired != "" {
+		d, err := ParseWindow(*includeExpired)
+		if err != nil {
+			return printError(ExpiryUnknown, "usage", fmt.Sprintf("Error: --include-expired: %v", err))
+		}
+		expiredWithin = d
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	certs, err := ExpiringCerts(dir, windows["within"], expiredWithin, *includeCA)
+	if err != nil {
+		return printError(ExpiryUnknown, errorCode(err), err.Error())
+	}
+	level := ExpiryLevel(certs, windows["warn"], windows["crit"])
+
+	if *jsonOut {
+		output.format = "json"
+	}
+	if structured() {
+		report := ExpiryReport{ExpiryLevelNames[level], *within, *warn, *crit, *includeExpired, certs}
+		if report.Certificates == nil {
+			report.Certificates = []ExpiringCert{}
+		}
+		printResult("ExpiryReport", report)
+		return level
+	}
+
+	// First line is the plugin status line
+	if len(certs) == 0 {
+		fmt.Printf("%s: no certificates expire within %s\n", ExpiryLevelNames[level], *within)
+		return level
+	}
+	expired := 0
+	for _, c := range certs {
+		if c.Status == "expired" {
+			expired++
+		}
+	}
+	noun := "certificates expire"
+	if len(certs)-expired == 1 {
+		noun = "certificate expires"
+	}
+	fmt.Printf("%s: %d %s within %s", ExpiryLevelNames[level], len(certs)-expired, noun, *within)
+	if expired > 0 {
+		fmt.Printf(", %d expired within %s", expired, *includeExpired)
+	}
+	fmt.Println()
+	serials := make([]string, len(certs))
+	for i, c := range certs {
+		serials[i] = c.Serial
+	}
+	w := serialColumn(serials)
+	fmt.Printf("%-*s%-9s%-22s%-6s%s\n", w, "SERIAL", "STATUS", "NOT AFTER", "DAYS", "SUBJECT")
+	for _, c := range certs {
+		fmt.Printf("%-*s%-9s%-22s%-6d%s\n", w, c.Serial, c.Status, c.NotAfter.Format(time.RFC3339), c.RemainingDays, c.Subject)
+	}
+	return level
+}
+
+// runFsck handles the "ca fsck" command.
+// Enforces CON-BD-023: exit codes (0 consistent or repaired, 1 issues remain, 2 usage)
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	repair := fs.Bool("repair", false, "Fix the issues that can be fixed from the files on disk")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+	if fs.NArg() > 0 {
+		return usageError("usage: ca fsck [--repair]")
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	result, err := CheckDataDir(dir, *repair)
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("FsckResult", result)
+		if result.Clean {
+			return 0
+		}
+		return 1
+	}
+
+	switch {
+	case len(result.Issues) == 0:
+		fmt.Println("Data directory check: OK")
+	case result.Clean:
+		fmt.Println("Data directory check: REPAIRED")
+	default:
+		fmt.Println("Data directory check: ISSUES FOUND")
+	}
+	fmt.Printf("  Data directory: %s\n", result.DataDir)
+	fmt.Printf("  Certificates:   %d\n", result.Certificates)
+	fmt.Printf("  Index entries:  %d\n", result.IndexEntries)
+	fmt.Printf("  CRLs:           %d\n", result.CRLs)
+	for _, is := range result.Issues {
+		switch {
+		case is.Repaired:
+			fmt.Printf("  Repaired: %s: %s (%s)\n", is.Path, is.Problem, is.Repair)
+		case is.Repair != "":
+			fmt.Printf("  Issue:    %s: %s (--repair will %s)\n", is.Path, is.Problem, is.Repair)
+		default:
+			fmt.Printf("  Issue:    %s: %s\n", is.Path, is.Problem)
+		}
+	}
+	if result.Clean {
+		return 0
+	}
+	return 1
+}
+
+// runBackup handles the "ca backup" command.
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	out := fs.String("out", "", "Path of the backup archive to write")
+	encrypt := fs.Bool("encrypt", false, "Encrypt the archive with a backup passphrase")
+	pass := addPassphraseFlags(fs, "", "CA key")
+	backupPass := addPassphraseFlags(fs, "backup-", "backup")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+	if *out == "" {
+		return usageError("--out is required")
+	}
+	if !*encrypt && (flagWasSet(fs, "backup-passphrase-file") || flagWasSet(fs, "backup-passphrase-fd")) {
+		return usageError("--backup-passphrase-file and --backup-passphrase-fd require --encrypt")
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	var backupPassphrase []byte
+	if *encrypt {
+		var err error
+		backupPassphrase, err = backupPass.newPassphrase("CA_BACKUP_PASSPHRASE", "Backup passphrase: ")
+		if err != nil {
+			return usageError("%v", err)
+		}
+	}
+
+	result, err := Backup(dir, *out, pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "), backupPassphrase)
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("BackupResult", result)
+		return 0
+	}
+	fmt.Printf("Backup written to %s\n", result.Path)
+	fmt.Printf("  CA:        %s\n", result.CA)
+	fmt.Printf("  Files:     %d\n", result.Files)
+	fmt.Printf("  Size:      %d bytes\n", result.Bytes)
+	fmt.Printf("  Encrypted: %t\n", result.Encrypted)
+	fmt.Printf("  SHA-256:   %s\n", result.SHA256)
+	return 0
+}
+
+// runRestore handles the "ca restore" command.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "Data directory to restore into (must not exist or be empty)")
+	in := fs.String("in", "", "Path of the backup archive")
+	caCert := fs.String("ca-cert", "", "Only restore a backup of this CA certificate")
+	pass := addPassphraseFlags(fs, "", "CA key")
+	backupPass := addPassphraseFlags(fs, "backup-", "backup")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+	if *in == "" {
+		return usageError("--in is required")
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	var expect *x509.Certificate
+	if *caCert != "" {
+		cert, err := LoadCertificate(*caCert)
+		if err != nil {
+			return reportError(newCAError(KindInvalidInput, "Error: %v", err))
+		}
+		expect = cert
+	}
+
+	result, err := Restore(dir, *in, expect,
+		backupPass.source("CA_BACKUP_PASSPHRASE", "Backup passphrase: "),
+		pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "))
+	if err != nil {
+		return reportError(err)
+	}
+	if !result.SignatureTrusted {
+		fmt.Fprintln(os.Stderr, "Warning: the backup was only checked against its own ca.crt; pass --ca-cert to check it against a CA certificate you trust.")
+	}
+
+	if structured() {
+		printResult("RestoreResult", result)
+		return 0
+	}
+	fmt.Printf("Restored %s\n", result.DataDir)
+	fmt.Printf("  CA:          %s\n", result.CA)
+	fmt.Printf("  Backup from: %s\n", result.Created)
+	fmt.Printf("  Files:       %d\n", result.Files)
+	if result.KeyChecked {
+		fmt.Println("  CA key:      matches ca.crt")
+	} else {
+		fmt.Println("  CA key:      held by the signer provider (not checked)")
+	}
+	return 0
+}
+
+// runVerify handles the "ca verify" command.
+// Enforces CON-BD-016: precondition validation
+// Enforces CON-BD-017: verification report format
+// Enforces CON-BD-023: exit codes
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	purpose := fs.String("purpose", "", "Require the chain to allow this use: "+VerifyPurposeNames())
+	hostname := fs.String("hostname", "", "Require a DNS name SAN matching this host name")
+	ip := fs.String("ip", "", "Require an IP address SAN matching this address")
+	checkIndex := fs.Bool("index", false, "Also check revocation against the certificate index")
+	ocspURL := fs.String("ocsp", "", "Also ask the OCSP responder at this URL")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+
+	remaining := fs.Args()
+	if len(remaining) < 1 {
+		return usageError("certificate file path is required")
+	}
+	certFile := remaining[0]
+	if _, ok := verifyPurposes[*purpose]; *purpose != "" && !ok {
+		return usageError("--purpose must be one of: %s", VerifyPurposeNames())
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return reportError(newCAError(KindInvalidInput, "Error: failed to read certificate file %s: %v", certFile, err))
+	}
+
+	opts := VerifyOptions{Purpose: *purpose, Hostname: *hostname, IP: *ip, Index: *checkIndex, OCSPURL: *ocspURL}
+	result, err := VerifyCert(dir, certPEM, certFile, opts)
+	if err != nil {
+		return reportError(err)
+	}
+
+	// Format verification report per SPEC.md §4.1.6
+	if structured() {
+		printResult("VerifyResult", result)
+		if result.Valid {
+			return 0
+		}
+		return 1
+	}
+
+	if result.Valid {
+		fmt.Println("Certificate verification: VALID")
+	} else {
+		fmt.Println("Certificate verification: INVALID")
+	}
+
+	fmt.Printf("  Subject:    %s\n", result.Subject)
+	fmt.Printf("  Serial:     %s\n", result.Serial)
+	fmt.Printf("  Issuer:     %s\n", result.Issuer)
+	if result.Generation > 0 {
+		fmt.Printf("  Generation: %d\n", result.Generation)
+	}
+	if len(result.Chain) > 2 {
+		fmt.Printf("  Chain:      %s\n", strings.Join(result.Chain, " -> "))
+	}
+	fmt.Printf("  Not Before: %s\n", result.NotBefore.Format(time.RFC3339))
+	fmt.Printf("  Not After:  %s\n", result.NotAfter.Format(time.RFC3339))
+	for _, u := range result.OCSPServers {
+		fmt.Printf("  OCSP:       %s\n", u)
+	}
+	for _, u := range result.CAIssuers {
+		fmt.Printf("  CA Issuers: %s\n", u)
+	}
+	for _, u := range result.CRLDistributionPoints {
+		fmt.Printf("  CRL:        %s\n", u)
+	}
+	for _, u := range result.FreshestCRL {
+		fmt.Printf("  Delta CRL:  %s\n", u)
+	}
+
+	if result.SigOK {
+		fmt.Println("  Signature:  OK")
+	} else {
+		fmt.Println("  Signature:  FAILED")
+		if result.Valid {
+			return 0
+		}
+		return 1 // Early return — no further checks shown (CON-BD-017)
+	}
+
+	if result.PathOK {
+		fmt.Print("  Path:       OK")
+	} else {
+		fmt.Print("  Path:       FAILED")
+	}
+	if result.Purpose != "" {
+		fmt.Printf(" (purpose %s)", result.Purpose)
+	}
+	fmt.Println()
+	for _, l := range result.Links {
+		source := ""
+		if l.Source == "bundle" {
+			source = ", from the bundle"
+		}
+		fmt.Printf("    %s (serial %s%s): %s\n", l.Subject, l.Serial, source, l.Status)
+	}
+
+	if result.ExpiryOK {
+		fmt.Println("  Expiry:     OK")
+	} else {
+		fmt.Println("  Expiry:     EXPIRED")
+	}
+
+	fmt.Printf("  Revocation: %s\n", result.RevStatus)
+	if result.CRLStatus != "" {
+		fmt.Printf("    CRL:   %s\n", result.CRLStatus)
+	}
+	if result.IndexStatus != "" {
+		fmt.Printf("    Index: %s\n", result.IndexStatus)
+	}
+	if result.OCSPStatus != "" {
+		fmt.Printf("    OCSP:  %s\n", result.OCSPStatus)
+	}
+	if result.NameStatus == "OK" {
+		fmt.Printf("  Name:       OK (%s)\n", strings.Join(result.Names, ", "))
+	} else if result.NameStatus != "" {
+		fmt.Printf("  Name:       %s\n", result.NameStatus)
+	}
+
+	if result.Valid {
+		return 0
+	}
+	return 1
+}
+
+// runRequest handles the "ca request" command.
+// Enforces CON-BD-019: precondition validation
+// Enforces CON-BD-021: SAN format validation
+// Enforces CON-BD-023: exit codes
+func runRequest(args []string) int {
+	fs := flag.NewFlagSet("request", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	subject := fs.String("subject", "", "Distinguished Name for the CSR")
+	san := fs.String("san", "", "Comma-separated SANs: DNS:name,IP:addr")
+	keyAlgo := fs.String("key-algorithm", "ecdsa-p256", "Key algorithm: "+KeyAlgorithmNames())
+	outKey := fs.String("out-key", "", "Output path for generated private key")
+	outCSR := fs.String("out-csr", "", "Output path for generated CSR")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
 	}
 
 	// Validate required flags (CON-BD-019)
 	if *subject == "" {
-		fmt.Fprintln(os.Stderr, "Error: --subject is required")
-		return 2
+		return usageError("--subject is required")
 	}
 	if *outKey == "" {
-		fmt.Fprintln(os.Stderr, "Error: --out-key is required")
-		return 2
+		return usageError("--out-key is required")
 	}
 	if *outCSR == "" {
-		fmt.Fprintln(os.Stderr, "Error: --out-csr is required")
-		return 2
+		return usageError("--out-csr is required")
 	}
 
-	if *keyAlgo != "ecdsa-p256" && *keyAlgo != "rsa-2048" {
-		fmt.Fprintf(os.Stderr, "Error: invalid key algorithm %q. Must be ecdsa-p256 or rsa-2048\n", *keyAlgo)
-		return 2
+	if _, ok := lookupKeyAlgorithm(*keyAlgo); !ok {
+		return usageError("invalid key algorithm %q. Must be one of: %s", *keyAlgo, KeyAlgorithmNames())
 	}
 
 	parsedSubject, err := ParseDN(*subject)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: invalid subject: %v\n", err)
-		return 2
+		return usageError("invalid subject: %v", err)
 	}
 
 	var dnsNames []string
@@ -418,18 +1320,21 @@ func runRequest(args []string) int {
 	if *san != "" {
 		dnsNames, ips, err = ParseSANs(*san)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: invalid SAN: %v\n", err)
-			return 2
+			return usageError("invalid SAN: %v", err)
 		}
 	}
 
 	result, err := GenerateCSR(parsedSubject, dnsNames, ips, *keyAlgo, *outKey, *outCSR)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return reportError(err)
 	}
 
 	// Format output per SPEC.md §4.1.7 (REQ-MK-005)
+	if structured() {
+		printResult("RequestResult", result)
+		return 0
+	}
+
 	fmt.Println("CSR generated successfully.")
 	fmt.Printf("  Subject:   %s\n", result.Subject)
 	fmt.Printf("  Algorithm: %s\n", result.Algorithm)
@@ -439,16 +1344,587 @@ func runRequest(args []string) int {
 	return 0
 }
 
+// runKey handles the "ca key" command group.
+// Subcommands: encrypt (migrate a plaintext ca.key), change-passphrase.
+// Enforces CON-SC-001: only print key file path, never key content
+// Enforces CON-BD-023: exit codes
+func runKey(args []string) int {
+	if len(args) < 1 {
+		return usageError("key subcommand is required (encrypt, change-passphrase)")
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("key "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "current CA key")
+	newPass := addPassphraseFlags(fs, "new-", "new CA key")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return usageError("%v", err)
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	if sub != "encrypt" && sub != "change-passphrase" {
+		return usageError("unknown key subcommand %q", sub)
+	}
+
+	// VALIDATE before prompting (ADR-003): the key must be in the state the subcommand expects
+	if _, err := CheckKeyState(dir, sub == "change-passphrase"); err != nil {
+		return reportError(err)
+	}
+
+	newPassphrase, err := newPass.newPassphrase("CA_KEY_NEW_PASSPHRASE", "New CA key passphrase: ")
+	if err != nil {
+		return usageError("%v", err)
+	}
+
+	var keyPath string
+	if sub == "encrypt" {
+		keyPath, err = EncryptCAKey(dir, newPassphrase)
+	} else {
+		keyPath, err = ChangeKeyPassphrase(dir, pass.source("CA_KEY_PASSPHRASE", "Current CA key passphrase: "), newPassphrase)
+	}
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("KeyResult", KeyResult{KeyPath: keyPath, Encrypted: true})
+		return 0
+	}
+
+	if sub == "encrypt" {
+		fmt.Println("CA key encrypted successfully.")
+	} else {
+		fmt.Println("CA key passphrase changed successfully.")
+	}
+	fmt.Printf("  Key: %s (encrypted)\n", keyPath)
+
+	return 0
+}
+
+// runPolicy handles the "ca policy test" command: a dry run of the issuance policy
+// that reports every check. Exits 1 if the policy would reject the CSR.
+// Enforces CON-BD-023: exit codes
+func runPolicy(args []string) int {
+	if len(args) < 1 || args[0] != "test" {
+		return usageError("usage: ca policy test [--policy file] <csr-file>")
+	}
+
+	fs := flag.NewFlagSet("policy test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	policyFile := fs.String("policy", "", "Policy file to test instead of the data directory's policy.json")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return usageError("%v", err)
+	}
+
+	remaining := fs.Args()
+	if len(remaining) < 1 {
+		return usageError("CSR file path is required")
+	}
+	csrFile := remaining[0]
+
+	dir := resolveDataDir(*dataDir)
+
+	csrPEM, err := os.ReadFile(csrFile)
+	if err != nil {
+		return reportError(newCAError(KindInvalidInput, "Error: failed to read CSR file %s: %v", csrFile, err))
+	}
+
+	result, err := TestPolicy(dir, *policyFile, csrPEM, csrFile)
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		if result.Checks == nil {
+			result.Checks = []PolicyCheck{}
+		}
+		printResult("PolicyTestResult", result)
+		if result.Allowed {
+			return 0
+		}
+		return 1
+	}
+
+	if result.PolicyPath == "" {
+		fmt.Println("No issuance policy configured; every name is permitted.")
+		return 0
+	}
+	fmt.Printf("Policy: %s\n", result.PolicyPath)
+	failed := 0
+	for _, c := range result.Checks {
+		mark := "PASS"
+		if !c.Passed {
+			mark = "FAIL"
+			failed++
+		}
+		fmt.Printf("  %s  %-40s %s\n", mark, c.Name, c.Reason)
+	}
+	if !result.Allowed {
+		fmt.Printf("Result: DENIED (%d of %d checks failed)\n", failed, len(result.Checks))
+		return 1
+	}
+	fmt.Printf("Result: ALLOWED (%d checks passed)\n", len(result.Checks))
+	return 0
+}
+
+// runConfig handles "ca config show", "ca config set <key> <value>..." and "ca config unset <key>".
+// Enforces CON-BD-023: exit codes
+func runConfig(args []string) int {
+	const usage = "usage: ca config show | set <key> <value>... | unset <key>"
+	if len(args) < 1 || (args[0] != "show" && args[0] != "set" && args[0] != "unset") {
+		return usageError(usage)
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("config "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return usageError("%v", err)
+	}
+
+	remaining := fs.Args()
+	dir := resolveDataDir(*dataDir)
+
+	var config *CAConfig
+	var err error
+	switch sub {
+	case "show":
+		if !IsInitialized(dir) {
+			return reportError(newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.")) // REQ-ER-002
+		}
+		config, err = LoadConfig(dir)
+	case "set":
+		if len(remaining) < 2 {
+			return usageError(usage)
+		}
+		config, err = SetConfig(dir, remaining[0], remaining[1:])
+	case "unset":
+		if len(remaining) != 1 {
+			return usageError(usage)
+		}
+		config, err = SetConfig(dir, remaining[0], nil)
+	}
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("CAConfig", config)
+		return 0
+	}
+
+	for _, key := range ConfigKeys() {
+		values := *configKeys[key](config)
+		if len(values) == 0 {
+			fmt.Printf("%-16s(not set)\n", key+":")
+			continue
+		}
+		for _, v := range values {
+			fmt.Printf("%-16s%s\n", key+":", v)
+		}
+	}
+	fmt.Printf("%-16s%t\n", configKeyRSAPSS+":", config.RSAPSS)
+	fmt.Printf("%-16s%s\n", configKeySigner+":", config.signerProvider())
+	if config.SignerSocket != "" {
+		fmt.Printf("%-16s%s\n", configKeySignerSocket+":", config.SignerSocket)
+	}
+	fmt.Printf("%-16s%s\n", configKeySerialMode+":", config.serialMode())
+	return 0
+}
+
+// runOCSP handles the "ca ocsp serve" command.
+// Enforces CON-BD-023: exit codes
+func runOCSP(args []string) int {
+	if len(args) < 1 || args[0] != "serve" {
+		return usageError("usage: ca ocsp serve [--addr host:port] [--responder-cert file --responder-key file]")
+	}
+
+	fs := flag.NewFlagSet("ocsp serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	addr := fs.String("addr", "127.0.0.1:8080", "Listen address")
+	responderCert := fs.String("responder-cert", "", "Delegated OCSP-signing certificate (default: sign with the CA key)")
+	responderKey := fs.String("responder-key", "", "Private key for --responder-cert")
+	nextUpdate := fs.Int("next-update", 60, "Minutes until nextUpdate in each response")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "signing key")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return usageError("%v", err)
+	}
+
+	if *nextUpdate <= 0 {
+		return usageError("--next-update must be a positive integer")
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	responder, err := LoadOCSPResponder(dir, *responderCert, *responderKey,
+		pass.source("CA_KEY_PASSPHRASE", "Signing key passphrase: "), time.Duration(*nextUpdate)*time.Minute)
+	if err != nil {
+		return reportError(err)
+	}
+
+	if structured() {
+		printResult("ServerInfo", ServerInfo{Service: "ocsp", Addr: *addr, URL: "http://" + *addr + "/"})
+	} else {
+		fmt.Printf("OCSP responder listening on %s\n", *addr)
+	}
+	if err := runServer(*addr, responder, "", ""); err != nil {
+		return reportError(fmt.Errorf("Error: %w", err))
+	}
+	return 0
+}
+
+// runServe handles the "ca serve" command: the REST API over HTTP.
+// Enforces CON-BD-023: exit codes
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	addr := fs.String("addr", "127.0.0.1:8000", "Listen address")
+	tokenFile := fs.String("token-file", "", "File containing a bearer token required on every request")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "CA key")
+
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	var token string
+	if *tokenFile != "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return reportError(newCAError(KindInvalidInput, "Error: failed to read token file %s: %v", *tokenFile, err))
+		}
+		token = string(firstLine(data))
+		if token == "" {
+			return usageError("token file is empty")
+		}
+	}
+
+	if !IsInitialized(dir) {
+		return reportError(newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.")) // REQ-ER-002
+	}
+
+	// Unlock an encrypted key up front so requests never block on a prompt.
+	passphrase := pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: ")
+	config, err := LoadConfig(dir)
+	if err != nil {
+		return reportError(err)
+	}
+	if _, err := LoadCASigner(dir, config, passphrase); err != nil {
+		return reportError(fmt.Errorf("Error: failed to load CA key: %w", err))
+	}
+
+	if structured() {
+		printResult("ServerInfo", ServerInfo{Service: "api", Addr: *addr, URL: "http://" + *addr + "/"})
+	} else {
+		fmt.Printf("CA API listening on %s\n", *addr)
+	}
+	if err := runServer(*addr, NewAPIHandler(dir, passphrase, token), "", ""); err != nil {
+		return reportError(fmt.Errorf("Error: %w", err))
+	}
+	return 0
+}
+
+// runACME handles the "ca acme serve" command: the RFC 8555 ACME server.
+// Enforces CON-BD-023: exit codes
+func runACME(args []string) int {
+	if len(args) < 1 || args[0] != "serve" {
+		return usageError("usage: ca acme serve [--addr host:port] [--validity days] [--http01-port port] [--dns-resolver host:port]")
+	}
+
+	fs := flag.NewFlagSet("acme serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	addr := fs.String("addr", "127.0.0.1:8001", "Listen address")
+	profile := fs.String("profile", "tls-server", "Certificate profile for issued certificates")
+	validity := fs.Int("validity", 90, "Validity period in days for issued certificates")
+	http01Port := fs.Int("http01-port", 80, "Port to fetch http-01 challenge responses from")
+	dnsResolver := fs.String("dns-resolver", "", "DNS server (host:port) for dns-01 lookups (default: system resolver)")
+	tlsCert := fs.String("tls-cert", "", "Serve HTTPS with this certificate")
+	tlsKey := fs.String("tls-key", "", "Private key for --tls-cert")
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	pass := addPassphraseFlags(fs, "", "CA key")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return usageError("%v", err)
+	}
+
+	if *validity <= 0 {
+		return usageError("--validity must be a positive integer")
+	}
+	if *http01Port <= 0 || *http01Port > 65535 {
+		return usageError("--http01-port must be between 1 and 65535")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return usageError("--tls-cert and --tls-key must be given together")
+	}
+	if *dnsResolver != "" {
+		if _, _, err := net.SplitHostPort(*dnsResolver); err != nil {
+			return usageError("invalid --dns-resolver %q: expected host:port", *dnsResolver)
+		}
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	server, err := NewACMEServer(dir, pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "), *profile, *validity, *http01Port, *dnsResolver)
+	if err != nil {
+		return reportError(err)
+	}
+	defer server.Close()
+
+	// Unlock an encrypted key up front so finalize never blocks on a prompt.
+	config, err := LoadConfig(dir)
+	if err != nil {
+		return reportError(err)
+	}
+	if _, err := LoadCASigner(dir, config, server.passphrase); err != nil {
+		return reportError(fmt.Errorf("Error: failed to load CA key: %w", err))
+	}
+
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
+	directory := scheme + "://" + *addr + "/acme/directory"
+	if structured() {
+		printResult("ServerInfo", ServerInfo{Service: "acme", Addr: *addr, URL: directory})
+	} else {
+		fmt.Printf("ACME server listening on %s\n", *addr)
+		fmt.Printf("  Directory: %s\n", directory)
+	}
+	if err := runServer(*addr, server, *tlsCert, *tlsKey); err != nil {
+		return reportError(fmt.Errorf("Error: %w", err))
+	}
+	return 0
+}
+
+// runSigner handles "ca signer serve", the reference service of the remote signer
+// provider. It holds one private key and signs for the CAs whose config selects
+// "signer remote" with this socket.
+// Enforces CON-SC-001: key material stays in the signer process, never sent or printed
+// Enforces CON-BD-023: exit codes
+func runSigner(args []string) int {
+	const usage = "usage: ca signer serve --socket path --key file"
+	if len(args) < 1 || args[0] != "serve" {
+		return usageError(usage)
+	}
+
+	fs := flag.NewFlagSet("signer serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	socket := fs.String("socket", "", "Unix socket to listen on")
+	keyFile := fs.String("key", "", "PKCS#8 private key to sign with, plaintext or encrypted")
+	pass := addPassphraseFlags(fs, "", "signing key")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return usageError("%v", err)
+	}
+	if *socket == "" || *keyFile == "" {
+		return usageError(usage)
+	}
+
+	key, err := LoadPrivateKey(*keyFile, pass.source("CA_KEY_PASSPHRASE", "Signing key passphrase: "))
+	if err != nil {
+		return reportError(fmt.Errorf("Error: failed to load signing key: %w", err))
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || keyAlgorithmOf(signer.Public()) == nil {
+		return reportError(newCAError(KindInvalidInput, "Error: unsupported key algorithm in %s (supported: %s)", *keyFile, KeyAlgorithmNames()))
+	}
+
+	// A socket left behind by an unclean exit would make Listen fail; anything else is kept
+	if fi, err := os.Lstat(*socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(*socket)
+	}
+	ln, err := net.Listen("unix", *socket)
+	if err != nil {
+		return reportError(fmt.Errorf("Error: %w", err))
+	}
+	if err := os.Chmod(*socket, 0600); err != nil {
+		ln.Close()
+		return reportError(fmt.Errorf("Error: %w", err))
+	}
+
+	if structured() {
+		printResult("ServerInfo", ServerInfo{Service: "signer", Addr: *socket, URL: "unix:" + *socket})
+	} else {
+		fmt.Printf("Remote signer listening on %s\n", *socket)
+		fmt.Printf("  Key: %s (%s)\n", *keyFile, keyAlgorithmOf(signer.Public()).DisplayName)
+	}
+	if err := serveListener(ln, NewSignerHandler(signer), "", ""); err != nil {
+		return reportError(fmt.Errorf("Error: %w", err))
+	}
+	return 0
+}
+
+// runServer serves handler on addr until SIGINT or SIGTERM, then shuts down gracefully.
+// When certFile and keyFile are set it serves HTTPS.
+func runServer(addr string, handler http.Handler, certFile string, keyFile string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return serveListener(ln, handler, certFile, keyFile)
+}
+
+// serveListener is runServer for a listener the caller opened.
+func serveListener(ln net.Listener, handler http.Handler, certFile string, keyFile string) error {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errCh <- srv.ServeTLS(ln, certFile, keyFile)
+			return
+		}
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// runAudit handles "ca audit verify" and "ca audit checkpoint".
+// Enforces CON-BD-023: exit codes (0 intact, 1 tampered or operational error, 2 usage)
+func runAudit(args []string) int {
+	const usage = "usage: ca audit verify [--anchor hash] | ca audit checkpoint"
+	if len(args) < 1 || (args[0] != "verify" && args[0] != "checkpoint") {
+		return usageError(usage)
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("audit "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addOutputFlag(fs)
+
+	dataDir := fs.String("data-dir", "", "CA data directory path")
+	anchor := fs.String("anchor", "", "Hash of a record the log must still contain (verify)")
+	pass := addPassphraseFlags(fs, "", "CA key")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return usageError("%v", err)
+	}
+	if fs.NArg() > 0 {
+		return usageError(usage)
+	}
+
+	dir := resolveDataDir(*dataDir)
+
+	if sub == "checkpoint" {
+		rec, err := AuditCheckpoint(dir, pass.source("CA_KEY_PASSPHRASE", "CA key passphrase: "))
+		if err != nil {
+			return reportError(err)
+		}
+		if structured() {
+			printResult("AuditRecord", rec)
+			return 0
+		}
+		fmt.Println("Audit checkpoint signed successfully.")
+		fmt.Printf("  Record: %d\n", rec.Seq)
+		fmt.Printf("  Hash:   %s\n", rec.Hash)
+		return 0
+	}
+
+	result, err := VerifyAuditLog(dir, strings.ToLower(*anchor))
+	if err != nil {
+		return reportError(err)
+	}
+	if structured() {
+		printResult("AuditVerifyResult", result)
+		if result.Valid {
+			return 0
+		}
+		return 1
+	}
+
+	if result.Valid {
+		fmt.Println("Audit log verification: VALID")
+	} else {
+		fmt.Println("Audit log verification: INVALID")
+	}
+	fmt.Printf("  Log:         %s\n", result.Path)
+	fmt.Printf("  Records:     %d\n", result.Records)
+	if result.Records > 0 {
+		fmt.Printf("  Period:      %s to %s\n", result.FirstTime, result.LastTime)
+	}
+	if result.Checkpoints > 0 {
+		fmt.Printf("  Checkpoints: %d (last at record %d)\n", result.Checkpoints, result.LastCheckpoint)
+	} else {
+		fmt.Println("  Checkpoints: none")
+	}
+	if result.Unsigned > 0 {
+		fmt.Printf("  Unsigned:    %d record(s) after the last checkpoint\n", result.Unsigned)
+	}
+	for _, p := range result.Problems {
+		fmt.Printf("  Problem:     %s\n", p)
+	}
+	if result.Valid {
+		return 0
+	}
+	return 1
+}
+
 // printUsage prints available subcommands to stderr.
 func printUsage() {
-	fmt.Fprintln(os.Stderr, "Usage: ca <command> [flags]")
+	fmt.Fprintln(os.Stderr, "Usage: ca [--output text|json|yaml] <command> [flags]")
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "Commands:")
-	fmt.Fprintln(os.Stderr, "  init      Initialize the root Certificate Authority")
+	fmt.Fprintln(os.Stderr, "  init      Initialize a root or intermediate Certificate Authority")
 	fmt.Fprintln(os.Stderr, "  sign      Sign a CSR and issue a certificate")
+	fmt.Fprintln(os.Stderr, "  sign-batch     Sign a directory or manifest of CSRs in one transaction")
+	fmt.Fprintln(os.Stderr, "  renew     Reissue a certificate with a fresh validity period")
+	fmt.Fprintln(os.Stderr, "  rekey     Replace a certificate with one for a new key")
 	fmt.Fprintln(os.Stderr, "  revoke    Revoke a certificate by serial number")
+	fmt.Fprintln(os.Stderr, "  unhold    Release a certificateHold revocation")
 	fmt.Fprintln(os.Stderr, "  crl       Generate a Certificate Revocation List")
-	fmt.Fprintln(os.Stderr, "  list      List all issued certificates")
-	fmt.Fprintln(os.Stderr, "  verify    Verify a certificate")
+	fmt.Fprintln(os.Stderr, "  rollover  Replace the root CA key with a new, cross-certified generation")
+	fmt.Fprintln(os.Stderr, "  list      List issued certificates, optionally by status, subject or SAN")
+	fmt.Fprintln(os.Stderr, "  expiring  Report certificates close to expiry (monitoring plugin exit codes)")
+	fmt.Fprintln(os.Stderr, "  migrate-store  Move a legacy index.json into the index.db store")
+	fmt.Fprintln(os.Stderr, "  export-index   Export the certificate index as index.json")
+	fmt.Fprintln(os.Stderr, "  fsck      Check the data directory for inconsistencies (--repair to fix them)")
+	fmt.Fprintln(os.Stderr, "  backup    Write the whole CA state to a signed archive (--out, optionally --encrypt)")
+	fmt.Fprintln(os.Stderr, "  restore   Restore a backup archive into a new data directory")
+	fmt.Fprintln(os.Stderr, "  verify    Verify a certificate or bundle against the CA")
 	fmt.Fprintln(os.Stderr, "  request   Generate a key pair and CSR for testing")
+	fmt.Fprintln(os.Stderr, "  key       Manage CA key encryption (encrypt, change-passphrase)")
+	fmt.Fprintln(os.Stderr, "  ocsp      Run an OCSP responder (ocsp serve)")
+	fmt.Fprintln(os.Stderr, "  serve     Run the REST API server")
+	fmt.Fprintln(os.Stderr, "  acme      Run an ACME (RFC 8555) server (acme serve)")
+	fmt.Fprintln(os.Stderr, "  policy    Check a CSR against the issuance policy (policy test)")
+	fmt.Fprintln(os.Stderr, "  config    Show or change publication URLs and signing settings (config show|set|unset)")
+	fmt.Fprintln(os.Stderr, "  signer    Serve a CA key over the remote signer protocol (signer serve)")
+	fmt.Fprintln(os.Stderr, "  audit     Check or sign the audit log (audit verify|checkpoint)")
 }
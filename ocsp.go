@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OCSP response status values (RFC 6960 §4.2.1).
+const (
+	ocspSuccessful       = 0
+	ocspMalformedRequest = 1
+	ocspInternalError    = 2
+	ocspUnauthorized     = 6
+)
+
+var (
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+	oidSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// ocspRequest is the OCSPRequest structure (RFC 6960 §4.1.1).
+type ocspRequest struct {
+	TBSRequest        tbsRequest
+	OptionalSignature asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type tbsRequest struct {
+	Version       int           `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName asn1.RawValue `asn1:"explicit,tag:1,optional"`
+	RequestList   []singleRequest
+	Extensions    []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+type singleRequest struct {
+	Cert       certID
+	Extensions []pkix.Extension `asn1:"explicit,tag:0,optional"`
+}
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+// ocspResponse is the OCSPResponse structure (RFC 6960 §4.2.1).
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+	Extensions  []pkix.Extension `asn1:"optional,explicit,tag:1"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag   `asn1:"tag:0,optional"`
+	Revoked    revokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag   `asn1:"tag:2,optional"`
+	ThisUpdate time.Time   `asn1:"generalized"`
+	NextUpdate time.Time   `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// OCSPResponder answers RFC 6960 status requests for certificates issued by one CA,
+// reading status from the index on every request so revocations apply immediately.
+// After ca rollover it answers for every generation of the CA key.
+type OCSPResponder struct {
+	dataDir    string
+	issuers    []*ocspIssuer // one per generation, oldest first
+	pss        bool          // sign with RSASSA-PSS (config rsa-pss)
+	nextUpdate time.Duration
+}
+
+// ocspIssuer is one CA generation as CertIDs name it, with the key that signs its responses.
+type ocspIssuer struct {
+	generation     int
+	cert           *x509.Certificate
+	subjectKeyBits []byte
+	signerKey      crypto.PrivateKey
+	signerCert     *x509.Certificate // nil when responses are signed by the generation's own key
+	responderID    asn1.RawValue
+}
+
+// LoadOCSPResponder prepares a responder for the CA in dataDir. Responses are signed with
+// the key of the generation that issued the certificate, or with a delegated OCSP-signing
+// certificate and key when both paths are given; the delegated certificate then answers for
+// the generation that issued it, and every other generation signs with its own key.
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-INV-005: responses signed by the CA or a certificate it issued for OCSP signing
+func LoadOCSPResponder(dataDir string, responderCertPath string, responderKeyPath string, passphrase PassphraseFunc, nextUpdate time.Duration) (*OCSPResponder, error) {
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	gens, err := LoadGenerations(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &OCSPResponder{dataDir: dataDir, nextUpdate: nextUpdate, pss: config.RSAPSS}
+
+	var delegatedCert *x509.Certificate
+	var delegatedKey crypto.PrivateKey
+	var delegatedGen *CAGeneration
+	if responderCertPath != "" || responderKeyPath != "" {
+		if responderCertPath == "" || responderKeyPath == "" {
+			return nil, newCAError(KindInvalidInput, "Error: a delegated responder needs both a certificate and a key")
+		}
+		delegatedCert, err = LoadCertificate(responderCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load responder certificate: %w", err)
+		}
+		if delegatedGen = generationOf(gens, delegatedCert); delegatedGen == nil {
+			return nil, newCAError(KindInvalidInput, "Error: responder certificate was not issued by this CA")
+		}
+		if !hasExtKeyUsage(delegatedCert, x509.ExtKeyUsageOCSPSigning) {
+			return nil, newCAError(KindInvalidInput, "Error: responder certificate lacks the id-kp-OCSPSigning extended key usage")
+		}
+		delegatedKey, err = LoadPrivateKey(responderKeyPath, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load responder key: %w", err)
+		}
+	}
+
+	for _, g := range gens {
+		iss := &ocspIssuer{generation: g.Number, cert: g.Cert}
+		signingCert := g.Cert
+		if g == delegatedGen {
+			iss.signerKey, iss.signerCert = delegatedKey, delegatedCert
+			signingCert = delegatedCert
+		} else if iss.signerKey, err = g.loadSigner(config, passphrase); err != nil {
+			if g.Current {
+				return nil, fmt.Errorf("failed to load CA key: %w", err)
+			}
+			return nil, fmt.Errorf("failed to load the CA key of generation %d: %w", g.Number, err)
+		}
+
+		// ResponderID byKey: SHA-1 of the signer's subjectPublicKey BIT STRING (RFC 6960 §4.2.1)
+		signerKeyBits, err := subjectPublicKeyBits(signingCert)
+		if err != nil {
+			return nil, err
+		}
+		keyHash := sha1.Sum(signerKeyBits)
+		keyHashDER, err := asn1.Marshal(keyHash[:])
+		if err != nil {
+			return nil, err
+		}
+		iss.responderID = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHashDER}
+
+		if iss.subjectKeyBits, err = subjectPublicKeyBits(g.Cert); err != nil {
+			return nil, err
+		}
+		r.issuers = append(r.issuers, iss)
+	}
+
+	return r, nil
+}
+
+// issuerOf returns the generation a CertID names by its issuer name and key hashes,
+// or nil if it names no generation of this CA.
+func (r *OCSPResponder) issuerOf(id certID, newHash func() hash.Hash) *ocspIssuer {
+	for _, iss := range r.issuers {
+		h := newHash()
+		h.Write(iss.cert.RawSubject)
+		nameHash := h.Sum(nil)
+		h = newHash()
+		h.Write(iss.subjectKeyBits)
+		keyHash := h.Sum(nil)
+		if bytes.Equal(nameHash, id.NameHash) && bytes.Equal(keyHash, id.IssuerKeyHash) {
+			return iss
+		}
+	}
+	return nil
+}
+
+// subjectPublicKeyBits extracts the subjectPublicKey BIT STRING contents from a certificate.
+func subjectPublicKeyBits(cert *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, fmt.Errorf("failed to parse subject public key: %w", err)
+	}
+	return spki.PublicKey.RightAlign(), nil
+}
+
+// certIDHash returns the hash function named by a CertID hashAlgorithm.
+func certIDHash(alg asn1.ObjectIdentifier) func() hash.Hash {
+	switch {
+	case alg.Equal(oidSHA1):
+		return sha1.New
+	case alg.Equal(oidSHA256):
+		return sha256.New
+	case alg.Equal(oidSHA384):
+		return sha512.New384
+	case alg.Equal(oidSHA512):
+		return sha512.New
+	}
+	return nil
+}
+
+// Respond builds a DER-encoded OCSPResponse for a DER-encoded OCSPRequest.
+// Enforces CON-DI-014: system clock for producedAt/thisUpdate
+func (r *OCSPResponder) Respond(reqDER []byte) []byte {
+	var req ocspRequest
+	rest, err := asn1.Unmarshal(reqDER, &req)
+	if err != nil || len(rest) > 0 || len(req.TBSRequest.RequestList) == 0 {
+		return ocspErrorResponse(ocspMalformedRequest)
+	}
+
+	store, err := OpenIndex(r.dataDir)
+	if err != nil {
+		return ocspErrorResponse(ocspInternalError)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014: system clock
+	data := responseData{ProducedAt: now}
+	var issuer *ocspIssuer
+
+	for _, single := range req.TBSRequest.RequestList {
+		id := single.Cert
+		newHash := certIDHash(id.HashAlgorithm.Algorithm)
+		if newHash == nil || id.SerialNumber == nil {
+			return ocspErrorResponse(ocspMalformedRequest)
+		}
+
+		// Only certificates issued by this CA can be answered, and one response is
+		// signed for one generation.
+		iss := r.issuerOf(id, newHash)
+		if iss == nil || issuer != nil && iss != issuer {
+			return ocspErrorResponse(ocspUnauthorized)
+		}
+		issuer = iss
+
+		resp := singleResponse{
+			CertID:     id,
+			ThisUpdate: now,
+			NextUpdate: now.Add(r.nextUpdate),
+		}
+		entry, err := store.Get(FormatSerialBig(id.SerialNumber))
+		if err != nil {
+			return ocspErrorResponse(ocspInternalError)
+		}
+		switch {
+		case entry == nil || entryGeneration(*entry) != issuer.generation:
+			resp.Unknown = true
+		case entry.Status == "revoked":
+			revokedAt, err := time.Parse(time.RFC3339, entry.RevokedAt)
+			if err != nil {
+				return ocspErrorResponse(ocspInternalError)
+			}
+			resp.Revoked = revokedInfo{
+				RevocationTime: revokedAt.UTC(),
+				Reason:         asn1.Enumerated(ReasonCodes[entry.RevocationReason]),
+			}
+		default:
+			resp.Good = true
+		}
+		data.Responses = append(data.Responses, resp)
+	}
+
+	data.ResponderID = issuer.responderID
+
+	// Echo the nonce so the client can bind the response to its request.
+	for _, ext := range req.TBSRequest.Extensions {
+		if ext.Id.Equal(oidOCSPNonce) {
+			data.Extensions = append(data.Extensions, ext)
+		}
+	}
+
+	tbs, err := asn1.Marshal(data)
+	if err != nil {
+		return ocspErrorResponse(ocspInternalError)
+	}
+	algID, sig, err := signTBS(issuer.signerKey, tbs, r.pss)
+	if err != nil {
+		return ocspErrorResponse(ocspInternalError)
+	}
+
+	basic := basicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: algID,
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	}
+	if issuer.signerCert != nil {
+		basic.Certificates = []asn1.RawValue{{FullBytes: issuer.signerCert.Raw}}
+	}
+	basicDER, err := asn1.Marshal(basic)
+	if err != nil {
+		return ocspErrorResponse(ocspInternalError)
+	}
+
+	out, err := asn1.Marshal(ocspResponse{
+		Status:   ocspSuccessful,
+		Response: responseBytes{ResponseType: oidOCSPBasic, Response: basicDER},
+	})
+	if err != nil {
+		return ocspErrorResponse(ocspInternalError)
+	}
+	return out
+}
+
+// ocspErrorResponse builds an unsigned OCSPResponse carrying only an error status.
+func ocspErrorResponse(status int) []byte {
+	out, _ := asn1.Marshal(ocspResponse{Status: asn1.Enumerated(status)})
+	return out
+}
+
+// ServeHTTP implements the RFC 6960 Appendix A HTTP transport: POST with an
+// application/ocsp-request body, or GET with the base64 request in the URL path.
+func (r *OCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var reqDER []byte
+	switch req.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(req.Body, 64*1024))
+		if err != nil {
+			http.Error(w, "failed to read request", http.StatusBadRequest)
+			return
+		}
+		reqDER = body
+	case http.MethodGet:
+		// Many clients leave '/' from the base64 alphabet unescaped, so the whole
+		// path is the request; mount under a prefix with http.StripPrefix.
+		encoded := strings.TrimPrefix(req.URL.EscapedPath(), "/")
+		unescaped, err := url.PathUnescape(encoded)
+		if err != nil {
+			http.Error(w, "malformed request path", http.StatusBadRequest)
+			return
+		}
+		reqDER, err = base64.StdEncoding.DecodeString(unescaped)
+		if err != nil {
+			http.Error(w, "malformed base64 request", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := r.Respond(reqDER)
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+	log.Printf("ocsp: %s request from %s answered (%d bytes)", req.Method, req.RemoteAddr, len(resp))
+}
+
+// OCSPStatus is a responder's answer about one certificate.
+type OCSPStatus struct {
+	Status     string    `json:"status"` // "good", "revoked" or "unknown"
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	ThisUpdate time.Time `json:"this_update"`
+	NextUpdate time.Time `json:"next_update,omitempty"`
+}
+
+// QueryOCSP asks the responder at responderURL for the status of cert, which issuer
+// issued, and checks the answer: it must be signed by issuer or by an OCSP-signing
+// certificate issuer issued, echo the request's nonce, and be current.
+func QueryOCSP(responderURL string, cert, issuer *x509.Certificate) (*OCSPStatus, error) {
+	keyBits, err := subjectPublicKeyBits(issuer)
+	if err != nil {
+		return nil, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(keyBits)
+	id := certID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	nonceValue, err := asn1.Marshal(nonce)
+	if err != nil {
+		return nil, err
+	}
+	reqDER, err := asn1.Marshal(ocspRequest{TBSRequest: tbsRequest{
+		RequestList: []singleRequest{{Cert: id}},
+		Extensions:  []pkix.Extension{{Id: oidOCSPNonce, Value: nonceValue}},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OCSP request: %w", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	httpResp, err := client.Post(responderURL, "application/ocsp-request", bytes.NewReader(reqDER))
+	if err != nil {
+		return nil, fmt.Errorf("OCSP request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned HTTP %d", httpResp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("malformed OCSP response: %w", err)
+	}
+	if resp.Status != ocspSuccessful {
+		return nil, fmt.Errorf("OCSP responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return nil, fmt.Errorf("unsupported OCSP response type %s", resp.Response.ResponseType)
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, fmt.Errorf("malformed OCSP basic response: %w", err)
+	}
+	var data responseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, fmt.Errorf("malformed OCSP response data: %w", err)
+	}
+
+	// The response is signed by the CA, or by a responder certificate it issued for that
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		delegated, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("malformed OCSP responder certificate: %w", err)
+		}
+		if !delegated.Equal(issuer) {
+			if err := delegated.CheckSignatureFrom(issuer); err != nil {
+				return nil, fmt.Errorf("OCSP responder certificate is not issued by %s", FormatDN(issuer.Subject))
+			}
+			if !hasExtKeyUsage(delegated, x509.ExtKeyUsageOCSPSigning) {
+				return nil, fmt.Errorf("OCSP responder certificate is not authorized for OCSP signing")
+			}
+			signer = delegated
+		}
+	}
+	sigAlg, ok := signatureAlgorithmOf(basic.SignatureAlgorithm)
+	if !ok {
+		return nil, fmt.Errorf("unsupported OCSP signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(sigAlg, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("OCSP response signature is invalid: %w", err)
+	}
+	for _, ext := range data.Extensions {
+		if ext.Id.Equal(oidOCSPNonce) && !bytes.Equal(ext.Value, nonceValue) {
+			return nil, fmt.Errorf("OCSP response nonce does not match the request")
+		}
+	}
+
+	now := time.Now()
+	for _, single := range data.Responses {
+		got := single.CertID
+		if got.SerialNumber == nil || got.SerialNumber.Cmp(cert.SerialNumber) != 0 ||
+			!bytes.Equal(got.NameHash, id.NameHash) || !bytes.Equal(got.IssuerKeyHash, id.IssuerKeyHash) {
+			continue
+		}
+		if !single.NextUpdate.IsZero() && now.After(single.NextUpdate) {
+			return nil, fmt.Errorf("OCSP response expired at %s", single.NextUpdate.UTC().Format(time.RFC3339))
+		}
+		status := &OCSPStatus{ThisUpdate: single.ThisUpdate, NextUpdate: single.NextUpdate}
+		switch {
+		case bool(single.Good):
+			status.Status = "good"
+		case bool(single.Unknown):
+			status.Status = "unknown"
+		default:
+			status.Status = "revoked"
+			status.RevokedAt = single.Revoked.RevocationTime
+			if status.Reason = ReasonNames[int(single.Revoked.Reason)]; status.Reason == "" {
+				status.Reason = "unspecified"
+			}
+		}
+		return status, nil
+	}
+	return nil, fmt.Errorf("OCSP response has no status for serial %s", FormatSerialBig(cert.SerialNumber))
+}
+
+// hasExtKeyUsage reports whether cert lists usage among its extended key usages.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
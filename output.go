@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OutputSchemaVersion versions the structured output envelope and the result objects
+// inside it. Fields may be added within a version; removing or changing the meaning
+// of a field requires a new version.
+const OutputSchemaVersion = 1
+
+// outputFormats lists the values accepted by --output.
+var outputFormats = []string{"text", "json", "yaml"}
+
+// output holds the settings of the current invocation: the command name recorded in
+// the envelope and the format selected with --output (global or per command).
+var output = struct {
+	command string
+	format  string
+}{format: "text"}
+
+// outputEnvelope wraps every structured result and error.
+type outputEnvelope struct {
+	Version int          `json:"version"`
+	Command string       `json:"command"`
+	Kind    string       `json:"kind,omitempty"` // result type, e.g. "SignResult"; absent on errors
+	Result  interface{}  `json:"result,omitempty"`
+	Error   *outputError `json:"error,omitempty"`
+}
+
+// outputError is a structured error. Code is stable ("usage", "not_initialized",
+// "invalid_input", ...); Message is the text the error has in text mode, without "Error: ".
+type outputError struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// ServerInfo is printed once by the long-running commands (serve, ocsp serve,
+// acme serve) when they start listening.
+type ServerInfo struct {
+	Service string `json:"service"` // "api", "ocsp", "acme" or "signer"
+	Addr    string `json:"addr"`
+	URL     string `json:"url"`
+}
+
+// outputFlag implements flag.Value for --output.
+type outputFlag struct{}
+
+func (outputFlag) String() string { return output.format }
+
+func (outputFlag) Set(v string) error {
+	if !contains(outputFormats, v) {
+		return fmt.Errorf("invalid output format %q (valid: %s)", v, strings.Join(outputFormats, ", "))
+	}
+	output.format = v
+	return nil
+}
+
+// addOutputFlag registers --output on a command's flag set.
+func addOutputFlag(fs *flag.FlagSet) {
+	fs.Var(outputFlag{}, "output", "Output format: text, json or yaml")
+}
+
+// parseGlobalFlags consumes a leading "--output <format>" given before the command
+// name and returns the remaining arguments.
+func parseGlobalFlags(args []string) ([]string, error) {
+	for len(args) > 0 {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		if !strings.HasPrefix(args[0], "-") || name != "output" {
+			break
+		}
+		args = args[1:]
+		if !hasValue {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("flag needs an argument: -output")
+			}
+			value, args = args[0], args[1:]
+		}
+		if err := (outputFlag{}).Set(value); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// structured reports whether results are printed as JSON or YAML instead of text.
+func structured() bool {
+	return output.format != "text"
+}
+
+// printResult writes result as the structured envelope's payload.
+func printResult(kind string, result interface{}) {
+	writeEnvelope(os.Stdout, outputEnvelope{
+		Version: OutputSchemaVersion,
+		Command: output.command,
+		Kind:    kind,
+		Result:  result,
+	})
+}
+
+// printError reports an error and returns exitCode. Text mode prints msg to stderr
+// unchanged; structured modes print an error envelope to stdout instead.
+func printError(exitCode int, code string, msg string) int {
+	if !structured() {
+		fmt.Fprintln(os.Stderr, msg)
+		return exitCode
+	}
+	writeEnvelope(os.Stdout, outputEnvelope{
+		Version: OutputSchemaVersion,
+		Command: output.command,
+		Error:   &outputError{Code: code, Message: strings.TrimPrefix(msg, "Error: "), ExitCode: exitCode},
+	})
+	return exitCode
+}
+
+// reportError reports an operational error and returns exit code 1 (CON-BD-023).
+func reportError(err error) int {
+	return printError(1, errorCode(err), err.Error())
+}
+
+// usageError reports a usage error and returns exit code 2 (CON-BD-023).
+func usageError(format string, args ...interface{}) int {
+	return printError(2, "usage", "Error: "+fmt.Sprintf(format, args...))
+}
+
+func writeEnvelope(w io.Writer, env outputEnvelope) {
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to encode output: %v\n", err)
+		return
+	}
+	if output.format == "yaml" {
+		if data, err = jsonToYAML(data); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to encode output: %v\n", err)
+			return
+		}
+	}
+	w.Write(append(data, '\n'))
+}
+
+// jsonToYAML re-encodes a JSON document as block-style YAML, keeping the key order
+// of the JSON encoding (and so of the Go struct fields). Strings are quoted whenever
+// a plain scalar could be read back as something else.
+func jsonToYAML(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	if err := yamlValue(dec, &buf, "", 0); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// yamlValue writes the next JSON value from dec. head is the text before the value on
+// its first line ("", "  key:" or "  -"); indent is the nesting level of its own lines.
+func yamlValue(dec *json.Decoder, buf *bytes.Buffer, head string, indent int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	sep := ""
+	if head != "" {
+		sep = " "
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		isObject := t == '{'
+		if !dec.More() {
+			if isObject {
+				buf.WriteString(head + sep + "{}\n")
+			} else {
+				buf.WriteString(head + sep + "[]\n")
+			}
+			_, err := dec.Token() // closing delimiter
+			return err
+		}
+		pad := strings.Repeat("  ", indent)
+		inline := isObject && strings.HasSuffix(head, "-") // "- key: value" for objects in lists
+		if head != "" && !inline {
+			buf.WriteString(head + "\n")
+		}
+		for first := true; dec.More(); first = false {
+			childHead := pad + "-"
+			if isObject {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				childHead = pad + yamlString(key.(string)) + ":"
+				if inline && first {
+					childHead = head + " " + yamlString(key.(string)) + ":"
+				}
+			}
+			if err := yamlValue(dec, buf, childHead, indent+1); err != nil {
+				return err
+			}
+		}
+		_, err := dec.Token() // closing delimiter
+		return err
+	case string:
+		buf.WriteString(head + sep + yamlString(t) + "\n")
+	case json.Number:
+		buf.WriteString(head + sep + t.String() + "\n")
+	case bool:
+		buf.WriteString(head + sep + strconv.FormatBool(t) + "\n")
+	case nil:
+		buf.WriteString(head + sep + "null\n")
+	}
+	return nil
+}
+
+// yamlString returns s as a plain scalar when that is unambiguous, else double-quoted.
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "", "~", "null", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@` ") || strings.HasSuffix(s, " ") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return strconv.Quote(s)
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f || r > 0x7e {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
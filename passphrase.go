@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// passphraseFlags holds the CLI sources for one passphrase.
+// Sources are tried in order: --<prefix>passphrase-file, --<prefix>passphrase-fd,
+// the environment variable, then an interactive prompt on the controlling terminal.
+type passphraseFlags struct {
+	file *string
+	fd   *int
+}
+
+// addPassphraseFlags registers --<prefix>passphrase-file and --<prefix>passphrase-fd on fs.
+func addPassphraseFlags(fs *flag.FlagSet, prefix string, what string) *passphraseFlags {
+	return &passphraseFlags{
+		file: fs.String(prefix+"passphrase-file", "", "File containing the "+what+" passphrase"),
+		fd:   fs.Int(prefix+"passphrase-fd", -1, "File descriptor to read the "+what+" passphrase from"),
+	}
+}
+
+// source returns a PassphraseFunc that resolves the passphrase on first use and caches it,
+// so a prompt appears only if the key turns out to be encrypted.
+func (p *passphraseFlags) source(envVar string, prompt string) PassphraseFunc {
+	var cached []byte
+	return func() ([]byte, error) {
+		if cached != nil {
+			return cached, nil
+		}
+		pass, err := p.read(envVar, prompt, false)
+		if err != nil {
+			return nil, err
+		}
+		cached = pass
+		return pass, nil
+	}
+}
+
+// newPassphrase resolves a passphrase that is about to protect a key.
+// When prompting interactively it asks twice and requires both entries to match.
+func (p *passphraseFlags) newPassphrase(envVar string, prompt string) ([]byte, error) {
+	return p.read(envVar, prompt, true)
+}
+
+func (p *passphraseFlags) read(envVar string, prompt string, confirm bool) ([]byte, error) {
+	var pass []byte
+	switch {
+	case *p.file != "":
+		data, err := os.ReadFile(*p.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		pass = firstLine(data)
+	case *p.fd >= 0:
+		f := os.NewFile(uintptr(*p.fd), "passphrase-fd")
+		if f == nil {
+			return nil, fmt.Errorf("invalid passphrase file descriptor %d", *p.fd)
+		}
+		line, err := bufio.NewReader(f).ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return nil, fmt.Errorf("failed to read passphrase from fd %d: %w", *p.fd, err)
+		}
+		pass = firstLine(line)
+	case os.Getenv(envVar) != "":
+		pass = []byte(os.Getenv(envVar))
+	default:
+		var err error
+		pass, err = promptPassphrase(prompt)
+		if err != nil {
+			return nil, err
+		}
+		if confirm {
+			again, err := promptPassphrase("Confirm " + strings.ToLower(prompt[:1]) + prompt[1:])
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(pass, again) {
+				return nil, fmt.Errorf("passphrases do not match")
+			}
+		}
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	return pass, nil
+}
+
+// firstLine returns data up to the first newline, without the line terminator.
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	return bytes.TrimRight(data, "\r")
+}
+
+// promptPassphrase reads a passphrase from the controlling terminal with echo disabled.
+// Enforces CON-SC-001: passphrase never echoed or written to output
+func promptPassphrase(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no passphrase supplied and no terminal available to prompt for one")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	echoOff := exec.Command("stty", "-echo")
+	echoOff.Stdin = tty
+	if echoOff.Run() == nil {
+		defer func() {
+			echoOn := exec.Command("stty", "echo")
+			echoOn.Stdin = tty
+			echoOn.Run()
+			fmt.Fprintln(tty)
+		}()
+	}
+
+	line, err := bufio.NewReader(tty).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return firstLine(line), nil
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policy restricts the names and subject attributes the CA will certify. It is read
+// from policy.json in the data directory; without that file the CA signs any valid
+// CSR for any name (CON-INV-011).
+type Policy struct {
+	DNS     NameRules           `json:"dns"`
+	IP      NameRules           `json:"ip"`
+	Email   NameRules           `json:"email"`
+	URI     NameRules           `json:"uri"`
+	Subject map[string][]string `json:"subject,omitempty"` // attribute (CN, O, OU, L, ST, C) → permitted values
+
+	// KeyAlgorithms lists the CSR key types the CA accepts, by registry name
+	// ("ecdsa-p384", "rsa-3072", ...). Empty accepts every supported type.
+	KeyAlgorithms []string `json:"key_algorithms,omitempty"`
+}
+
+// NameRules permits and denies names of one type. A name must match no deny rule and,
+// when permit rules exist, at least one permit rule. Rule syntax per type:
+//
+//	dns:   "example.com" (the name and everything below it), ".example.com" (below only),
+//	       "*.example.com" (exactly one label below)
+//	ip:    "10.0.0.0/8" or a single address
+//	email: "user@example.com" (one mailbox), "example.com" (its mailboxes), ".example.com" (subdomains)
+//	uri:   host rules as for dns, without "*."
+type NameRules struct {
+	Permit []string `json:"permit,omitempty"`
+	Deny   []string `json:"deny,omitempty"`
+}
+
+// PolicyCheck is the outcome of checking one name or subject attribute against the policy.
+type PolicyCheck struct {
+	Name   string `json:"name"` // "key:ecdsa-p256", "dns:www.example.com", "subject:O=Example Corp"
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason"`
+}
+
+// policyNameTypes lists the name types in the order they are reported.
+var policyNameTypes = []string{"dns", "ip", "email", "uri"}
+
+// LoadPolicy reads policy.json from dataDir. It returns nil without error if the file
+// does not exist.
+func LoadPolicy(dataDir string) (*Policy, error) {
+	path := filepath.Join(dataDir, "policy.json")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return LoadPolicyFile(path)
+}
+
+// LoadPolicyFile reads and validates a policy file.
+func LoadPolicyFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	var p Policy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to parse %s: %v", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: invalid policy in %s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// marshalPolicy serializes a policy to indented JSON for policy.json.
+func marshalPolicy(p *Policy) ([]byte, error) {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal policy: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+func (p *Policy) rules(nameType string) NameRules {
+	switch nameType {
+	case "dns":
+		return p.DNS
+	case "ip":
+		return p.IP
+	case "email":
+		return p.Email
+	default:
+		return p.URI
+	}
+}
+
+// validate checks the syntax of every rule so that a typo fails loudly instead of
+// silently permitting or denying nothing.
+func (p *Policy) validate() error {
+	for _, t := range policyNameTypes {
+		r := p.rules(t)
+		for _, list := range [][]string{r.Permit, r.Deny} {
+			for _, rule := range list {
+				if err := validateNameRule(t, rule); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for attr, values := range p.Subject {
+		if !contains(subjectFields, attr) {
+			return fmt.Errorf("unknown subject attribute %q (valid: %s)", attr, strings.Join(subjectFields, ", "))
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("subject.%s must list at least one permitted value", attr)
+		}
+	}
+	for _, name := range p.KeyAlgorithms {
+		if _, ok := lookupKeyAlgorithm(name); !ok {
+			return fmt.Errorf("unknown key algorithm %q in key_algorithms (valid: %s)", name, KeyAlgorithmNames())
+		}
+	}
+	return nil
+}
+
+func validateNameRule(nameType, rule string) error {
+	bad := func(why string) error {
+		return fmt.Errorf("invalid %s rule %q: %s", nameType, rule, why)
+	}
+	if rule == "" {
+		return bad("empty")
+	}
+	switch nameType {
+	case "ip":
+		if _, err := parseIPRule(rule); err != nil {
+			return bad("not an IP address or CIDR range")
+		}
+	case "email":
+		if strings.Count(rule, "@") > 1 || strings.HasPrefix(rule, "@") || strings.HasSuffix(rule, "@") {
+			return bad("expected user@domain, domain or .domain")
+		}
+	default:
+		host := strings.TrimPrefix(rule, ".")
+		if nameType == "dns" {
+			host = strings.TrimPrefix(strings.TrimPrefix(rule, "*."), ".")
+		}
+		if host == "" || strings.ContainsAny(host, "*@/: ") {
+			return bad("expected a domain name")
+		}
+	}
+	return nil
+}
+
+// parseIPRule parses a CIDR range or a single address (as a /32 or /128 range).
+func parseIPRule(rule string) (*net.IPNet, error) {
+	if strings.Contains(rule, "/") {
+		_, n, err := net.ParseCIDR(rule)
+		return n, err
+	}
+	ip := net.ParseIP(rule)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", rule)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// matchDNSRule reports whether a DNS name, possibly a "*." wildcard, falls under rule.
+func matchDNSRule(rule, name string) bool {
+	rule = strings.ToLower(strings.TrimSuffix(rule, "."))
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	switch {
+	case strings.HasPrefix(rule, "*."):
+		label := strings.TrimSuffix(name, rule[1:])
+		return label != name && label != "" && !strings.Contains(label, ".")
+	case strings.HasPrefix(rule, "."):
+		return strings.HasSuffix(name, rule)
+	default:
+		return name == rule || strings.HasSuffix(name, "."+rule)
+	}
+}
+
+// denyDNSRule is matchDNSRule for deny rules: a wildcard name is also denied when
+// one of the names it covers is.
+func denyDNSRule(rule, name string) bool {
+	if matchDNSRule(rule, name) {
+		return true
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(rule, "*."), ".")
+	return strings.HasPrefix(name, "*.") && matchDNSRule(name, host)
+}
+
+func matchEmailRule(rule, addr string) bool {
+	rule = strings.ToLower(rule)
+	addr = strings.ToLower(addr)
+	if strings.Contains(rule, "@") {
+		return addr == rule
+	}
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return false
+	}
+	domain := addr[at+1:]
+	if strings.HasPrefix(rule, ".") {
+		return strings.HasSuffix(domain, rule)
+	}
+	return domain == rule
+}
+
+// matchName reports whether name of the given type falls under rule.
+func matchName(nameType, rule, name string, deny bool) bool {
+	switch nameType {
+	case "dns":
+		if deny {
+			return denyDNSRule(rule, name)
+		}
+		return matchDNSRule(rule, name)
+	case "ip":
+		n, err := parseIPRule(rule)
+		ip := net.ParseIP(name)
+		return err == nil && ip != nil && n.Contains(ip)
+	case "email":
+		return matchEmailRule(rule, name)
+	default:
+		host := name
+		if h, _, err := net.SplitHostPort(name); err == nil {
+			host = h
+		}
+		return net.ParseIP(host) == nil && matchDNSRule(rule, host)
+	}
+}
+
+// checkName checks one name against the rules for its type.
+func (p *Policy) checkName(nameType, name string) PolicyCheck {
+	c := PolicyCheck{Name: nameType + ":" + name}
+	r := p.rules(nameType)
+	for _, rule := range r.Deny {
+		if matchName(nameType, rule, name, true) {
+			c.Reason = fmt.Sprintf("denied by %s.deny %q", nameType, rule)
+			if nameType == "dns" && !matchDNSRule(rule, name) {
+				c.Reason = fmt.Sprintf("covers a name denied by %s.deny %q", nameType, rule)
+			}
+			return c
+		}
+	}
+	if len(r.Permit) == 0 {
+		c.Passed = true
+		c.Reason = fmt.Sprintf("no %s.permit rules", nameType)
+		return c
+	}
+	for _, rule := range r.Permit {
+		if matchName(nameType, rule, name, false) {
+			c.Passed = true
+			c.Reason = fmt.Sprintf("permitted by %s.permit %q", nameType, rule)
+			return c
+		}
+	}
+	c.Reason = fmt.Sprintf("not matched by any %s.permit rule", nameType)
+	return c
+}
+
+// requestNames returns the names of each type requested by a CSR or carried by a certificate.
+func requestNames(dnsNames []string, ips []net.IP, emails []string, uris []string) map[string][]string {
+	names := map[string][]string{"dns": dnsNames, "email": emails, "uri": uris}
+	for _, ip := range ips {
+		names["ip"] = append(names["ip"], ip.String())
+	}
+	return names
+}
+
+func uriHosts(uris []*url.URL) []string {
+	var hosts []string
+	for _, u := range uris {
+		hosts = append(hosts, u.Host)
+	}
+	return hosts
+}
+
+// commonNameAsName returns the type and value of a subject CN that names a host,
+// an IP address or a DNS name with at least two labels, as clients that fall back
+// to the CN would read it. ok is false for a CN such as a person's name.
+func commonNameAsName(cn string) (nameType, name string, ok bool) {
+	if ip := net.ParseIP(cn); ip != nil {
+		return "ip", ip.String(), true
+	}
+	name = strings.ToLower(strings.TrimSuffix(cn, "."))
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return "", "", false
+	}
+	for i, label := range labels {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", "", false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", "", false
+			}
+		}
+	}
+	return "dns", name, true
+}
+
+// Evaluate checks the key type, every subject attribute and every SAN of csr and
+// returns one result per check, in that order. A CN that names a host or an IP
+// address is checked as a dns or ip name too, unless a SAN already carries it.
+func (p *Policy) Evaluate(csr *x509.CertificateRequest) []PolicyCheck {
+	var checks []PolicyCheck
+
+	if len(p.KeyAlgorithms) > 0 {
+		c := PolicyCheck{Name: "key:unsupported", Reason: "not listed in key_algorithms"}
+		if a := keyAlgorithmOf(csr.PublicKey); a != nil {
+			c.Name = "key:" + a.Name
+			if contains(p.KeyAlgorithms, a.Name) {
+				c.Passed = true
+				c.Reason = "permitted by key_algorithms"
+			}
+		}
+		checks = append(checks, c)
+	}
+
+	values := map[string][]string{
+		"O":  csr.Subject.Organization,
+		"OU": csr.Subject.OrganizationalUnit,
+		"L":  csr.Subject.Locality,
+		"ST": csr.Subject.Province,
+		"C":  csr.Subject.Country,
+	}
+	if csr.Subject.CommonName != "" {
+		values["CN"] = []string{csr.Subject.CommonName}
+	}
+	for _, attr := range subjectFields {
+		permitted, ok := p.Subject[attr]
+		if !ok {
+			continue
+		}
+		for _, v := range values[attr] {
+			c := PolicyCheck{Name: "subject:" + attr + "=" + v}
+			if contains(permitted, v) {
+				c.Passed = true
+				c.Reason = "permitted by subject." + attr
+			} else {
+				c.Reason = fmt.Sprintf("not listed in subject.%s", attr)
+			}
+			checks = append(checks, c)
+		}
+	}
+
+	names := requestNames(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, uriHosts(csr.URIs))
+	if t, name, ok := commonNameAsName(csr.Subject.CommonName); ok && !containsFold(names[t], name) {
+		names[t] = append(names[t], name)
+	}
+	for _, t := range policyNameTypes {
+		for _, name := range names[t] {
+			checks = append(checks, p.checkName(t, name))
+		}
+	}
+	return checks
+}
+
+// containsFold reports whether list holds s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error naming the key type, subject attribute or SAN the policy rejects first.
+// Enforces CON-SC-003: CSR validation gate (issuance policy)
+func (p *Policy) check(csr *x509.CertificateRequest) error {
+	for _, c := range p.Evaluate(csr) {
+		if !c.Passed {
+			return newCAError(KindInvalidInput, "Error: CSR violates issuance policy: %s %s", c.Name, c.Reason)
+		}
+	}
+	return nil
+}
+
+// applyNameConstraints embeds the policy's name rules in a CA certificate template as a
+// critical NameConstraints extension (RFC 5280 §4.2.1.10). Subject rules and one-label
+// wildcard rules have no NameConstraints form and are rejected rather than dropped;
+// key_algorithms only governs this CA's own issuance and stays in policy.json.
+func (p *Policy) applyNameConstraints(template *x509.Certificate) error {
+	for _, list := range [][]string{p.DNS.Permit, p.DNS.Deny} {
+		for _, rule := range list {
+			if strings.HasPrefix(rule, "*.") {
+				return newCAError(KindInvalidInput, "Error: dns rule %q cannot be expressed as a name constraint; use %q or %q", rule, rule[2:], rule[1:])
+			}
+		}
+	}
+	if len(p.Subject) > 0 {
+		return newCAError(KindInvalidInput, "Error: subject rules cannot be expressed as name constraints; remove them or omit --name-constraints")
+	}
+
+	template.PermittedDNSDomains = p.DNS.Permit
+	template.ExcludedDNSDomains = p.DNS.Deny
+	template.PermittedEmailAddresses = p.Email.Permit
+	template.ExcludedEmailAddresses = p.Email.Deny
+	template.PermittedURIDomains = p.URI.Permit
+	template.ExcludedURIDomains = p.URI.Deny
+	for _, rule := range p.IP.Permit {
+		n, _ := parseIPRule(rule)
+		template.PermittedIPRanges = append(template.PermittedIPRanges, n)
+	}
+	for _, rule := range p.IP.Deny {
+		n, _ := parseIPRule(rule)
+		template.ExcludedIPRanges = append(template.ExcludedIPRanges, n)
+	}
+	if len(template.PermittedDNSDomains)+len(template.ExcludedDNSDomains)+len(template.PermittedEmailAddresses)+
+		len(template.ExcludedEmailAddresses)+len(template.PermittedURIDomains)+len(template.ExcludedURIDomains)+
+		len(template.PermittedIPRanges)+len(template.ExcludedIPRanges) == 0 {
+		return newCAError(KindInvalidInput, "Error: policy has no name rules to embed as name constraints")
+	}
+	template.PermittedDNSDomainsCritical = true // RFC 5280: conforming CAs MUST mark it critical
+	return nil
+}
+
+// policyFromNameConstraints converts a CA certificate's NameConstraints back into name rules.
+// It returns nil if the certificate carries none.
+func policyFromNameConstraints(ca *x509.Certificate) *Policy {
+	p := &Policy{
+		DNS:   NameRules{Permit: ca.PermittedDNSDomains, Deny: ca.ExcludedDNSDomains},
+		Email: NameRules{Permit: ca.PermittedEmailAddresses, Deny: ca.ExcludedEmailAddresses},
+		URI:   NameRules{Permit: ca.PermittedURIDomains, Deny: ca.ExcludedURIDomains},
+	}
+	for _, n := range ca.PermittedIPRanges {
+		p.IP.Permit = append(p.IP.Permit, n.String())
+	}
+	for _, n := range ca.ExcludedIPRanges {
+		p.IP.Deny = append(p.IP.Deny, n.String())
+	}
+	for _, t := range policyNameTypes {
+		if r := p.rules(t); len(r.Permit)+len(r.Deny) > 0 {
+			return p
+		}
+	}
+	return nil
+}
+
+// checkNameConstraints verifies that the SANs of every certificate below ca in the
+// chain satisfy ca's NameConstraints.
+func checkNameConstraints(ca *x509.Certificate, below []*x509.Certificate) error {
+	p := policyFromNameConstraints(ca)
+	if p == nil {
+		return nil
+	}
+	for _, cert := range below {
+		names := requestNames(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, uriHosts(cert.URIs))
+		for _, t := range policyNameTypes {
+			for _, name := range names[t] {
+				if c := p.checkName(t, name); !c.Passed {
+					return fmt.Errorf("x509: %s violates a name constraint of %s (%s)", c.Name, FormatDN(ca.Subject), c.Reason)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// PolicyTestResult is the outcome of a "ca policy test" dry run.
+type PolicyTestResult struct {
+	PolicyPath string        `json:"policy_path"` // empty when no policy is configured
+	Allowed    bool          `json:"allowed"`
+	Checks     []PolicyCheck `json:"checks"`
+}
+
+// TestPolicy evaluates a CSR against the policy at policyPath, or against the data
+// directory's policy.json when policyPath is empty, without issuing anything.
+func TestPolicy(dataDir string, policyPath string, csrPEM []byte, csrPath string) (*PolicyTestResult, error) {
+	var policy *Policy
+	var err error
+	if policyPath != "" {
+		policy, err = LoadPolicyFile(policyPath)
+	} else {
+		if !IsInitialized(dataDir) {
+			return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+		}
+		policyPath = filepath.Join(dataDir, "policy.json")
+		policy, err = LoadPolicy(dataDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The same parse and self-signature check as ca sign (CON-SC-003 check 1)
+	csr, err := parseCSR(csrPEM, csrPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PolicyTestResult{Allowed: true}
+	if policy == nil {
+		return result, nil
+	}
+	result.PolicyPath = policyPath
+	result.Checks = policy.Evaluate(csr)
+	for _, c := range result.Checks {
+		if !c.Passed {
+			result.Allowed = false
+		}
+	}
+	return result, nil
+}
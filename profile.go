@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultProfileName is the profile used when "ca sign" is given no --profile.
+const DefaultProfileName = "default"
+
+// defaultValidityDays is the validity of a signed certificate when none is requested,
+// lowered to the profile's maximum if that is shorter.
+const defaultValidityDays = 365
+
+// Profile shapes and constrains the end-entity certificates issued under its name.
+// Profiles are read from profiles.json in the data directory.
+type Profile struct {
+	Description           string   `json:"description,omitempty"`
+	KeyUsage              []string `json:"key_usage"`                         // keyEncipherment applies to RSA keys only
+	ExtKeyUsage           []string `json:"ext_key_usage,omitempty"`           // omitted: no extendedKeyUsage extension
+	MaxValidityDays       int      `json:"max_validity_days,omitempty"`       // 0: no limit
+	AllowedSANTypes       []string `json:"allowed_san_types"`                 // dns, ip, email, uri
+	RequireSAN            bool     `json:"require_san,omitempty"`             // at least one allowed SAN
+	RequiredSubjectFields []string `json:"required_subject_fields,omitempty"` // CN, O, OU, L, ST, C
+	OCSPNoCheck           bool     `json:"ocsp_no_check,omitempty"`           // id-pkix-ocsp-nocheck (RFC 6960 §4.2.2.2.1)
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+var sanTypes = []string{"dns", "ip", "email", "uri"}
+
+var subjectFields = []string{"CN", "O", "OU", "L", "ST", "C"}
+
+// oidOCSPNoCheck is id-pkix-ocsp-nocheck (RFC 6960 §4.2.2.2.1).
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// builtinProfiles returns the profiles written to profiles.json at init, and used
+// unchanged by data directories created before profiles existed.
+// "default" reproduces the certificate shape issued before profiles were introduced.
+func builtinProfiles() map[string]*Profile {
+	return map[string]*Profile{
+		DefaultProfileName: {
+			Description:     "General-purpose end-entity certificate with no extended key usage",
+			KeyUsage:        []string{"digitalSignature", "keyEncipherment"},
+			AllowedSANTypes: []string{"dns", "ip", "email", "uri"},
+		},
+		"tls-server": {
+			Description:     "TLS server (web server, API endpoint)",
+			KeyUsage:        []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage:     []string{"serverAuth"},
+			MaxValidityDays: 397,
+			AllowedSANTypes: []string{"dns", "ip"},
+			RequireSAN:      true, // names live in the SAN; CN is optional (RFC 6125)
+		},
+		"tls-client": {
+			Description:           "TLS client authentication (mTLS user or service)",
+			KeyUsage:              []string{"digitalSignature"},
+			ExtKeyUsage:           []string{"clientAuth"},
+			MaxValidityDays:       397,
+			AllowedSANTypes:       []string{"dns", "email", "uri"},
+			RequiredSubjectFields: []string{"CN"},
+		},
+		"code-signing": {
+			Description:           "Code and artifact signing",
+			KeyUsage:              []string{"digitalSignature"},
+			ExtKeyUsage:           []string{"codeSigning"},
+			MaxValidityDays:       1095,
+			AllowedSANTypes:       []string{},
+			RequiredSubjectFields: []string{"CN", "O"},
+		},
+		"smime": {
+			Description:           "S/MIME email signing and encryption",
+			KeyUsage:              []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage:           []string{"emailProtection"},
+			MaxValidityDays:       825,
+			AllowedSANTypes:       []string{"email"},
+			RequireSAN:            true,
+			RequiredSubjectFields: []string{"CN"},
+		},
+		"ocsp-signing": {
+			Description:           "Delegated OCSP responder (ca ocsp serve --responder-cert)",
+			KeyUsage:              []string{"digitalSignature"},
+			ExtKeyUsage:           []string{"OCSPSigning"},
+			MaxValidityDays:       90,
+			AllowedSANTypes:       []string{},
+			RequiredSubjectFields: []string{"CN"},
+			OCSPNoCheck:           true,
+		},
+	}
+}
+
+// marshalProfiles serializes profiles to indented JSON for profiles.json.
+func marshalProfiles(profiles map[string]*Profile) ([]byte, error) {
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal profiles: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// LoadProfiles reads profiles.json from dataDir, or returns the built-in profiles
+// if the file does not exist. Unknown fields are rejected, and every profile is
+// checked for unknown names.
+func LoadProfiles(dataDir string) (map[string]*Profile, error) {
+	path := filepath.Join(dataDir, "profiles.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return builtinProfiles(), nil
+		}
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+
+	var profiles map[string]*Profile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&profiles); err != nil {
+		return nil, newCAError(KindInvalidInput, "Error: failed to parse %s: %v", path, err)
+	}
+	for name, p := range profiles {
+		if err := p.validate(); err != nil {
+			return nil, newCAError(KindInvalidInput, "Error: invalid profile %q in %s: %v", name, path, err)
+		}
+	}
+	return profiles, nil
+}
+
+// lookupProfile returns the named profile, listing the available names if it does not exist.
+func lookupProfile(profiles map[string]*Profile, name string) (*Profile, error) {
+	if p, ok := profiles[name]; ok {
+		return p, nil
+	}
+	names := make([]string, 0, len(profiles))
+	for n := range profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, newCAError(KindInvalidInput, "Error: unknown profile %q. Available: %s", name, strings.Join(names, ", "))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that a profile only names known usages, SAN types and subject fields.
+func (p *Profile) validate() error {
+	if p == nil {
+		return fmt.Errorf("profile is empty")
+	}
+	if len(p.KeyUsage) == 0 {
+		return fmt.Errorf("key_usage must name at least one usage")
+	}
+	for _, ku := range p.KeyUsage {
+		if _, ok := keyUsageNames[ku]; !ok {
+			return fmt.Errorf("unknown key usage %q", ku)
+		}
+	}
+	for _, eku := range p.ExtKeyUsage {
+		if _, ok := extKeyUsageNames[eku]; !ok {
+			return fmt.Errorf("unknown extended key usage %q", eku)
+		}
+	}
+	for _, t := range p.AllowedSANTypes {
+		if !contains(sanTypes, t) {
+			return fmt.Errorf("unknown SAN type %q (valid: %s)", t, strings.Join(sanTypes, ", "))
+		}
+	}
+	for _, f := range p.RequiredSubjectFields {
+		if !contains(subjectFields, f) {
+			return fmt.Errorf("unknown subject field %q (valid: %s)", f, strings.Join(subjectFields, ", "))
+		}
+	}
+	if p.MaxValidityDays < 0 {
+		return fmt.Errorf("max_validity_days cannot be negative")
+	}
+	return nil
+}
+
+// validityDays resolves the requested validity against the profile: 0 selects the
+// default (365 days, or the profile maximum if lower); anything longer than the maximum is rejected.
+func (p *Profile) validityDays(name string, requested int) (int, error) {
+	if requested == 0 {
+		requested = defaultValidityDays
+		if p.MaxValidityDays > 0 && p.MaxValidityDays < requested {
+			requested = p.MaxValidityDays
+		}
+		return requested, nil
+	}
+	if p.MaxValidityDays > 0 && requested > p.MaxValidityDays {
+		return 0, newCAError(KindInvalidInput, "Error: validity of %d days exceeds the maximum of %d days for profile %q", requested, p.MaxValidityDays, name)
+	}
+	return requested, nil
+}
+
+// checkCSR rejects a CSR whose subject or SANs the profile does not permit.
+// Enforces CON-SC-003: CSR validation gate (profile constraints)
+func (p *Profile) checkCSR(name string, csr *x509.CertificateRequest) error {
+	violation := func(format string, args ...interface{}) error {
+		return newCAError(KindInvalidInput, "Error: CSR violates profile %q: %s", name, fmt.Sprintf(format, args...))
+	}
+
+	present := map[string]bool{
+		"CN": csr.Subject.CommonName != "",
+		"O":  len(csr.Subject.Organization) > 0,
+		"OU": len(csr.Subject.OrganizationalUnit) > 0,
+		"L":  len(csr.Subject.Locality) > 0,
+		"ST": len(csr.Subject.Province) > 0,
+		"C":  len(csr.Subject.Country) > 0,
+	}
+	for _, f := range p.RequiredSubjectFields {
+		if !present[f] {
+			return violation("subject must include %s", f)
+		}
+	}
+
+	counts := map[string]int{
+		"dns":   len(csr.DNSNames),
+		"ip":    len(csr.IPAddresses),
+		"email": len(csr.EmailAddresses),
+		"uri":   len(csr.URIs),
+	}
+	total := 0
+	for _, t := range sanTypes {
+		if counts[t] > 0 && !contains(p.AllowedSANTypes, t) {
+			if len(p.AllowedSANTypes) == 0 {
+				return violation("subject alternative names are not allowed (found %s)", t)
+			}
+			return violation("%s subject alternative names are not allowed (allowed: %s)", t, strings.Join(p.AllowedSANTypes, ", "))
+		}
+		total += counts[t]
+	}
+	if p.RequireSAN && total == 0 {
+		return violation("at least one subject alternative name (%s) is required", strings.Join(p.AllowedSANTypes, ", "))
+	}
+	return nil
+}
+
+// apply sets the profile's key usages, extended key usages, SANs and extensions on template.
+// Enforces CON-DI-012: end-entity certificate extensions
+func (p *Profile) apply(template *x509.Certificate, csr *x509.CertificateRequest) {
+	_, isRSA := csr.PublicKey.(*rsa.PublicKey)
+	var ku x509.KeyUsage
+	for _, name := range p.KeyUsage {
+		if name == "keyEncipherment" && !isRSA {
+			continue // only meaningful for RSA key transport
+		}
+		ku |= keyUsageNames[name]
+	}
+	template.KeyUsage = ku
+
+	for _, name := range p.ExtKeyUsage {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, extKeyUsageNames[name])
+	}
+
+	template.DNSNames = csr.DNSNames
+	template.IPAddresses = csr.IPAddresses
+	template.EmailAddresses = csr.EmailAddresses
+	template.URIs = csr.URIs
+
+	if p.OCSPNoCheck {
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:    oidOCSPNoCheck,
+			Value: asn1.NullBytes,
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+)
+
+// loadPredecessor returns the index entry and certificate a renewal or rekey replaces.
+// CA certificates and certificates that were already replaced are refused.
+func loadPredecessor(dataDir string, serialHex string) (*IndexEntry, *x509.Certificate, error) {
+	if !IsInitialized(dataDir) {
+		return nil, nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	entry, err := store.Get(serialHex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	if entry == nil {
+		return nil, nil, newCAError(KindNotFound, "Error: certificate with serial %s not found", serialHex) // REQ-ER-003
+	}
+	if entry.IsCA {
+		return nil, nil, newCAError(KindInvalidInput, "Error: certificate with serial %s is a CA certificate; create a new intermediate with 'ca init --parent'", serialHex)
+	}
+	if entry.ReplacedBy != "" {
+		return nil, nil, newCAError(KindConflict, "Error: certificate with serial %s was already replaced by %s", serialHex, entry.ReplacedBy)
+	}
+
+	cert, err := LoadCertificate(filepath.Join(dataDir, "certs", serialHex+".pem"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load certificate %s: %w", serialHex, err)
+	}
+	return entry, cert, nil
+}
+
+// predecessorProfile is the profile a replacement is issued under: the predecessor's,
+// or the default for certificates issued before profiles existed.
+func predecessorProfile(entry *IndexEntry) string {
+	if entry.Profile == "" {
+		return DefaultProfileName
+	}
+	return entry.Profile
+}
+
+// RenewCert reissues certificate serialHex with the same subject, SANs, public key and
+// profile and a fresh validity window. The new certificate passes through the same
+// profile and policy checks as a CSR would; revoked certificates cannot be renewed.
+// Enforces CON-INV-003: a revoked certificate is never brought back into use
+// Enforces CON-DI-004: validate-before-mutate + atomic writes (ADR-003, ADR-006)
+func RenewCert(dataDir string, serialHex string, validityDays int, revokeOld bool, passphrase PassphraseFunc) (result *SignResult, err error) {
+	audit := startAudit(dataDir, "renew")
+	audit.rec.Detail = "replaces " + serialHex
+	defer func() { audit.finish(err) }()
+
+	// VALIDATE PHASE (ADR-003)
+	entry, cert, err := loadPredecessor(dataDir, serialHex)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Status == "revoked" {
+		return nil, newCAError(KindConflict, "Error: certificate with serial %s is revoked; issue a certificate for a new key with 'ca rekey'", serialHex)
+	}
+
+	// The issued certificate stands in for the CSR: its key was proven when it was first signed.
+	csr := &x509.CertificateRequest{
+		Subject:        cert.Subject,
+		PublicKey:      cert.PublicKey,
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           cert.URIs,
+	}
+	return issueCertificate(dataDir, csr, predecessorProfile(entry), validityDays, passphrase, &replacement{serialHex, revokeOld}, audit)
+}
+
+// RekeyCert issues a certificate for the new key in csrPEM to replace certificate serialHex.
+// The CSR must carry the predecessor's subject and a different public key; its SANs are
+// taken from the CSR. Revoked certificates may be rekeyed (the usual response to keyCompromise).
+// Enforces CON-SC-003: CSR validation gate
+// Enforces CON-DI-004: validate-before-mutate + atomic writes (ADR-003, ADR-006)
+func RekeyCert(dataDir string, serialHex string, csrPEM []byte, csrPath string, validityDays int, revokeOld bool, passphrase PassphraseFunc) (result *SignResult, err error) {
+	audit := startAudit(dataDir, "rekey")
+	audit.rec.Detail = "replaces " + serialHex
+	defer func() { audit.finish(err) }()
+
+	// VALIDATE PHASE (ADR-003)
+	entry, cert, err := loadPredecessor(dataDir, serialHex)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := parseCSR(csrPEM, csrPath)
+	if err != nil {
+		return nil, err
+	}
+	audit.setCSR(csr)
+
+	if FormatDN(csr.Subject) != FormatDN(cert.Subject) {
+		return nil, newCAError(KindInvalidInput, "Error: CSR subject %s does not match certificate %s subject %s", FormatDN(csr.Subject), serialHex, FormatDN(cert.Subject))
+	}
+
+	oldKey, err := publicKeyBytes(cert.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate public key: %w", err)
+	}
+	newKey, err := publicKeyBytes(csr.PublicKey)
+	if err != nil {
+		return nil, errUnsupportedCSRKey()
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil, newCAError(KindInvalidInput, "Error: CSR uses the same key as certificate %s; use 'ca renew' to reissue it", serialHex)
+	}
+
+	return issueCertificate(dataDir, csr, predecessorProfile(entry), validityDays, passphrase, &replacement{serialHex, revokeOld}, audit)
+}
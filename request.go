@@ -12,10 +12,10 @@ import (
 
 // RequestResult contains the results of CSR generation.
 type RequestResult struct {
-	Subject   string
-	Algorithm string
-	KeyPath   string
-	CSRPath   string
+	Subject   string `json:"subject"`
+	Algorithm string `json:"algorithm"`
+	KeyPath   string `json:"key_path"`
+	CSRPath   string `json:"csr_path"`
 }
 
 // GenerateCSR generates a key pair and PKCS#10 CSR for the ca request utility.
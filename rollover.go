@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retired CA generations live in generations/NN/ with the files they had at the top
+// of the data directory (ca.key, ca.crt, ca.crl), plus the cross certificates that
+// link them to their successor (RFC 4210 §4.4).
+const (
+	generationsDir = "generations"
+	newWithOldFile = "new-with-old.crt" // the successor's key, certified by this generation's key
+	oldWithNewFile = "old-with-new.crt" // this generation's key, certified by the successor's key
+)
+
+// CAGeneration is one key of a root CA. ca rollover retires the current key to
+// generations/NN/, where it goes on signing CRLs for the certificates it issued.
+type CAGeneration struct {
+	Number  int
+	Dir     string // holds this generation's ca.crt, ca.key and ca.crl
+	Cert    *x509.Certificate
+	Current bool // the generation in ca.crt that issues new certificates
+}
+
+// generationDir returns the directory of retired generation n.
+func generationDir(dataDir string, n int) string {
+	return filepath.Join(dataDir, generationsDir, fmt.Sprintf("%02d", n))
+}
+
+// crlPath returns where the latest full CRL of the generation is published.
+func (g *CAGeneration) crlPath() string {
+	return filepath.Join(g.Dir, "ca.crl")
+}
+
+// loadSigner opens the generation's key. The current key goes through the signer
+// provider; a retired key is always a ca.key file, protected like the current one.
+func (g *CAGeneration) loadSigner(config *CAConfig, passphrase PassphraseFunc) (crypto.Signer, error) {
+	if g.Current {
+		return LoadCASigner(g.Dir, config, passphrase)
+	}
+	key, err := LoadPrivateKey(filepath.Join(g.Dir, "ca.key"), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("generation %d key cannot sign", g.Number)
+	}
+	want, err := publicKeyBytes(g.Cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	got, err := publicKeyBytes(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(want, got) {
+		return nil, newCAError(KindConflict, "Error: %s does not hold the key of generation %d", filepath.Join(g.Dir, "ca.key"), g.Number)
+	}
+	return signer, nil
+}
+
+// retiredGenerationNumbers lists the numbered directories in generations/, in order.
+func retiredGenerationNumbers(dataDir string) ([]int, error) {
+	dirs, err := os.ReadDir(filepath.Join(dataDir, generationsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, d := range dirs {
+		if n, err := strconv.Atoi(d.Name()); err == nil && n > 0 && d.IsDir() {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// LoadGenerations returns the key generations of the CA in dataDir, oldest first and
+// the current one last. A CA that was never rolled over has one generation.
+// A last directory without its ca.crt, or holding the current ca.crt, is a rollover
+// that never reached its commit point; it is skipped here and repaired by ca fsck.
+func LoadGenerations(dataDir string) ([]*CAGeneration, error) {
+	caCert, err := LoadCertificate(filepath.Join(dataDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	numbers, err := retiredGenerationNumbers(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", generationsDir, err)
+	}
+	var gens []*CAGeneration
+	for i, n := range numbers {
+		if n != i+1 {
+			return nil, fmt.Errorf("%s: generation %02d is missing", filepath.Join(dataDir, generationsDir), i+1)
+		}
+		dir := generationDir(dataDir, n)
+		cert, err := LoadCertificate(filepath.Join(dir, "ca.crt"))
+		last := i == len(numbers)-1
+		if last && (errors.Is(err, os.ErrNotExist) || err == nil && cert.Equal(caCert)) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load generation %d CA certificate: %w", n, err)
+		}
+		gens = append(gens, &CAGeneration{Number: n, Dir: dir, Cert: cert})
+	}
+	return append(gens, &CAGeneration{Number: len(gens) + 1, Dir: dataDir, Cert: caCert, Current: true}), nil
+}
+
+// interruptedRollover returns the directory of a rollover that did not reach its
+// commit point, or "" if there is none.
+func interruptedRollover(dataDir string, gens []*CAGeneration) string {
+	numbers, err := retiredGenerationNumbers(dataDir)
+	if err != nil || len(numbers) < len(gens) {
+		return ""
+	}
+	return generationDir(dataDir, numbers[len(numbers)-1])
+}
+
+// entryGeneration returns the generation that issued an index entry. Entries from
+// before the first rollover carry no generation and belong to the first.
+func entryGeneration(e IndexEntry) int {
+	if e.Generation == 0 {
+		return 1
+	}
+	return e.Generation
+}
+
+// generationOf returns the generation whose key signed cert, or nil if none did.
+func generationOf(gens []*CAGeneration, cert *x509.Certificate) *CAGeneration {
+	for i := len(gens) - 1; i >= 0; i-- {
+		if bytes.Equal(cert.RawIssuer, gens[i].Cert.RawSubject) && cert.CheckSignatureFrom(gens[i].Cert) == nil {
+			return gens[i]
+		}
+	}
+	return nil
+}
+
+// crlGeneration returns the generation whose key signed crl, or nil if none did.
+func crlGeneration(gens []*CAGeneration, crl *x509.RevocationList) *CAGeneration {
+	for i := len(gens) - 1; i >= 0; i-- {
+		if crl.CheckSignatureFrom(gens[i].Cert) == nil {
+			return gens[i]
+		}
+	}
+	return nil
+}
+
+// currentGeneration returns the number of the generation that issues new certificates.
+func currentGeneration(dataDir string) (int, error) {
+	gens, err := LoadGenerations(dataDir)
+	if err != nil {
+		return 0, err
+	}
+	return len(gens), nil
+}
+
+// checkIssuingCA re-reads ca.crt under the data directory lock and checks that it is
+// still caCert and that key is its key. Issuance loads both before taking the lock,
+// and ca rollover renames the new ca.key before the new ca.crt, so without this a
+// certificate could be signed by one generation and recorded under another.
+func checkIssuingCA(dataDir string, caCert *x509.Certificate, key crypto.Signer) error {
+	current, err := LoadCertificate(filepath.Join(dataDir, "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	want, err := publicKeyBytes(caCert.PublicKey)
+	if err != nil {
+		return err
+	}
+	got, err := publicKeyBytes(key.Public())
+	if err != nil {
+		return err
+	}
+	if !current.Equal(caCert) {
+		return newCAError(KindConflict, "Error: the CA was rolled over during issuance; run it again")
+	}
+	if !bytes.Equal(want, got) {
+		return newCAError(KindConflict, "Error: the CA key does not match the CA certificate %s; run 'ca fsck' if no rollover is in progress", FormatDN(caCert.Subject))
+	}
+	return nil
+}
+
+// issuedGeneration is the generation field of a new index entry: none while the CA
+// has only ever had one key, so the index keeps its pre-rollover layout.
+func issuedGeneration(n int) int {
+	if n == 1 {
+		return 0
+	}
+	return n
+}
+
+// RolloverResult describes the generation created by "ca rollover".
+type RolloverResult struct {
+	Generation int       `json:"generation"`
+	Subject    string    `json:"subject"`
+	Algorithm  string    `json:"algorithm"`
+	Serial     string    `json:"serial"`
+	NotAfter   time.Time `json:"not_after"`
+	CertPath   string    `json:"cert_path"`
+	KeyPath    string    `json:"key_path"`
+	Encrypted  bool      `json:"key_encrypted"`
+
+	PreviousDir      string    `json:"previous_dir"`       // where the retired generation now lives
+	PreviousNotAfter time.Time `json:"previous_not_after"` // it signs CRLs until then
+	NewWithOldPath   string    `json:"new_with_old_path"`  // the new key certified by the old one
+	OldWithNewPath   string    `json:"old_with_new_path"`  // the old key certified by the new one
+}
+
+// RolloverCA replaces the key of a root CA with a new generation: a fresh key and
+// self-signed certificate become ca.key and ca.crt, and the old ones move to
+// generations/NN/ together with its published CRLs. Two cross certificates link the
+// generations, so relying parties that trust either root can validate chains to the
+// other: new-with-old certifies the new key with the old one, old-with-new the old key
+// with the new one. Certificates issued from now on come from the new generation; the
+// old one keeps signing CRLs for the certificates it issued until it expires.
+// A nil subject keeps the old root's subject, an empty keyAlgo its key algorithm. The
+// new key is stored like the old one: encrypted under the same passphrase if the old
+// one was. Only a root CA whose key is in ca.key can be rolled over.
+// Enforces CON-INV-001: serial number uniqueness (the new root and cross certificates draw from the counter)
+// Enforces CON-INV-004: CA initialization prerequisite
+// Enforces CON-SC-002: cryptographically secure key generation via crypto/rand
+// Enforces CON-DI-004: validate-before-mutate + atomic writes (ADR-003, ADR-006)
+// Enforces CON-DI-011: root CA certificate extensions
+func RolloverCA(dataDir string, subject *pkix.Name, keyAlgo string, validityDays int, passphrase PassphraseFunc) (result *RolloverResult, err error) {
+	audit := startAudit(dataDir, "rollover")
+	var crossAudits []*auditOp
+	defer func() {
+		audit.finish(err)
+		if err == nil {
+			for _, op := range crossAudits {
+				op.finish(nil)
+			}
+		}
+	}()
+
+	// VALIDATE PHASE (ADR-003)
+	if !IsInitialized(dataDir) {
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+	}
+	if validityDays <= 0 {
+		return nil, newCAError(KindInvalidInput, "Error: validity must be a positive number of days")
+	}
+	config, err := LoadConfig(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if p := config.signerProvider(); p != signerFile {
+		return nil, newCAError(KindConflict, "Error: the CA key is held by the %s signer; ca rollover needs it in ca.key", p)
+	}
+	certPath := filepath.Join(dataDir, "ca.crt")
+	keyPath := filepath.Join(dataDir, "ca.key")
+	oldCert, err := LoadCertificate(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	chain, err := LoadChain(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA chain: %w", err)
+	}
+	if len(chain) > 0 || !bytes.Equal(oldCert.RawSubject, oldCert.RawIssuer) {
+		return nil, newCAError(KindConflict, "Error: %s is an intermediate CA; issue a new one from its parent with 'ca init --parent'", FormatDN(oldCert.Subject))
+	}
+	if now := time.Now(); now.After(oldCert.NotAfter) {
+		return nil, newCAError(KindConflict, "Error: the CA certificate expired at %s; only a valid root can certify its successor", oldCert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if keyAlgo == "" {
+		if a := keyAlgorithmOf(oldCert.PublicKey); a != nil {
+			keyAlgo = a.Name
+		}
+	}
+	alg, ok := lookupKeyAlgorithm(keyAlgo)
+	if !ok {
+		return nil, newCAError(KindInvalidInput, "Error: invalid key algorithm %q. Must be one of: %s", keyAlgo, KeyAlgorithmNames())
+	}
+	if config.RSAPSS && !strings.HasPrefix(alg.Name, "rsa-") {
+		return nil, newCAError(KindInvalidInput, "Error: config sets %s, so the new key must be an RSA key", configKeyRSAPSS)
+	}
+
+	oldKey, err := LoadCASigner(dataDir, config, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key: %w", err)
+	}
+	encrypted, err := IsKeyEncrypted(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	var newPassphrase []byte
+	if encrypted {
+		if newPassphrase, err = passphrase(); err != nil { // cached: the one that opened the old key
+			return nil, err
+		}
+	}
+
+	// MUTATE PHASE
+	// Generate the new key using CSPRNG (CON-SC-002)
+	newKey, err := generateKeyPair(alg.Name)
+	if err != nil {
+		return nil, err
+	}
+	newPub := publicKey(newKey)
+	keyPEM, err := marshalCAKey(newKey, newPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	ski, err := computeSKI(newPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute subject key identifier: %w", err)
+	}
+
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Re-check under the lock: a concurrent rollover may have finished meanwhile
+	if current, err := LoadCertificate(certPath); err != nil || !current.Equal(oldCert) {
+		return nil, newCAError(KindConflict, "Error: the CA certificate changed during the rollover; run it again")
+	}
+	gens, err := LoadGenerations(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if dir := interruptedRollover(dataDir, gens); dir != "" {
+		return nil, newCAError(KindConflict, "Error: %s is left over from an interrupted rollover. Run 'ca fsck --repair' first.", dir)
+	}
+	prevDir := generationDir(dataDir, len(gens))
+
+	store, err := OpenIndex(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+	// The new root and both cross certificates (CON-INV-001)
+	serials, newSerialData, err := nextSerials(dataDir, config, store, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second) // CON-DI-014: system clock; certificates carry whole seconds
+	notAfter := now.Add(time.Duration(validityDays) * 24 * time.Hour)
+
+	// The new self-signed root (CON-DI-011)
+	root := &x509.Certificate{
+		SerialNumber:          serials[0],
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          ski,
+		SignatureAlgorithm:    sigAlgorithm(newKey, config.RSAPSS), // CON-INV-008: hash matched to the key
+	}
+	if subject != nil {
+		root.Subject = *subject
+	} else {
+		root.RawSubject = oldCert.RawSubject // byte for byte, so names chain across generations
+	}
+	copyNameConstraints(root, oldCert)
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, newPub, newKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	newCert, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		return nil, err
+	}
+
+	// new-with-old: the new key under the old root, for relying parties that only trust the old one
+	newWithOld := *root
+	newWithOld.RawSubject = newCert.RawSubject
+	newWithOld.SerialNumber = serials[1]
+	if oldCert.NotAfter.Before(notAfter) {
+		newWithOld.NotAfter = oldCert.NotAfter
+	}
+	newWithOld.AuthorityKeyId = oldCert.SubjectKeyId
+	newWithOld.SignatureAlgorithm = sigAlgorithm(oldKey, config.RSAPSS)
+	newWithOldDER, err := x509.CreateCertificate(rand.Reader, &newWithOld, oldCert, newPub, oldKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cross certificate: %w", err)
+	}
+
+	// old-with-new: the old key under the new root, for certificates the old one issued
+	oldWithNew := &x509.Certificate{
+		SerialNumber:          serials[2],
+		RawSubject:            oldCert.RawSubject,
+		NotBefore:             now,
+		NotAfter:              oldCert.NotAfter,
+		KeyUsage:              oldCert.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            oldCert.MaxPathLen,
+		MaxPathLenZero:        oldCert.MaxPathLenZero,
+		SubjectKeyId:          oldCert.SubjectKeyId,
+		AuthorityKeyId:        ski,
+		SignatureAlgorithm:    sigAlgorithm(newKey, config.RSAPSS),
+	}
+	copyNameConstraints(oldWithNew, oldCert)
+	oldWithNewDER, err := x509.CreateCertificate(rand.Reader, oldWithNew, newCert, oldCert.PublicKey, newKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cross certificate: %w", err)
+	}
+
+	// All three are recorded like an intermediate, so their serials are never drawn again (CON-INV-001)
+	var entries []IndexEntry
+	for _, issued := range []struct {
+		der        []byte
+		generation int
+	}{{rootDER, len(gens) + 1}, {newWithOldDER, len(gens)}, {oldWithNewDER, len(gens) + 1}} {
+		cert, err := x509.ParseCertificate(issued.der)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, IndexEntry{
+			Serial:     FormatSerialBig(cert.SerialNumber),
+			Subject:    FormatDN(cert.Subject),
+			NotBefore:  cert.NotBefore.UTC().Format(time.RFC3339), // CON-DI-003
+			NotAfter:   cert.NotAfter.UTC().Format(time.RFC3339),  // CON-DI-003
+			Status:     "active",
+			IsCA:       true,
+			Generation: issuedGeneration(issued.generation),
+		})
+	}
+
+	// The retired generation takes its key, certificate and published CRLs along
+	oldKeyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+	certPEM := func(der []byte) []byte { return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}) }
+	var files []stagedFile
+	files = append(files,
+		stagedFile{filepath.Join(prevDir, "ca.crt"), certPEM(oldCert.Raw), 0644},
+		stagedFile{filepath.Join(prevDir, "ca.key"), oldKeyPEM, 0600},
+	)
+	var published []string
+	for _, name := range []string{"ca.crl", "ca-delta.crl"} {
+		data, err := os.ReadFile(filepath.Join(dataDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		files = append(files, stagedFile{filepath.Join(prevDir, name), data, 0644})
+		published = append(published, filepath.Join(dataDir, name))
+	}
+	files = append(files,
+		stagedFile{filepath.Join(prevDir, newWithOldFile), certPEM(newWithOldDER), 0644},
+		stagedFile{filepath.Join(prevDir, oldWithNewFile), certPEM(oldWithNewDER), 0644},
+	)
+	if newSerialData != nil {
+		files = append(files, stagedFile{filepath.Join(dataDir, "serial"), newSerialData, 0644})
+	}
+	for i, der := range [][]byte{rootDER, newWithOldDER, oldWithNewDER} {
+		files = append(files, stagedFile{filepath.Join(dataDir, "certs", entries[i].Serial+".pem"), certPEM(der), 0644})
+	}
+	files = append(files,
+		stagedFile{keyPath, keyPEM, 0600},
+		stagedFile{certPath, certPEM(rootDER), 0644}, // Commit point: the new generation is current
+	)
+
+	// STAGE + COMMIT (ADR-006): rename in order: the retired generation, serial, the three
+	// certificates in certs/, ca.key, ca.crt; then commit their index entries. A crash before
+	// the index commit leaves certificates that ca fsck --repair indexes from certs/.
+	if err := os.MkdirAll(prevDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", prevDir, err)
+	}
+	if err := stageAndCommit(files); err != nil {
+		os.Remove(prevDir) // only if nothing was committed into it
+		return nil, err
+	}
+	if err := store.Commit(entries...); err != nil {
+		return nil, err
+	}
+	// The published CRLs were the old generation's; the next ca crl publishes the new one's
+	for _, path := range published {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	oldSKI, err := computeSKI(oldCert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	audit.rec.Subject = FormatDN(newCert.Subject)
+	audit.rec.Serial = FormatSerialBig(serials[0])
+	audit.rec.Detail = fmt.Sprintf("generation %d, retires key %s", len(gens)+1, hex.EncodeToString(oldSKI))
+	audit.signWith(newKey)
+	audit.checkpoint = true
+	// The cross certificates get records of their own, so the log names every serial issued
+	for i, detail := range []string{"new-with-old cross certificate", "old-with-new cross certificate"} {
+		op := startAudit(dataDir, "rollover")
+		op.rec.Serial = entries[i+1].Serial
+		op.rec.Subject = entries[i+1].Subject
+		op.rec.Detail = detail
+		crossAudits = append(crossAudits, op)
+	}
+
+	return &RolloverResult{
+		Generation:       len(gens) + 1,
+		Subject:          FormatDN(newCert.Subject),
+		Algorithm:        alg.DisplayName,
+		Serial:           FormatSerialBig(serials[0]),
+		NotAfter:         notAfter,
+		CertPath:         certPath,
+		KeyPath:          keyPath,
+		Encrypted:        encrypted,
+		PreviousDir:      prevDir,
+		PreviousNotAfter: oldCert.NotAfter,
+		NewWithOldPath:   filepath.Join(prevDir, newWithOldFile),
+		OldWithNewPath:   filepath.Join(prevDir, oldWithNewFile),
+	}, nil
+}
+
+// copyNameConstraints gives dst the NameConstraints of src, so a new generation or
+// cross certificate restricts names exactly like the root it succeeds.
+func copyNameConstraints(dst, src *x509.Certificate) {
+	dst.PermittedDNSDomainsCritical = src.PermittedDNSDomainsCritical
+	dst.PermittedDNSDomains = src.PermittedDNSDomains
+	dst.ExcludedDNSDomains = src.ExcludedDNSDomains
+	dst.PermittedIPRanges = src.PermittedIPRanges
+	dst.ExcludedIPRanges = src.ExcludedIPRanges
+	dst.PermittedEmailAddresses = src.PermittedEmailAddresses
+	dst.ExcludedEmailAddresses = src.ExcludedEmailAddresses
+	dst.PermittedURIDomains = src.PermittedURIDomains
+	dst.ExcludedURIDomains = src.ExcludedURIDomains
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signer providers name where the CA private key lives. The rest of the CA only
+// ever sees a crypto.Signer, so a key held by a provider never exists as a file
+// in the data directory.
+const (
+	signerFile   = "file"   // ca.key in the data directory, plaintext or encrypted
+	signerRemote = "remote" // a signing service on a Unix socket
+)
+
+// signerProviders opens the CA key for each provider selectable in config.json.
+// A PKCS#11 token or cloud KMS is reached through a bridge that speaks the remote
+// protocol; the CA itself links no vendor libraries.
+var signerProviders = map[string]func(dataDir string, c *CAConfig, passphrase PassphraseFunc) (crypto.Signer, error){
+	signerFile:   openFileSigner,
+	signerRemote: openRemoteSigner,
+}
+
+// SignerProviders returns the provider names, sorted.
+func SignerProviders() []string {
+	names := make([]string, 0, len(signerProviders))
+	for name := range signerProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// signerProvider returns the configured provider; no setting means the file provider.
+func (c *CAConfig) signerProvider() string {
+	if c.Signer == "" {
+		return signerFile
+	}
+	return c.Signer
+}
+
+// openSigner opens the key of the provider c selects.
+func openSigner(dataDir string, c *CAConfig, passphrase PassphraseFunc) (crypto.Signer, error) {
+	open, ok := signerProviders[c.signerProvider()]
+	if !ok {
+		return nil, newCAError(KindInvalidInput, "Error: unknown signer provider %q (valid: %s)", c.Signer, strings.Join(SignerProviders(), ", "))
+	}
+	signer, err := open(dataDir, c, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if keyAlgorithmOf(signer.Public()) == nil {
+		return nil, newCAError(KindInvalidInput, "Error: the %s signer holds an unsupported key algorithm (supported: %s)", c.signerProvider(), KeyAlgorithmNames()) // CON-INV-010
+	}
+	return signer, nil
+}
+
+// LoadCASigner opens the signing key of the CA in dataDir through the provider its
+// config selects. A key held outside the data directory must belong to ca.crt, so
+// a misconfigured socket cannot sign in this CA's name with someone else's key.
+func LoadCASigner(dataDir string, config *CAConfig, passphrase PassphraseFunc) (crypto.Signer, error) {
+	signer, err := openSigner(dataDir, config, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if config.signerProvider() != signerFile {
+		if err := checkSignerKey(dataDir, config, signer); err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
+}
+
+// checkSignerKey verifies that signer holds the private key of the CA certificate.
+func checkSignerKey(dataDir string, config *CAConfig, signer crypto.Signer) error {
+	caCert, err := LoadCertificate(filepath.Join(dataDir, "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	want, err := publicKeyBytes(caCert.PublicKey)
+	if err != nil {
+		return err
+	}
+	got, err := publicKeyBytes(signer.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want, got) {
+		return newCAError(KindConflict, "Error: the %s signer's key does not match the CA certificate %s", config.signerProvider(), FormatDN(caCert.Subject))
+	}
+	return nil
+}
+
+// newCAKey returns the key of a CA being initialized: a fresh key pair for the file
+// provider, or the key another provider already holds. keyPEM is the ca.key content,
+// nil for a provider key, which is never written to the data directory.
+// Enforces CON-SC-002: cryptographically secure key generation via crypto/rand
+func newCAKey(dataDir string, config *CAConfig, keyAlgo string, passphrase []byte) (key crypto.Signer, keyPEM []byte, err error) {
+	if config != nil && config.signerProvider() != signerFile {
+		key, err = openSigner(dataDir, config, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := key.Public().(*rsa.PublicKey); config.RSAPSS && !ok {
+			return nil, nil, newCAError(KindInvalidInput, "Error: %s requires an RSA CA key", configKeyRSAPSS)
+		}
+		return key, nil, nil
+	}
+	privKey, err := generateKeyPair(keyAlgo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	keyPEM, err = marshalCAKey(privKey, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privKey.(crypto.Signer), keyPEM, nil
+}
+
+func openFileSigner(dataDir string, _ *CAConfig, passphrase PassphraseFunc) (crypto.Signer, error) {
+	key, err := LoadPrivateKey(filepath.Join(dataDir, "ca.key"), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key does not implement crypto.Signer")
+	}
+	return signer, nil
+}
+
+// The remote signer protocol is JSON over HTTP on a Unix socket:
+//
+//	GET  /public-key  → {"public_key": "<base64 PKIX DER>"}
+//	POST /sign        {"data": "<base64>", "hash": "SHA-256", "pss": false} → {"signature": "<base64>"}
+//
+// "data" is the digest, or the whole message when "hash" is empty (Ed25519). PSS
+// signatures use a salt as long as the hash. Failures return a non-200 status with
+// {"error": "..."}. "ca signer serve" is the reference service.
+type remotePublicKey struct {
+	PublicKey []byte `json:"public_key"`
+}
+
+type remoteSignRequest struct {
+	Data []byte `json:"data"`
+	Hash string `json:"hash,omitempty"`
+	PSS  bool   `json:"pss,omitempty"`
+}
+
+type remoteSignature struct {
+	Signature []byte `json:"signature"`
+}
+
+// remoteHashes are the digests the protocol carries, by crypto.Hash name.
+var remoteHashes = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// remoteSigner is a crypto.Signer whose key is held by a signing service.
+type remoteSigner struct {
+	socket string
+	client *http.Client
+	pub    crypto.PublicKey
+}
+
+func openRemoteSigner(_ string, c *CAConfig, _ PassphraseFunc) (crypto.Signer, error) {
+	s := &remoteSigner{
+		socket: c.SignerSocket,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", c.SignerSocket)
+				},
+			},
+		},
+	}
+	var resp remotePublicKey
+	if err := s.call(http.MethodGet, "/public-key", nil, &resp); err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer at %s returned an invalid public key: %w", s.socket, err)
+	}
+	s.pub = pub
+	return s, nil
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *remoteSigner) Sign(_ io.Reader, data []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := remoteSignRequest{Data: data}
+	if h := opts.HashFunc(); h != 0 {
+		req.Hash = h.String()
+	}
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		req.PSS = true
+	}
+	var resp remoteSignature
+	if err := s.call(http.MethodPost, "/sign", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// call performs one protocol request and decodes the JSON reply into out.
+func (s *remoteSigner) call(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://signer"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach remote signer at %s: %w", s.socket, err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		var apiErr apiError
+		if dec.Decode(&apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return fmt.Errorf("remote signer at %s: %s", s.socket, apiErr.Error)
+	}
+	if err := dec.Decode(out); err != nil {
+		return fmt.Errorf("invalid reply from remote signer at %s: %w", s.socket, err)
+	}
+	return nil
+}
+
+// signerServer serves one private key over the remote signer protocol.
+type signerServer struct {
+	key crypto.Signer
+}
+
+// NewSignerHandler returns the remote signer protocol handler for key.
+func NewSignerHandler(key crypto.Signer) http.Handler {
+	return &signerServer{key: key}
+}
+
+func (s *signerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/public-key" && r.Method == http.MethodGet:
+		der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, remotePublicKey{PublicKey: der})
+	case r.URL.Path == "/sign" && r.Method == http.MethodPost:
+		s.sign(w, r)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint"))
+		return
+	}
+	log.Printf("signer: %s %s", r.Method, r.URL.Path)
+}
+
+func (s *signerServer) sign(w http.ResponseWriter, r *http.Request) {
+	var req remoteSignRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var hash crypto.Hash
+	if req.Hash != "" {
+		for _, h := range remoteHashes {
+			if h.String() == req.Hash {
+				hash = h
+			}
+		}
+		if hash == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported hash %q", req.Hash))
+			return
+		}
+		if len(req.Data) != hash.Size() {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("data is not a %s digest", req.Hash))
+			return
+		}
+	}
+
+	var opts crypto.SignerOpts = hash
+	if req.PSS {
+		if _, ok := s.key.Public().(*rsa.PublicKey); !ok || hash == 0 {
+			writeError(w, http.StatusBadRequest, errors.New("pss needs an RSA key and a hash"))
+			return
+		}
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+
+	sig, err := s.key.Sign(rand.Reader, req.Data, opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to sign: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, remoteSignature{Signature: sig})
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"crypto"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
@@ -13,20 +13,30 @@ import (
 	"strings"
 )
 
-// IndexEntry represents a certificate record in index.json.
+// IndexEntry represents a certificate record in the index (index.db, or index.json
+// before "ca migrate-store").
 // Enforces CON-DI-005: index schema completeness
 type IndexEntry struct {
-	Serial           string `json:"serial"`
-	Subject          string `json:"subject"`
-	NotBefore        string `json:"not_before"`
-	NotAfter         string `json:"not_after"`
-	Status           string `json:"status"`
-	RevokedAt        string `json:"revoked_at"`
-	RevocationReason string `json:"revocation_reason"`
+	Serial           string   `json:"serial"`
+	Subject          string   `json:"subject"`
+	NotBefore        string   `json:"not_before"`
+	NotAfter         string   `json:"not_after"`
+	Status           string   `json:"status"`
+	RevokedAt        string   `json:"revoked_at"`
+	RevocationReason string   `json:"revocation_reason"`
+	IsCA             bool     `json:"is_ca,omitempty"`            // intermediate CA, or a certificate of ca rollover (amends CON-INV-009)
+	Profile          string   `json:"profile,omitempty"`          // profile the certificate was issued under
+	Replaces         string   `json:"replaces,omitempty"`         // serial this certificate renewed or rekeyed
+	ReplacedBy       string   `json:"replaced_by,omitempty"`      // serial of its renewal or rekey
+	InvalidityDate   string   `json:"invalidity_date,omitempty"`  // RFC 5280 invalidityDate of a revocation
+	HoldReleasedAt   string   `json:"hold_released_at,omitempty"` // when a certificateHold was last released
+	SANs             []string `json:"sans,omitempty"`             // "dns:…", "ip:…", "email:…", "uri:…" (indexSANs)
+	Generation       int      `json:"generation,omitempty"`       // CA key generation that issued it; 0 before the first ca rollover
 }
 
 // InitDataDir creates the CA data directory structure.
-// Creates: data dir, certs/ subdir, serial("02"), crlnumber("01"), index.json("[]").
+// Creates: data dir, certs/ and crls/ subdirs. InitCA writes serial("02"), crlnumber("01")
+// and an empty index.db.
 // Enforces CON-DI-008: serial counter consistency
 // Enforces CON-DI-009: CRL number counter consistency
 func InitDataDir(dataDir string) error {
@@ -34,17 +44,27 @@ func InitDataDir(dataDir string) error {
 	if err := os.MkdirAll(certsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create certs directory: %w", err)
 	}
+	crlsDir := filepath.Join(dataDir, "crls")
+	if err := os.MkdirAll(crlsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create crls directory: %w", err)
+	}
 	return nil
 }
 
-// IsInitialized returns true if both ca.key and ca.crt exist in dataDir.
+// IsInitialized returns true if ca.crt exists in dataDir together with its key:
+// ca.key, or a config.json naming the signer provider that holds the key.
 // Enforces CON-INV-004: CA initialization prerequisite
 func IsInitialized(dataDir string) bool {
 	keyPath := filepath.Join(dataDir, "ca.key")
 	certPath := filepath.Join(dataDir, "ca.crt")
-	_, keyErr := os.Stat(keyPath)
-	_, certErr := os.Stat(certPath)
-	return keyErr == nil && certErr == nil
+	if _, err := os.Stat(certPath); err != nil {
+		return false
+	}
+	if _, err := os.Stat(keyPath); err == nil {
+		return true
+	}
+	config, err := LoadConfig(dataDir)
+	return err == nil && config.signerProvider() != signerFile
 }
 
 // SavePrivateKey marshals a private key to PKCS#8 PEM and writes it to path.
@@ -62,15 +82,34 @@ func SavePrivateKey(path string, key crypto.PrivateKey) error {
 	return writeFileAtomic(path, pemBlock, 0600)
 }
 
+// SaveEncryptedPrivateKey writes key as a passphrase-protected PKCS#8 PEM file.
+// Enforces CON-DI-001: PEM encoding ("ENCRYPTED PRIVATE KEY" header)
+// Enforces CON-SC-001: key material only written to file, never to output
+func SaveEncryptedPrivateKey(path string, key crypto.PrivateKey, passphrase []byte) error {
+	pemBlock, err := EncryptPrivateKeyPEM(key, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, pemBlock, 0600)
+}
+
 // LoadPrivateKey reads a PEM-encoded PKCS#8 private key from path.
-func LoadPrivateKey(path string) (crypto.PrivateKey, error) {
-	data, err := os.ReadFile(path)
+// Encrypted keys are decrypted transparently; passphrase is only consulted for
+// them and may be nil when the key is known to be plaintext.
+func LoadPrivateKey(path string, passphrase PassphraseFunc) (crypto.PrivateKey, error) {
+	block, err := readPEMBlock(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read private key: %w", err)
+		return nil, err
 	}
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM block from %s", path)
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if passphrase == nil {
+			return nil, fmt.Errorf("private key %s is encrypted and no passphrase was supplied", path)
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		return decryptPKCS8(block.Bytes, pass)
 	}
 	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
@@ -79,6 +118,19 @@ func LoadPrivateKey(path string) (crypto.PrivateKey, error) {
 	return key, nil
 }
 
+// readPEMBlock reads path and decodes its first PEM block.
+func readPEMBlock(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block from %s", path)
+	}
+	return block, nil
+}
+
 // SaveCertPEM writes a DER-encoded certificate as PEM to path.
 // Enforces CON-DI-001: PEM encoding ("CERTIFICATE" header)
 func SaveCertPEM(path string, certDER []byte) error {
@@ -106,6 +158,36 @@ func LoadCertificate(path string) (*x509.Certificate, error) {
 	return cert, nil
 }
 
+// LoadChain reads chain.pem from the data directory: the issuer chain of an
+// intermediate CA, ordered from its direct parent up to the root.
+// A root CA has no chain.pem and yields an empty chain.
+func LoadChain(dataDir string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, "chain.pem"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read chain: %w", err)
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse chain certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
 // SaveCRLPEM writes a DER-encoded CRL as PEM to path.
 // Enforces CON-DI-001: PEM encoding ("X509 CRL" header)
 func SaveCRLPEM(path string, crlDER []byte) error {
@@ -155,31 +237,14 @@ func WriteCounter(path string, value int64) error {
 	return writeFileAtomic(path, []byte(s+"\n"), 0644)
 }
 
-// LoadIndex reads and parses index.json from the data directory.
+// LoadIndex returns every entry of the data directory's index in issuance order.
 // Enforces CON-DI-005: index schema completeness
 func LoadIndex(dataDir string) ([]IndexEntry, error) {
-	path := filepath.Join(dataDir, "index.json")
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read index: %w", err)
-	}
-	var entries []IndexEntry
-	if err := json.Unmarshal(data, &entries); err != nil {
-		return nil, fmt.Errorf("failed to parse index: %w", err)
-	}
-	return entries, nil
-}
-
-// SaveIndex serializes index entries to JSON and writes to index.json.
-// Enforces CON-DI-005: index schema completeness
-func SaveIndex(dataDir string, entries []IndexEntry) error {
-	data, err := json.MarshalIndent(entries, "", "  ")
+	store, err := OpenIndex(dataDir)
 	if err != nil {
-		return fmt.Errorf("failed to marshal index: %w", err)
+		return nil, err
 	}
-	data = append(data, '\n')
-	path := filepath.Join(dataDir, "index.json")
-	return writeFileAtomic(path, data, 0644)
+	return store.Entries()
 }
 
 // FormatSerial returns a lowercase hex string zero-padded to at least 2 digits.
@@ -198,22 +263,82 @@ func FormatSerialBig(n *big.Int) string {
 	return s
 }
 
-// writeFileAtomic writes data to a temporary file then renames it atomically.
+// NormalizeSerial returns a serial given on the command line or in a URL in the
+// FormatSerialBig form: lowercase hex without leading zeros or colons, so that
+// "0A:1B" and "a1b" name the same certificate. Input that is not hex is only lowercased.
+// Enforces CON-DI-002: serial number hexadecimal format
+func NormalizeSerial(s string) string {
+	n, ok := new(big.Int).SetString(strings.ReplaceAll(s, ":", ""), 16)
+	if !ok || n.Sign() < 0 {
+		return strings.ToLower(s)
+	}
+	return FormatSerialBig(n)
+}
+
+// writeFileAtomic writes data to a uniquely named temporary file then renames it atomically.
 // Enforces CON-DI-004: atomicity via atomic file replacement (ADR-006)
 func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
-	tmpPath := path + ".tmp"
-	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+	return stageAndCommit([]stagedFile{{path, data, perm}})
+}
+
+// stagedFile is one output of a multi-file mutation.
+type stagedFile struct {
+	path string
+	data []byte
+	perm os.FileMode
+}
+
+// stageFile writes data to a new temporary file next to path and returns its name.
+// The name is unique (<name>.<random>.tmp), so concurrent writers never share a temp file.
+func stageFile(path string, data []byte, perm os.FileMode) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
 		os.Remove(tmpPath)
-		return fmt.Errorf("failed to write %s: %w", path, err)
+		return "", err
 	}
-	if err := os.Rename(tmpPath, path); err != nil {
+	if err := os.Chmod(tmpPath, perm); err != nil {
 		os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename %s: %w", path, err)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// stageAndCommit writes every file to a temporary path, then renames them in the
+// given order. Nothing is renamed unless every file was staged successfully.
+// Enforces CON-DI-004: stage-then-commit for multi-file mutations (ADR-006)
+func stageAndCommit(files []stagedFile) error {
+	var tmpPaths []string
+
+	// STAGE SUB-PHASE
+	for _, f := range files {
+		tmpPath, err := stageFile(f.path, f.data, f.perm)
+		if err != nil {
+			cleanupTempFiles(tmpPaths)
+			return fmt.Errorf("failed to stage %s: %w", f.path, err)
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+	}
+
+	// COMMIT SUB-PHASE
+	for i, f := range files {
+		if err := os.Rename(tmpPaths[i], f.path); err != nil {
+			cleanupTempFiles(tmpPaths[i:])
+			return fmt.Errorf("failed to commit %s: %w", f.path, err)
+		}
 	}
 	return nil
 }
 
-// cleanupTempFiles removes .tmp files best-effort. Called on staging failure.
+// cleanupTempFiles removes staged temporary files best-effort. Called on staging failure.
 // Enforces CON-DI-004: no partial state on failure (ADR-006)
 func cleanupTempFiles(paths []string) {
 	for _, p := range paths {
@@ -0,0 +1,585 @@
+// Command acme-client is the minimal ACME (RFC 8555) client validate.sh drives
+// "ca acme serve" with. It signs every request as a JWS with an ES256 account key
+// and answers challenges itself: http-01 from a local HTTP server and dns-01 from
+// a local DNS server that holds only the _acme-challenge TXT records. Point the
+// CA at them with --http01-port and --dns-resolver.
+//
+//	acme-client -directory URL -account-key FILE [-challenge http-01|dns-01] [-late-fail] [-out FILE] issue NAME...
+//	acme-client -directory URL -account-key FILE -cert FILE [-reason N] revoke
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+func main() {
+	directory := flag.String("directory", "", "ACME directory URL")
+	keyPath := flag.String("account-key", "", "Account key (PEM), created if missing")
+	challenge := flag.String("challenge", "http-01", "Challenge type to answer: http-01 or dns-01")
+	http01Addr := flag.String("http01-addr", "127.0.0.1:5002", "Listen address of the http-01 responder")
+	dnsAddr := flag.String("dns-addr", "127.0.0.1:5053", "Listen address (UDP) of the dns-01 responder")
+	email := flag.String("email", "", "Also request this email SAN, which no order can authorize")
+	lateFail := flag.Bool("late-fail", false, "Also trigger the other challenge type, which the responder fails after a delay")
+	out := flag.String("out", "cert.pem", "Where issue writes the certificate chain")
+	certPath := flag.String("cert", "", "Certificate to revoke")
+	reason := flag.Int("reason", -1, "Revocation reason code (default: none)")
+	flag.Parse()
+
+	if *directory == "" || *keyPath == "" || flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: acme-client -directory URL -account-key FILE [flags] issue NAME... | -cert FILE revoke")
+		os.Exit(2)
+	}
+	c, err := newClient(*directory, *keyPath)
+	if err == nil {
+		switch cmd := flag.Arg(0); cmd {
+		case "issue":
+			err = c.issue(flag.Args()[1:], *challenge, *lateFail, *http01Addr, *dnsAddr, *email, *out)
+		case "revoke":
+			err = c.revoke(*certPath, *reason)
+		default:
+			err = fmt.Errorf("unknown command %q", cmd)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type client struct {
+	dir        map[string]string
+	key        *ecdsa.PrivateKey
+	jwk        map[string]string
+	thumbprint string
+	kid        string
+	nonce      string
+	http       *http.Client
+}
+
+// problem is an RFC 7807 error document returned by the server.
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newClient(directory, keyPath string) (*client, error) {
+	key, err := loadOrCreateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := b64(key.X.FillBytes(make([]byte, size)))
+	y := b64(key.Y.FillBytes(make([]byte, size)))
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":%q,"y":%q}`, x, y))) // RFC 7638
+	c := &client{
+		key:        key,
+		jwk:        map[string]string{"kty": "EC", "crv": "P-256", "x": x, "y": y},
+		thumbprint: b64(sum[:]),
+		http:       &http.Client{Timeout: 30 * time.Second},
+	}
+
+	resp, err := c.http.Get(directory)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&c.dir); err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	return c, nil
+}
+
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM key", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// post sends payload (nil for POST-as-GET) as a flattened JWS signed with the
+// account key, by kid once the account is known and by jwk before.
+func (c *client) post(url string, payload interface{}, into interface{}) (http.Header, []byte, error) {
+	if c.nonce == "" {
+		resp, err := c.http.Head(c.dir["newNonce"])
+		if err != nil {
+			return nil, nil, err
+		}
+		resp.Body.Close()
+		c.nonce = resp.Header.Get("Replay-Nonce")
+	}
+
+	header := map[string]interface{}{"alg": "ES256", "nonce": c.nonce, "url": url}
+	if c.kid != "" {
+		header["kid"] = c.kid
+	} else {
+		header["jwk"] = c.jwk
+	}
+	protectedJSON, _ := json.Marshal(header)
+	protected := b64(protectedJSON)
+	encodedPayload := ""
+	if payload != nil {
+		payloadJSON, _ := json.Marshal(payload)
+		encodedPayload = b64(payloadJSON)
+	}
+	digest := sha256.Sum256([]byte(protected + "." + encodedPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...) // R || S (RFC 7518 §3.4)
+	body, _ := json.Marshal(map[string]string{"protected": protected, "payload": encodedPayload, "signature": b64(sig)})
+
+	resp, err := c.http.Post(url, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		var p problem
+		json.Unmarshal(data, &p)
+		return nil, nil, fmt.Errorf("%s: %s (%s)", url, p.Detail, strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:"))
+	}
+	if into != nil {
+		if err := json.Unmarshal(data, into); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", url, err)
+		}
+	}
+	return resp.Header, data, nil
+}
+
+// account registers the account key, or finds its account when onlyExisting is set.
+func (c *client) account(onlyExisting bool) error {
+	payload := map[string]interface{}{"termsOfServiceAgreed": true}
+	if onlyExisting {
+		payload = map[string]interface{}{"onlyReturnExisting": true}
+	}
+	h, _, err := c.post(c.dir["newAccount"], payload, nil)
+	if err != nil {
+		return err
+	}
+	c.kid = h.Get("Location")
+	fmt.Printf("Account: %s\n", c.kid)
+	return nil
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *problem `json:"error"`
+}
+
+type authorization struct {
+	Identifier struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Status     string `json:"status"`
+	Challenges []struct {
+		Type   string   `json:"type"`
+		URL    string   `json:"url"`
+		Token  string   `json:"token"`
+		Status string   `json:"status"`
+		Error  *problem `json:"error"`
+	} `json:"challenges"`
+}
+
+// lateFailDelay is how long the responder holds a -late-fail challenge before failing
+// it, so that it finishes after the answered challenge has settled the authorization.
+const lateFailDelay = time.Second
+
+func (c *client) issue(names []string, challenge string, lateFail bool, http01Addr, dnsAddr, email, out string) error {
+	if len(names) == 0 {
+		return errors.New("issue needs at least one name")
+	}
+	if err := c.account(false); err != nil {
+		return err
+	}
+
+	var identifiers []map[string]string
+	for _, name := range names {
+		t := "dns"
+		if net.ParseIP(name) != nil {
+			t = "ip"
+		}
+		identifiers = append(identifiers, map[string]string{"type": t, "value": name})
+	}
+	var o order
+	h, _, err := c.post(c.dir["newOrder"], map[string]interface{}{"identifiers": identifiers}, &o)
+	if err != nil {
+		return err
+	}
+	orderURL := h.Get("Location")
+	fmt.Printf("Order: %s\n", orderURL)
+
+	responder := &responder{http01: map[string]string{}, txt: map[string][]string{}}
+	types := []string{challenge}
+	other := ""
+	if lateFail {
+		other = "dns-01"
+		if challenge == "dns-01" {
+			other = "http-01"
+		}
+		types = append(types, other)
+		responder.failDelay = lateFailDelay
+	}
+	stop, err := responder.start(types, http01Addr, dnsAddr)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	for _, authzURL := range o.Authorizations {
+		var a authorization
+		if _, _, err := c.post(authzURL, nil, &a); err != nil {
+			return err
+		}
+		chalURL, otherURL := "", ""
+		for _, ch := range a.Challenges {
+			if ch.Type == other {
+				otherURL = ch.URL
+			}
+			if ch.Type != challenge {
+				continue
+			}
+			keyAuth := ch.Token + "." + c.thumbprint
+			if challenge == "http-01" {
+				responder.setHTTP01(ch.Token, keyAuth)
+			} else {
+				sum := sha256.Sum256([]byte(keyAuth))
+				responder.addTXT("_acme-challenge."+a.Identifier.Value, b64(sum[:]))
+			}
+			chalURL = ch.URL
+		}
+		if chalURL == "" {
+			return fmt.Errorf("%s offers no %s challenge", a.Identifier.Value, challenge)
+		}
+		// The unanswered challenge goes first, so it is still processing when the
+		// answered one settles the authorization.
+		if otherURL != "" {
+			if _, _, err := c.post(otherURL, struct{}{}, nil); err != nil {
+				return err
+			}
+		}
+		if _, _, err := c.post(chalURL, struct{}{}, nil); err != nil {
+			return err
+		}
+		if err := c.poll(authzURL, &a, func() string { return a.Status }); err != nil {
+			return err
+		}
+		if a.Status != "valid" {
+			for _, ch := range a.Challenges {
+				if ch.Error != nil {
+					return fmt.Errorf("authorization for %s is %s: %s", a.Identifier.Value, a.Status, ch.Error.Detail)
+				}
+			}
+			return fmt.Errorf("authorization for %s is %s", a.Identifier.Value, a.Status)
+		}
+		fmt.Printf("Authorization: %s valid via %s\n", a.Identifier.Value, challenge)
+	}
+
+	csr, err := newCSR(names, email)
+	if err != nil {
+		return err
+	}
+	if _, _, err := c.post(o.Finalize, map[string]string{"csr": b64(csr)}, &o); err != nil {
+		return err
+	}
+	if err := c.poll(orderURL, &o, func() string { return o.Status }); err != nil {
+		return err
+	}
+	if o.Status != "valid" {
+		return fmt.Errorf("order is %s", o.Status)
+	}
+	fmt.Println("Order: valid")
+
+	_, chain, err := c.post(o.Certificate, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, chain, 0644); err != nil {
+		return err
+	}
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return errors.New("certificate response is not PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Certificate: %s (serial %02x)\n", out, cert.SerialNumber)
+
+	if lateFail {
+		// Wait for the unanswered challenges to fail; they must not change the
+		// authorizations the order was issued under.
+		for _, authzURL := range o.Authorizations {
+			var a authorization
+			if _, _, err := c.post(authzURL, nil, &a); err != nil {
+				return err
+			}
+			otherStatus := func() string {
+				for _, ch := range a.Challenges {
+					if ch.Type == other {
+						return ch.Status
+					}
+				}
+				return ""
+			}
+			if err := c.poll(authzURL, &a, otherStatus); err != nil {
+				return err
+			}
+			fmt.Printf("Authorization: %s %s after %s became %s\n", a.Identifier.Value, a.Status, other, otherStatus())
+		}
+	}
+	return nil
+}
+
+// poll fetches url into v until status() leaves pending and processing.
+func (c *client) poll(url string, v interface{}, status func() string) error {
+	for i := 0; i < 50; i++ {
+		if s := status(); s != "pending" && s != "processing" {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+		if _, _, err := c.post(url, nil, v); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%s is still %s", url, status())
+}
+
+// newCSR makes a CSR for names with a fresh P-256 key, its CN the first name.
+func newCSR(names []string, email string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: names[0]}}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	if email != "" {
+		template.EmailAddresses = []string{email}
+	}
+	return x509.CreateCertificateRequest(rand.Reader, template, key)
+}
+
+func (c *client) revoke(certPath string, reason int) error {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%s: no PEM certificate", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if err := c.account(true); err != nil {
+		return err
+	}
+	payload := map[string]interface{}{"certificate": b64(block.Bytes)}
+	if reason >= 0 {
+		payload["reason"] = reason
+	}
+	if _, _, err := c.post(c.dir["revokeCert"], payload, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked: %02x\n", cert.SerialNumber)
+	return nil
+}
+
+// responder answers http-01 and dns-01 challenges for the duration of an issue.
+type responder struct {
+	mu        sync.Mutex
+	http01    map[string]string   // token -> key authorization
+	txt       map[string][]string // lower-case FQDN without the trailing dot -> TXT values
+	failDelay time.Duration       // held before answering a request it has nothing for
+}
+
+func (r *responder) setHTTP01(token, keyAuth string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.http01[token] = keyAuth
+}
+
+func (r *responder) addTXT(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = strings.ToLower(name)
+	r.txt[name] = append(r.txt[name], value)
+}
+
+// start runs the stand-in server of each challenge type until stop is called.
+func (r *responder) start(types []string, http01Addr, dnsAddr string) (stop func(), err error) {
+	var stops []func()
+	stop = func() {
+		for _, f := range stops {
+			f()
+		}
+	}
+	for _, t := range types {
+		var f func()
+		switch t {
+		case "http-01":
+			f, err = r.startHTTP01(http01Addr)
+		case "dns-01":
+			f, err = r.startDNS(dnsAddr)
+		default:
+			err = fmt.Errorf("unsupported challenge type %q", t)
+		}
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		stops = append(stops, f)
+	}
+	return stop, nil
+}
+
+func (r *responder) startHTTP01(addr string) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		keyAuth, ok := r.http01[strings.TrimPrefix(req.URL.Path, "/.well-known/acme-challenge/")]
+		r.mu.Unlock()
+		if !ok {
+			time.Sleep(r.failDelay)
+			http.NotFound(w, req)
+			return
+		}
+		io.WriteString(w, keyAuth)
+	})}
+	go srv.Serve(ln)
+	return func() { srv.Close() }, nil
+}
+
+func (r *responder) startDNS(addr string) (func(), error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := append([]byte(nil), buf[:n]...)
+			go func() {
+				if reply := r.answerDNS(query); reply != nil {
+					conn.WriteTo(reply, from)
+				}
+			}()
+		}
+	}()
+	return func() { conn.Close() }, nil
+}
+
+// answerDNS answers a single-question query (RFC 1035 §4.1) with the TXT records
+// held for its name, or NXDOMAIN.
+func (r *responder) answerDNS(query []byte) []byte {
+	if len(query) < 12 || binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return nil
+	}
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		if l > 63 || i+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += 1 + l
+	}
+	if i+5 > len(query) {
+		return nil
+	}
+	question := query[12 : i+5]
+	qtype := binary.BigEndian.Uint16(query[i+1 : i+3])
+
+	r.mu.Lock()
+	values, ok := r.txt[strings.ToLower(strings.Join(labels, "."))]
+	r.mu.Unlock()
+
+	reply := make([]byte, 12, 512)
+	copy(reply, query[:2])
+	flags := uint16(0x8400) | binary.BigEndian.Uint16(query[2:4])&0x0100 // QR, AA, copy RD
+	if !ok {
+		flags |= 3 // NXDOMAIN
+		time.Sleep(r.failDelay)
+	}
+	binary.BigEndian.PutUint16(reply[2:], flags)
+	binary.BigEndian.PutUint16(reply[4:], 1)
+	if qtype == 16 && ok {
+		binary.BigEndian.PutUint16(reply[6:], uint16(len(values)))
+	}
+	reply = append(reply, question...)
+	if qtype != 16 || !ok {
+		return reply
+	}
+	for _, v := range values {
+		rr := []byte{0xc0, 12, 0, 16, 0, 1, 0, 0, 0, 60} // name pointer, TXT, IN, TTL 60
+		rr = binary.BigEndian.AppendUint16(rr, uint16(len(v)+1))
+		rr = append(rr, byte(len(v)))
+		reply = append(reply, append(rr, v...)...)
+	}
+	return reply
+}
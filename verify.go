@@ -1,57 +1,158 @@
 package main
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
 // VerifyResult contains the results of certificate verification.
 type VerifyResult struct {
-	Valid     bool
-	Subject   string
-	Serial    string
-	Issuer    string
-	NotBefore time.Time
-	NotAfter  time.Time
-	SigOK     bool
-	SigErr    string // empty if SigOK is true
-	ExpiryOK  bool
-	RevStatus string // "OK (not revoked)", "REVOKED (reason: X, date: Y)", or "NOT CHECKED (no CRL available)"
+	Valid       bool         `json:"valid"`
+	Subject     string       `json:"subject"`
+	Serial      string       `json:"serial"`
+	Issuer      string       `json:"issuer"`
+	Chain       []string     `json:"chain,omitempty"` // subjects from the certificate up to the root
+	NotBefore   time.Time    `json:"not_before"`
+	NotAfter    time.Time    `json:"not_after"`
+	SigOK       bool         `json:"signature_ok"`
+	SigErr      string       `json:"signature_error,omitempty"` // empty if SigOK is true
+	PathOK      bool         `json:"path_ok"`
+	PathErr     string       `json:"path_error,omitempty"` // why x509 path validation rejects the chain
+	Links       []VerifyLink `json:"links,omitempty"`      // the chain, with the status of each certificate
+	ExpiryOK    bool         `json:"expiry_ok"`
+	RevStatus   string       `json:"revocation_status,omitempty"` // "OK (not revoked)", "REVOKED (reason: X, date: Y)", "NOT CHECKED (no CRL available)", "STALE (...)", "INVALID CRL (...)" or "MISMATCH (...)"
+	CRLStatus   string       `json:"crl_status,omitempty"`        // the CRL consulted, its freshness and what it says
+	IndexStatus string       `json:"index_status,omitempty"`      // with --index: what the index says
+	OCSPStatus  string       `json:"ocsp_status,omitempty"`       // with --ocsp: what the responder says
+	Purpose     string       `json:"purpose,omitempty"`
+	Names       []string     `json:"names,omitempty"`       // the --hostname and --ip values checked against the SANs
+	NameStatus  string       `json:"name_status,omitempty"` // "OK" or "MISMATCH (...)"; empty if no name was given
+
+	Generation int `json:"generation,omitempty"` // CA key generation that issued it, after a rollover
+
+	// Where the certificate says its status and issuer can be found (reported, not fetched)
+	OCSPServers           []string `json:"ocsp_servers,omitempty"`
+	CAIssuers             []string `json:"ca_issuers,omitempty"`
+	CRLDistributionPoints []string `json:"crl_distribution_points,omitempty"`
+	FreshestCRL           []string `json:"freshest_crl,omitempty"`
+}
+
+// VerifyLink is one certificate of the chain that ca verify built.
+type VerifyLink struct {
+	Subject  string    `json:"subject"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+	Source   string    `json:"source"` // "certificate", "bundle" or "ca" (this data directory)
+	Status   string    `json:"status"` // "OK", or why path validation rejects this certificate
+}
+
+// VerifyOptions are the checks ca verify makes beyond the chain itself.
+type VerifyOptions struct {
+	Purpose  string // "server" or "client"; empty accepts any extended key usage
+	Hostname string // must match a DNS name SAN
+	IP       string // must match an IP address SAN
+	Index    bool   // also consult the index for revocations
+	OCSPURL  string // also ask this OCSP responder about the certificate
+}
+
+// verifyPurposes maps --purpose to the extended key usage that every certificate of the
+// chain must allow.
+var verifyPurposes = map[string]x509.ExtKeyUsage{
+	"server": x509.ExtKeyUsageServerAuth,
+	"client": x509.ExtKeyUsageClientAuth,
+}
+
+// VerifyPurposeNames lists the accepted --purpose values.
+func VerifyPurposeNames() string {
+	names := make([]string, 0, len(verifyPurposes))
+	for name := range verifyPurposes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
 }
 
 // VerifyCert verifies a certificate's signature, validity, and revocation status.
+// The signature check builds the full chain up to the root, so certificates issued by
+// an intermediate CA verify against that intermediate's data directory, and the root's
+// data directory can verify certificates issued by intermediates it signed. After a
+// ca rollover every generation of the root is trusted, and a certificate is checked
+// against the CRL of the generation that issued it.
+//
+// certPEM may be a bundle: the first certificate is verified and the rest are offered
+// as intermediates. The chain is then validated as crypto/x509 does for a TLS peer
+// (basic constraints, key usage, extended key usage for opts.Purpose, name constraints
+// and path length, and the validity of every issuer), and the status of each link is
+// reported. A hostname or IP in opts must match the certificate's SANs.
 // Enforces CON-INV-004: CA initialization prerequisite
 // Enforces CON-BD-016: preconditions
 // Enforces CON-BD-017: three checks in order (signature, expiry, revocation)
 // Enforces CON-BD-018: error conditions
 // Enforces CON-DI-014: system clock for expiry check
-func VerifyCert(dataDir string, certPEM []byte, certPath string) (*VerifyResult, error) {
+func VerifyCert(dataDir string, certPEM []byte, certPath string, opts VerifyOptions) (*VerifyResult, error) {
 	// Check CA initialization (CON-INV-004)
 	if !IsInitialized(dataDir) {
-		return nil, fmt.Errorf("Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
+		return nil, newCAError(KindNotInitialized, "Error: CA not initialized. Run 'ca init' first.") // REQ-ER-002
 	}
 
-	// Parse the certificate to verify
-	block, _ := pem.Decode(certPEM)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM from %s", certPath)
+	usage := x509.ExtKeyUsageAny
+	if opts.Purpose != "" {
+		var ok bool
+		if usage, ok = verifyPurposes[opts.Purpose]; !ok {
+			return nil, newCAError(KindInvalidInput, "Error: unknown purpose %q (supported: %s)", opts.Purpose, VerifyPurposeNames())
+		}
+	}
+	var ip net.IP
+	if opts.IP != "" {
+		if ip = net.ParseIP(opts.IP); ip == nil {
+			return nil, newCAError(KindInvalidInput, "Error: %q is not an IP address", opts.IP)
+		}
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse certificate from %s: %w", certPath, err)
+	// Parse the certificate to verify, and the intermediates bundled with it
+	var certs []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, newCAError(KindInvalidInput, "failed to parse certificate %d from %s: %v", len(certs)+1, certPath, err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, newCAError(KindInvalidInput, "failed to decode a PEM certificate from %s", certPath)
+	}
+	// A bundled root is no trust anchor: only this CA's roots are
+	cert := certs[0]
+	var bundle []*x509.Certificate
+	for _, c := range certs[1:] {
+		if !bytes.Equal(c.RawSubject, c.RawIssuer) {
+			bundle = append(bundle, c)
+		}
 	}
 
-	// Load CA certificate
-	caCertPath := filepath.Join(dataDir, "ca.crt")
-	caCert, err := LoadCertificate(caCertPath)
+	// Load the CA certificate of every generation; the current one is last
+	gens, err := LoadGenerations(dataDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+		return nil, err
 	}
+	caCert := gens[len(gens)-1].Cert
 
 	result := &VerifyResult{
 		Subject:   FormatDN(cert.Subject),
@@ -59,10 +160,25 @@ func VerifyCert(dataDir string, certPEM []byte, certPath string) (*VerifyResult,
 		Issuer:    FormatDN(cert.Issuer),
 		NotBefore: cert.NotBefore,
 		NotAfter:  cert.NotAfter,
+
+		OCSPServers:           cert.OCSPServer,
+		CAIssuers:             cert.IssuingCertificateURL,
+		CRLDistributionPoints: cert.CRLDistributionPoints,
+		FreshestCRL:           freshestCRLURLs(cert),
 	}
 
-	// Check 1: Signature validation (CON-BD-017)
-	if err := cert.CheckSignatureFrom(caCert); err != nil {
+	issuers, err := caIssuers(dataDir, caCert)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gens[:len(gens)-1] {
+		issuers = append(issuers, g.Cert)
+	}
+
+	// Check 1: Signature validation along the chain (CON-BD-017)
+	// Bundled intermediates come after this CA's own certificates, which are preferred.
+	chain, err := buildChain(cert, append(issuers, bundle...))
+	if err != nil {
 		result.SigOK = false
 		result.SigErr = err.Error()
 		result.Valid = false
@@ -70,43 +186,364 @@ func VerifyCert(dataDir string, certPEM []byte, certPath string) (*VerifyResult,
 		return result, nil
 	}
 	result.SigOK = true
+	for _, c := range chain {
+		result.Chain = append(result.Chain, FormatDN(c.Subject))
+	}
 
-	// Check 2: Validity period (CON-BD-017, CON-DI-014)
+	// The chain under x509 path validation rules, as a TLS client or server would check it
 	now := time.Now().UTC()
+	result.Purpose = opts.Purpose
+	result.Links, result.PathErr = validatePath(chain, issuers, bundle, usage, now)
+	result.PathOK = result.PathErr == ""
+
+	// Check 2: Validity period (CON-BD-017, CON-DI-014)
 	result.ExpiryOK = !now.Before(cert.NotBefore) && !now.After(cert.NotAfter)
 
 	// Check 3: Revocation check against CRL (CON-BD-017)
-	isRevoked := false
-	crlFilePath := filepath.Join(dataDir, "ca.crl")
-	if _, err := os.Stat(crlFilePath); err == nil {
-		crl, err := LoadCRL(crlFilePath)
+	// This CA's CRLs only speak for certificates it issued itself: the leaf when
+	// verifying in the issuing directory, or an intermediate when verifying at the root.
+	// Each is on the CRL of the generation that issued it.
+	generation := func(c *x509.Certificate) *CAGeneration {
+		for _, g := range gens {
+			if c.Equal(g.Cert) {
+				return g
+			}
+		}
+		return nil
+	}
+	type checkedCert struct {
+		cert *x509.Certificate
+		gen  *CAGeneration
+	}
+	var checked []checkedCert
+	for i := 0; i < len(chain)-1; i++ {
+		if g := generation(chain[i+1]); g != nil && generation(chain[i]) == nil {
+			checked = append(checked, checkedCert{chain[i], g})
+			if i == 0 && len(gens) > 1 {
+				result.Generation = g.Number
+			}
+		}
+	}
+
+	// Each certificate's status on its CRL (and the delta CRL), and with --index and
+	// --ocsp on those too; any disagreement between them is reported as such.
+	var store IndexStore
+	if opts.Index {
+		if store, err = OpenIndex(dataDir); err != nil {
+			return nil, fmt.Errorf("failed to open index: %w", err)
+		}
+	}
+	var checks []*revocationCheck
+	for _, cc := range checked {
+		rc, err := checkRevocation(cc.cert, cc.gen, store, now)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load CRL: %w", err)
+			return nil, err
+		}
+		checks = append(checks, rc)
+	}
+	if opts.OCSPURL != "" && len(chain) > 1 {
+		// The responder speaks for the leaf only, whoever issued it
+		var leaf *revocationCheck
+		if len(checks) > 0 && checks[0].cert == cert {
+			leaf = checks[0]
+		} else {
+			leaf = &revocationCheck{cert: cert}
+			checks = append([]*revocationCheck{leaf}, checks...)
 		}
+		status, err := QueryOCSP(opts.OCSPURL, cert, chain[1])
+		switch {
+		case err != nil:
+			leaf.ocspStatus = fmt.Sprintf("ERROR (%v)", err)
+		case status.Status == "unknown":
+			leaf.ocspStatus = "unknown"
+		default:
+			view := revocationView{source: "OCSP", revoked: status.Status == "revoked", reason: status.Reason, at: status.RevokedAt}
+			leaf.views = append(leaf.views, view)
+			leaf.ocspStatus = view.String()
+		}
+	}
 
-		for _, entry := range crl.RevokedCertificateEntries {
-			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
-				isRevoked = true
-				reasonName := ReasonNames[entry.ReasonCode]
-				if reasonName == "" {
-					reasonName = "unspecified"
-				}
-				result.RevStatus = fmt.Sprintf("REVOKED (reason: %s, date: %s)",
-					reasonName, entry.RevocationTime.UTC().Format(time.RFC3339))
-				break
-			}
+	isRevoked := false
+	if len(checks) == 0 {
+		result.RevStatus = "NOT CHECKED (not issued by this CA)"
+	}
+	severity := -1
+	for _, rc := range checks {
+		status, level := rc.status(rc.cert != cert)
+		if rc.revoked() || rc.crlInvalid {
+			isRevoked = true
+		}
+		if level > severity {
+			result.RevStatus, severity = status, level
 		}
+	}
+	if len(checks) > 0 {
+		result.CRLStatus, result.IndexStatus, result.OCSPStatus = checks[0].crlStatus, checks[0].indexStatus, checks[0].ocspStatus
+	}
 
-		if !isRevoked {
-			result.RevStatus = "OK (not revoked)"
+	// The names the certificate is expected to be valid for, against its SANs
+	if opts.Hostname != "" {
+		result.Names = append(result.Names, opts.Hostname)
+	}
+	if ip != nil {
+		result.Names = append(result.Names, ip.String())
+	}
+	var mismatches []string
+	for _, name := range result.Names {
+		if err := cert.VerifyHostname(name); err != nil {
+			mismatches = append(mismatches, err.Error())
 		}
-	} else {
-		// No CRL file — does not cause failure (CON-BD-017)
-		result.RevStatus = "NOT CHECKED (no CRL available)"
+	}
+	switch {
+	case len(mismatches) > 0:
+		result.NameStatus = fmt.Sprintf("MISMATCH (%s)", strings.Join(mismatches, "; "))
+	case len(result.Names) > 0:
+		result.NameStatus = "OK"
 	}
 
 	// Compute overall validity (CON-BD-017)
-	result.Valid = result.SigOK && result.ExpiryOK && !isRevoked
+	result.Valid = result.SigOK && result.PathOK && result.ExpiryOK && !isRevoked && len(mismatches) == 0
 
 	return result, nil
 }
+
+// validatePath runs crypto/x509 path validation over the chain buildChain found, with
+// the self-signed certificates of this CA as roots and the rest, bundled ones included,
+// as intermediates, and reports each link. The leaf's own validity period is left to
+// the expiry check, so the path is validated at a time within it. It returns the links
+// and, if the path is rejected, why.
+func validatePath(chain, issuers, bundle []*x509.Certificate, usage x509.ExtKeyUsage, now time.Time) ([]VerifyLink, string) {
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for _, c := range bundle {
+		intermediates.AddCert(c)
+	}
+	for _, c := range issuers {
+		if bytes.Equal(c.RawSubject, c.RawIssuer) && c.CheckSignatureFrom(c) == nil {
+			roots.AddCert(c)
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	leaf := chain[0]
+	at := now
+	if at.Before(leaf.NotBefore) {
+		at = leaf.NotBefore
+	} else if at.After(leaf.NotAfter) {
+		at = leaf.NotAfter
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+
+	// The certificate an x509 error names is the link it is reported on
+	var failed *x509.Certificate
+	var invalid x509.CertificateInvalidError
+	var unknown x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &invalid):
+		failed = invalid.Cert
+	case errors.As(err, &unknown):
+		failed = unknown.Cert
+	}
+
+	links := make([]VerifyLink, len(chain))
+	for i, c := range chain {
+		links[i] = VerifyLink{
+			Subject:  FormatDN(c.Subject),
+			Serial:   FormatSerialBig(c.SerialNumber),
+			NotAfter: c.NotAfter,
+			Source:   "ca",
+			Status:   "OK",
+		}
+		if i == 0 {
+			links[i].Source = "certificate"
+		} else if !isIn(c, issuers) {
+			links[i].Source = "bundle"
+		}
+		if failed != nil && c.Equal(failed) {
+			links[i].Status = err.Error()
+		}
+	}
+	if err != nil {
+		return links, err.Error()
+	}
+	return links, ""
+}
+
+// isIn reports whether certs holds cert.
+func isIn(cert *x509.Certificate, certs []*x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// revocationView is what one source says about a certificate's revocation.
+type revocationView struct {
+	source  string // "CRL 4", "CRL 4 + delta 5", "index" or "OCSP"
+	revoked bool
+	reason  string
+	at      time.Time
+}
+
+func (v revocationView) String() string {
+	if !v.revoked {
+		return "not revoked"
+	}
+	return fmt.Sprintf("revoked (reason: %s, date: %s)", v.reason, v.at.UTC().Format(time.RFC3339))
+}
+
+// revocationCheck collects the sources consulted about one certificate of the chain.
+type revocationCheck struct {
+	cert        *x509.Certificate
+	views       []revocationView
+	crlStatus   string
+	crlInvalid  bool      // the CRL's signature does not verify against its generation
+	crlStale    time.Time // the next update the CRL has let pass
+	indexStatus string
+	ocspStatus  string
+}
+
+// checkRevocation looks cert up on the CRL of gen, applying the delta CRL on top of
+// it for the current generation, and in the index when store is not nil.
+func checkRevocation(cert *x509.Certificate, gen *CAGeneration, store IndexStore, now time.Time) (*revocationCheck, error) {
+	rc := &revocationCheck{cert: cert}
+	crl, err := LoadCRL(gen.crlPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		rc.crlStatus = "none" // no CRL file — does not cause failure (CON-BD-017)
+	case err != nil:
+		return nil, fmt.Errorf("failed to load CRL: %w", err)
+	default:
+		if err := crl.CheckSignatureFrom(gen.Cert); err != nil {
+			rc.crlInvalid = true
+			rc.crlStatus = fmt.Sprintf("CRL %s: signature invalid: %v", crl.Number, err)
+			break
+		}
+		view := revocationView{source: fmt.Sprintf("CRL %s", crl.Number)}
+		entry := findRevocation(crl, cert)
+		nextUpdate := crl.NextUpdate
+		if gen.Current {
+			if delta := loadDeltaCRL(gen, crl, now); delta != nil {
+				view.source += fmt.Sprintf(" + delta %s", delta.Number)
+				if e := findRevocation(delta, cert); e != nil {
+					if e.ReasonCode == 8 { // removeFromCRL: a released hold
+						entry = nil
+					} else {
+						entry = e
+					}
+				}
+				nextUpdate = delta.NextUpdate
+			}
+		}
+		if entry != nil {
+			view.revoked, view.at = true, entry.RevocationTime
+			if view.reason = ReasonNames[entry.ReasonCode]; view.reason == "" {
+				view.reason = "unspecified"
+			}
+		}
+		rc.views = append(rc.views, view)
+		freshness := fmt.Sprintf("next update %s", nextUpdate.UTC().Format(time.RFC3339))
+		if !nextUpdate.IsZero() && now.After(nextUpdate) {
+			rc.crlStale = nextUpdate
+			freshness = fmt.Sprintf("STALE since %s", nextUpdate.UTC().Format(time.RFC3339))
+		}
+		rc.crlStatus = fmt.Sprintf("%s: %s, %s", view.source, view, freshness)
+	}
+
+	if store != nil {
+		e, err := store.Get(FormatSerialBig(cert.SerialNumber))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+		switch {
+		case e == nil:
+			rc.indexStatus = "not in the index"
+		case e.Status == "revoked":
+			at, err := time.Parse(time.RFC3339, e.RevokedAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid revocation date for serial %s", e.Serial)
+			}
+			view := revocationView{source: "index", revoked: true, reason: e.RevocationReason, at: at}
+			rc.views = append(rc.views, view)
+			rc.indexStatus = view.String()
+		default:
+			view := revocationView{source: "index"}
+			rc.views = append(rc.views, view)
+			rc.indexStatus = view.String()
+		}
+	}
+	return rc, nil
+}
+
+// loadDeltaCRL returns the published delta CRL if it extends base and can be relied
+// on: signed by gen and not past its next update.
+func loadDeltaCRL(gen *CAGeneration, base *x509.RevocationList, now time.Time) *x509.RevocationList {
+	delta, err := LoadCRL(filepath.Join(gen.Dir, "ca-delta.crl"))
+	if err != nil || delta.CheckSignatureFrom(gen.Cert) != nil {
+		return nil
+	}
+	if number, ok := deltaCRLBase(delta); !ok || !base.Number.IsInt64() || number != base.Number.Int64() {
+		return nil
+	}
+	if !delta.NextUpdate.IsZero() && now.After(delta.NextUpdate) {
+		return nil
+	}
+	return delta
+}
+
+// findRevocation returns the entry for cert on crl, or nil.
+func findRevocation(crl *x509.RevocationList, cert *x509.Certificate) *x509.RevocationListEntry {
+	for i, e := range crl.RevokedCertificateEntries {
+		if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return &crl.RevokedCertificateEntries[i]
+		}
+	}
+	return nil
+}
+
+// revoked reports whether any source has the certificate revoked.
+func (rc *revocationCheck) revoked() bool {
+	for _, v := range rc.views {
+		if v.revoked {
+			return true
+		}
+	}
+	return false
+}
+
+// status sums up the sources as the revocation status of the report, with its
+// severity, so that the worst status along the chain is the one reported.
+func (rc *revocationCheck) status(intermediate bool) (string, int) {
+	which := ""
+	if intermediate {
+		which = fmt.Sprintf("intermediate %s, ", FormatSerialBig(rc.cert.SerialNumber))
+	}
+	agree := true
+	for _, v := range rc.views {
+		agree = agree && v.revoked == rc.views[0].revoked
+	}
+	switch {
+	case !agree:
+		var says []string
+		for _, v := range rc.views {
+			says = append(says, fmt.Sprintf("%s: %s", v.source, v))
+		}
+		return fmt.Sprintf("MISMATCH (%s%s)", which, strings.Join(says, "; ")), 5
+	case rc.revoked():
+		v := rc.views[0]
+		return fmt.Sprintf("REVOKED (%sreason: %s, date: %s)", which, v.reason, v.at.UTC().Format(time.RFC3339)), 6
+	case rc.crlInvalid:
+		return fmt.Sprintf("INVALID CRL (%s%s)", which, rc.crlStatus), 4
+	case !rc.crlStale.IsZero():
+		return fmt.Sprintf("STALE (%sCRL next update %s has passed; not revoked on it)", which, rc.crlStale.UTC().Format(time.RFC3339)), 3
+	case len(rc.views) == 0:
+		return "NOT CHECKED (no CRL available)", 1
+	}
+	return "OK (not revoked)", 0
+}